@@ -0,0 +1,136 @@
+// Command replay-webhook signs Stripe event fixtures and posts them to the billing
+// webhook of a locally running server, e.g.
+//
+//	go run ./cmd/replay-webhook -org <organization id> internal/fixtures/stripe/checkout_session_completed.json
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// fixtureData holds the values substituted into fixture placeholders such as {{.Customer}}
+type fixtureData struct {
+	EventId      string
+	Organization string
+	Customer     string
+	Subscription string
+	Invoice      string
+	Plan         string
+	Price        string
+	Now          int64
+	PeriodEnd    int64
+}
+
+// sign computes the Stripe-Signature header for a payload
+func sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func main() {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:8080"
+	}
+
+	url := flag.String("url", strings.TrimRight(appURL, "/")+"/api/billing/webhook", "webhook endpoint")
+	secret := flag.String("secret", os.Getenv("STRIPE_WEBHOOK_SECRET"), "webhook signing secret")
+	organization := flag.String("org", "", "organization id the events belong to (required)")
+	plan := flag.String("plan", "pro", "plan code")
+	price := flag.String("price", "", "provider price id")
+	customer := flag.String("customer", "", "customer id (default cus_local_<org>)")
+	subscription := flag.String("subscription", "", "subscription id (default sub_local_<org>)")
+	invoice := flag.String("invoice", "", "invoice id (default in_local_<org>)")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: replay-webhook -org <id> [flags] fixture.json...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *organization == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *secret == "" {
+		log.Fatal("❌ Set STRIPE_WEBHOOK_SECRET or pass -secret")
+	}
+
+	now := time.Now()
+	data := fixtureData{
+		Organization: *organization,
+		Customer:     *customer,
+		Subscription: *subscription,
+		Invoice:      *invoice,
+		Plan:         *plan,
+		Price:        *price,
+		Now:          now.Unix(),
+		PeriodEnd:    now.AddDate(0, 1, 0).Unix(),
+	}
+	if data.Customer == "" {
+		data.Customer = "cus_local_" + *organization
+	}
+	if data.Subscription == "" {
+		data.Subscription = "sub_local_" + *organization
+	}
+	if data.Invoice == "" {
+		data.Invoice = "in_local_" + *organization
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	failed := false
+
+	for i, path := range flag.Args() {
+		fixture, err := template.ParseFiles(path)
+		if err != nil {
+			log.Fatalf("❌ Failed to read fixture %s: %v", path, err)
+		}
+
+		data.EventId = fmt.Sprintf("evt_local_%d_%d", now.UnixNano(), i)
+
+		var payload bytes.Buffer
+		if err := fixture.Execute(&payload, data); err != nil {
+			log.Fatalf("❌ Failed to render fixture %s: %v", path, err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, *url, bytes.NewReader(payload.Bytes()))
+		if err != nil {
+			log.Fatalf("❌ Invalid webhook URL: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Stripe-Signature", sign(*secret, time.Now().Unix(), payload.Bytes()))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Fatalf("❌ Failed to send %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			failed = true
+			log.Printf("❌ %s: %s %s", filepath.Base(path), resp.Status, strings.TrimSpace(string(body)))
+			continue
+		}
+		log.Printf("✅ %s: %s", filepath.Base(path), resp.Status)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
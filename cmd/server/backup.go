@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem/blob"
+	"github.com/pocketbase/pocketbase/tools/hook"
+	"github.com/spf13/cobra"
+)
+
+// newBackupCommand creates the commands that back up and restore the data directory
+func newBackupCommand(pb *pocketbase.PocketBase) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up and restore the data directory",
+	}
+
+	create := &cobra.Command{
+		Use:          "create [NAME]",
+		Short:        "Create a backup ZIP of the data directory, named after the current time by default",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			if err := pb.CreateBackup(context.Background(), name); err != nil {
+				return fmt.Errorf("failed to create backup: %w", err)
+			}
+
+			fmt.Println("Backup created")
+			return nil
+		},
+	}
+
+	list := &cobra.Command{
+		Use:          "list",
+		Short:        "List backups, newest first",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			fsys, err := pb.NewBackupsFilesystem()
+			if err != nil {
+				return err
+			}
+			defer fsys.Close()
+
+			files, err := fsys.List("")
+			if err != nil {
+				return fmt.Errorf("failed to list backups: %w", err)
+			}
+
+			slices.SortFunc(files, func(a, b *blob.ListObject) int {
+				return b.ModTime.Compare(a.ModTime)
+			})
+
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "NAME\tSIZE\tMODIFIED")
+			for _, file := range files {
+				fmt.Fprintf(table, "%s\t%d\t%s\n", file.Key, file.Size, file.ModTime.Format("2006-01-02 15:04"))
+			}
+			return table.Flush()
+		},
+	}
+
+	restore := &cobra.Command{
+		Use:          "restore NAME",
+		Short:        "Replace the data directory with a backup. Stop the server first.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			// PocketBase restarts the process to load the restored data, which would run
+			// this command again, so stop the restart before any cleanup runs and exit instead
+			pb.OnTerminate().Bind(&hook.Handler[*core.TerminateEvent]{
+				Func: func(e *core.TerminateEvent) error {
+					if e.IsRestart {
+						return nil
+					}
+					return e.Next()
+				},
+				Priority: -99999,
+			})
+
+			if err := pb.RestoreBackup(context.Background(), args[0]); err != nil {
+				return fmt.Errorf("failed to restore backup: %w", err)
+			}
+
+			fmt.Printf("Restored %s\n", args[0])
+			return nil
+		},
+	}
+
+	command.AddCommand(create, list, restore)
+
+	return command
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/pocketbase/pocketbase"
-	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/cmd"
+	"github.com/pocketbase/pocketbase/plugins/migratecmd"
+	"github.com/spf13/cobra"
 	"github.com/yourusername/go-saas-template/internal/auth"
+	_ "github.com/yourusername/go-saas-template/migrations"
 )
 
+// Whether the app runs in production, set from APP_ENV
+var production bool
+
 // loggingMiddleware logs information about incoming HTTP requests
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +32,118 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// configureMailer applies SMTP settings from the environment so outgoing
+// emails can be delivered through a real relay or a local capture server
+// (e.g. Mailpit on localhost:1025) without touching the admin UI
+func configureMailer(pb *pocketbase.PocketBase) {
+	settings := pb.Settings()
+
+	if sender := os.Getenv("MAIL_SENDER_ADDRESS"); sender != "" {
+		settings.Meta.SenderAddress = sender
+	}
+	if name := os.Getenv("MAIL_SENDER_NAME"); name != "" {
+		settings.Meta.SenderName = name
+	}
+
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return
+	}
+
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+
+	settings.SMTP.Enabled = true
+	settings.SMTP.Host = host
+	settings.SMTP.Port = port
+	settings.SMTP.Username = os.Getenv("SMTP_USERNAME")
+	settings.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+	settings.SMTP.TLS = os.Getenv("SMTP_TLS") == "true"
+
+	log.Printf("📧 Sending email through SMTP server %s:%d", host, port)
+}
+
+// configurePasswordPolicy overrides the default password rules from environment variables
+func configurePasswordPolicy() {
+	policy := &auth.PasswordRules
+
+	if minLength, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil {
+		policy.MinLength = minLength
+	}
+	if maxLength, err := strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH")); err == nil {
+		policy.MaxLength = maxLength
+	}
+
+	flags := map[string]*bool{
+		"PASSWORD_REQUIRE_UPPER":  &policy.RequireUpper,
+		"PASSWORD_REQUIRE_LOWER":  &policy.RequireLower,
+		"PASSWORD_REQUIRE_DIGIT":  &policy.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL": &policy.RequireSymbol,
+		"PASSWORD_DISALLOW_EMAIL": &policy.DisallowEmail,
+		"PASSWORD_REJECT_COMMON":  &policy.RejectCommon,
+	}
+	for name, flag := range flags {
+		if value, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+			*flag = value
+		}
+	}
+}
+
+// configureAppURL sets the public base URL used for links in outgoing emails,
+// defaulting to localhost on the port the server listens on
+func configureAppURL(port string) {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port
+	}
+	auth.AppURL = strings.TrimRight(appURL, "/")
+
+	// Only send cookies over HTTPS when the app is served over HTTPS
+	auth.SecureCookies = strings.HasPrefix(auth.AppURL, "https://")
+}
+
+// prepareApp applies the settings from the environment, brings the collections up to
+// date and seeds default data. Commands that use the app's data run it first.
+func prepareApp(pb *pocketbase.PocketBase) error {
+	configureMailer(pb)
+
+	// Create or update the collections the app depends on and fill in default data
+	if err := applyMigrations(pb); err != nil {
+		return err
+	}
+	if err := seedDefaults(pb); err != nil {
+		return err
+	}
+
+	// Register social login providers from the environment
+	if err := auth.ConfigureOAuth2Providers(pb); err != nil {
+		return fmt.Errorf("failed to configure OAuth2 providers: %w", err)
+	}
+
+	// Set up the payment provider and plan prices from the environment
+	if err := auth.ConfigureBilling(pb); err != nil {
+		return fmt.Errorf("failed to configure billing: %w", err)
+	}
+
+	return nil
+}
+
+// trackErrors stores the error the command or one of its subcommands fails with
+func trackErrors(command *cobra.Command, commandErr *error) {
+	if run := command.RunE; run != nil {
+		command.RunE = func(command *cobra.Command, args []string) error {
+			*commandErr = run(command, args)
+			return *commandErr
+		}
+	}
+
+	for _, subcommand := range command.Commands() {
+		trackErrors(subcommand, commandErr)
+	}
+}
+
 func main() {
 	// Get port from environment variable or use default
 	port := os.Getenv("PORT")
@@ -44,60 +163,58 @@ func main() {
 		}
 	}
 
-	log.Printf("📁 Using PocketBase data directory: %s", filepath.Clean(pbDataDir))
-
-	// Check if this is a fresh installation
-	if _, err := os.Stat(filepath.Join(pbDataDir, "data.db")); os.IsNotExist(err) {
-		log.Println("🆕 Fresh installation detected, initializing default data...")
-		// Initialize your default collections, users, etc.
-	}
+	// Production deployments apply migrations before starting the server
+	production = os.Getenv("APP_ENV") == "production"
 
-	// Create a new PocketBase app
-	pb := pocketbase.New()
+	// Create a new PocketBase app. The --dir flag overrides PB_DATA_DIR.
+	pb := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: pbDataDir})
+	pb.RootCmd.Short = "SaaS template server and operations commands"
+	pb.RootCmd.SilenceUsage = true
 
 	// Set the app as the global PbClient
 	auth.PbClient = pb
 
-	// Add a hook to log when PocketBase is initialized
-	// Use OnServe().BindFunc() instead of OnServe().Add() as per documentation
-	pb.OnServe().BindFunc(func(e *core.ServeEvent) error {
-		log.Println("🔐 PocketBase initialized successfully")
-		return e.Next()
-	})
-
-	// Initialize PocketBase (without starting the server)
-	if err := pb.Bootstrap(); err != nil {
-		log.Fatalf("❌ Failed to initialize PocketBase: %v", err)
+	configureAppURL(port)
+	configurePasswordPolicy()
+
+	// Optionally block unverified accounts from protected routes
+	auth.RequireVerifiedEmail = os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+
+	// Only allow webhooks to localhost and private networks when developing receivers locally
+	auth.WebhookAllowPrivateNetworks = os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+
+	// Keep usage counters up to date, keep the audit log append-only, queue webhooks
+	// and route account changes made through PocketBase's record API to the app's own
+	// flows, whichever command changes the data
+	auth.RegisterUsageHooks(pb)
+	auth.RegisterAuditHooks(pb)
+	auth.RegisterWebhookHooks(pb)
+	auth.RegisterRecordAPIGuards(pb)
+
+	serve := newServeCommand(pb, port)
+	pb.RootCmd.AddCommand(
+		serve,
+		newUsersCommand(pb),
+		newSeedCommand(pb),
+		newBackupCommand(pb),
+		newRoutesCommand(),
+		cmd.NewSuperuserCommand(pb),
+	)
+
+	// migrate up, down, create, collections and history-sync
+	migratecmd.MustRegister(pb, pb.RootCmd, migratecmd.Config{Dir: "migrations"})
+
+	// Running the binary without a command starts the server
+	pb.RootCmd.RunE = serve.RunE
+
+	// PocketBase prints the error a command fails with but exits successfully
+	var commandErr error
+	trackErrors(pb.RootCmd, &commandErr)
+
+	if err := pb.Execute(); err != nil {
+		log.Fatal(err)
 	}
-
-	r := mux.NewRouter()
-
-	// Apply logging middleware to all routes
-	r.Use(loggingMiddleware)
-
-	// Auth routes - these don't require authentication
-	authRouter := r.PathPrefix("/auth").Subrouter()
-	authRouter.HandleFunc("/login", auth.LoginHandler).Methods("GET", "POST")
-	authRouter.HandleFunc("/register", auth.RegisterHandler).Methods("GET", "POST")
-	authRouter.HandleFunc("/logout", auth.LogoutHandler).Methods("GET")
-	authRouter.HandleFunc("/forgot-password", auth.ForgotPasswordHandler).Methods("GET", "POST")
-	authRouter.HandleFunc("/reset-password", auth.ResetPasswordHandler).Methods("GET", "POST")
-
-	// PocketBase auth API forwarding
-	apiRouter := r.PathPrefix("/api").Subrouter()
-	apiRouter.HandleFunc("/auth/{action}", auth.PocketBaseAuthHandler).Methods("POST")
-
-	// Protected routes - require authentication
-	protectedRouter := r.PathPrefix("/").Subrouter()
-	protectedRouter.Use(auth.AuthMiddleware)
-
-	// Dashboard/Home page (protected)
-	protectedRouter.HandleFunc("/", auth.HomeRenderer)
-
-	log.Printf("🚀 Starting HTTP server on port %s (http://localhost:%s)", port, port)
-	log.Println("Press Ctrl+C to stop the server")
-
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatalf("❌ Failed to start web server on port %s: %v", port, err)
+	if commandErr != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// pendingMigrations returns the app migrations that haven't been applied yet
+func pendingMigrations(pb *pocketbase.PocketBase) ([]string, error) {
+	applied := []string{}
+	if err := pb.DB().Select("file").From(core.DefaultMigrationsTable).Column(&applied); err != nil {
+		return nil, err
+	}
+
+	pending := []string{}
+	for _, migration := range core.AppMigrations.Items() {
+		if !slices.Contains(applied, migration.File) {
+			pending = append(pending, migration.File)
+		}
+	}
+
+	return pending, nil
+}
+
+// applyMigrations brings the collections up to date. In production migrations are
+// applied explicitly with "migrate up", so it refuses to continue with pending ones.
+func applyMigrations(pb *pocketbase.PocketBase) error {
+	pending, err := pendingMigrations(pb)
+	if err != nil {
+		return fmt.Errorf("failed to check migrations: %w", err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if production {
+		return fmt.Errorf("%d pending migration(s): %s. Run \"migrate up\" first", len(pending), strings.Join(pending, ", "))
+	}
+
+	if _, err := pb.FindCollectionByNameOrId("sessions"); err != nil {
+		log.Println("🆕 Fresh installation detected, creating collections...")
+	} else {
+		log.Printf("🗂️ Applying %d pending migration(s)", len(pending))
+	}
+
+	if err := pb.RunAppMigrations(); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	return nil
+}
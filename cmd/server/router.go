@@ -0,0 +1,227 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/yourusername/go-saas-template/internal/auth"
+)
+
+// newRouter builds the app's routes
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	// Reject cross-site form submissions
+	r.Use(auth.CSRFMiddleware)
+
+	// Auth routes - these don't require authentication
+	authRouter := r.PathPrefix("/auth").Subrouter()
+	authRouter.HandleFunc("/login", auth.LoginHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/login/2fa", auth.TwoFactorLoginHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/register", auth.RegisterHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/logout", auth.LogoutHandler).Methods("POST")
+	authRouter.HandleFunc("/oauth2/{provider}", auth.OAuth2LoginHandler).Methods("GET")
+	authRouter.HandleFunc("/oauth2/{provider}/callback", auth.OAuth2CallbackHandler).Methods("GET")
+	authRouter.HandleFunc("/magic-link", auth.MagicLinkHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/magic-link/verify", auth.MagicLinkVerifyHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/forgot-password", auth.ForgotPasswordHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/reset-password", auth.ResetPasswordHandler).Methods("GET", "POST")
+	authRouter.HandleFunc("/verify", auth.VerifyEmailHandler).Methods("GET")
+	authRouter.HandleFunc("/verify/resend", auth.ResendVerificationHandler).Methods("POST")
+
+	// Invitation links work both logged in and logged out
+	r.HandleFunc("/invitations/accept", auth.AcceptInvitationHandler).Methods("GET", "POST")
+	r.HandleFunc("/api/invitations/accept", auth.AcceptInvitationHandler).Methods("POST")
+
+	// Email change and account deletion links are opened from an inbox, possibly in another browser
+	r.HandleFunc("/settings/email/confirm", auth.ConfirmEmailChangeHandler).Methods("GET")
+	r.HandleFunc("/settings/delete/confirm", auth.ConfirmAccountDeletionHandler).Methods("GET")
+
+	// Payment provider webhooks, authenticated by their signature
+	r.HandleFunc("/api/billing/webhook", auth.BillingWebhookHandler).Methods("POST")
+
+	// PocketBase auth API forwarding
+	apiRouter := r.PathPrefix("/api").Subrouter()
+	apiRouter.HandleFunc("/auth/{action}", auth.PocketBaseAuthHandler).Methods("POST")
+
+	// Protected API routes - accept bearer tokens and respond with JSON
+	apiProtectedRouter := apiRouter.NewRoute().Subrouter()
+	apiProtectedRouter.Use(auth.AuthMiddleware)
+	apiProtectedRouter.Use(auth.OrganizationMiddleware)
+
+	// Account, security and billing routes stay out of the API call quota, so an
+	// organization that used it up can still sign out sessions, export or delete
+	// data, revoke tokens and upgrade
+	apiProtectedRouter.HandleFunc("/organizations/members/{id}/transfer", auth.TransferOwnershipHandler).Methods("POST")
+	apiProtectedRouter.HandleFunc("/entitlements", auth.EntitlementsHandler).Methods("GET")
+	apiProtectedRouter.HandleFunc("/usage", auth.UsageHandler).Methods("GET")
+
+	// Account routes need a signed-in session, API tokens are refused
+	apiAccountRouter := apiProtectedRouter.NewRoute().Subrouter()
+	apiAccountRouter.Use(auth.SessionOnlyMiddleware)
+	apiAccountRouter.HandleFunc("/sessions", auth.SessionsHandler).Methods("GET")
+	apiAccountRouter.HandleFunc("/sessions", auth.RevokeAllSessionsHandler).Methods("DELETE")
+	apiAccountRouter.HandleFunc("/sessions/{id}", auth.RevokeSessionHandler).Methods("DELETE")
+	apiAccountRouter.HandleFunc("/profile", auth.ProfileHandler).Methods("GET", "POST")
+	apiAccountRouter.HandleFunc("/settings", auth.SettingsHandler).Methods("GET")
+	apiAccountRouter.HandleFunc("/settings/password", auth.ChangePasswordHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/settings/email", auth.RequestEmailChangeHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/settings/export", auth.RequestDataExportHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/settings/export/{id}/download", auth.DownloadDataExportHandler).Methods("GET")
+	apiAccountRouter.HandleFunc("/settings/delete", auth.RequestAccountDeletionHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/settings/delete/cancel", auth.CancelAccountDeletionHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/tokens", auth.APITokensHandler).Methods("GET")
+	apiAccountRouter.HandleFunc("/tokens", auth.CreatePersonalTokenHandler).Methods("POST")
+	apiAccountRouter.HandleFunc("/tokens/{id}", auth.RevokePersonalTokenHandler).Methods("DELETE")
+
+	apiBillingRouter := apiProtectedRouter.PathPrefix("/billing").Subrouter()
+	apiBillingRouter.Use(auth.RequirePermission(auth.PermissionBillingView))
+	apiBillingRouter.HandleFunc("", auth.BillingHandler).Methods("GET")
+
+	apiBillingManageRouter := apiProtectedRouter.PathPrefix("/billing").Subrouter()
+	apiBillingManageRouter.Use(auth.RequirePermission(auth.PermissionBillingManage))
+	apiBillingManageRouter.HandleFunc("/checkout", auth.CheckoutHandler).Methods("POST")
+	apiBillingManageRouter.HandleFunc("/portal", auth.BillingPortalHandler).Methods("POST")
+
+	// Product API routes - count against the organization's API call quota
+	apiMeteredRouter := apiProtectedRouter.NewRoute().Subrouter()
+	apiMeteredRouter.Use(auth.EnforceQuota(auth.LimitAPICalls, auth.HardQuota))
+	apiMeteredRouter.Use(auth.MeterAPICalls)
+	apiMeteredRouter.HandleFunc("/organizations", auth.OrganizationsHandler).Methods("GET")
+	apiMeteredRouter.HandleFunc("/organizations", auth.CreateOrganizationHandler).Methods("POST")
+
+	apiKeysRouter := apiMeteredRouter.PathPrefix("/api-keys").Subrouter()
+	apiKeysRouter.Use(auth.RequirePermission(auth.PermissionAPIKeysManage))
+	apiKeysRouter.HandleFunc("", auth.CreateOrganizationKeyHandler).Methods("POST")
+	apiKeysRouter.HandleFunc("/{id}", auth.RevokeOrganizationKeyHandler).Methods("DELETE")
+
+	// Member management requires the members:manage permission
+	apiMembersRouter := apiMeteredRouter.PathPrefix("/invitations").Subrouter()
+	apiMembersRouter.Use(auth.RequirePermission(auth.PermissionMembersManage))
+	apiMembersRouter.HandleFunc("", auth.InvitationsHandler).Methods("GET")
+	apiMembersRouter.HandleFunc("", auth.CreateInvitationHandler).Methods("POST")
+	apiMembersRouter.HandleFunc("/{id}/resend", auth.ResendInvitationHandler).Methods("POST")
+	apiMembersRouter.HandleFunc("/{id}", auth.RevokeInvitationHandler).Methods("DELETE")
+
+	apiMemberManageRouter := apiMeteredRouter.PathPrefix("/organizations/members").Subrouter()
+	apiMemberManageRouter.Use(auth.RequirePermission(auth.PermissionMembersManage))
+	apiMemberManageRouter.HandleFunc("/{id}", auth.RemoveMemberHandler).Methods("DELETE")
+	apiMemberManageRouter.HandleFunc("/{id}/role", auth.ChangeMemberRoleHandler).Methods("POST")
+
+	apiAuditRouter := apiMeteredRouter.PathPrefix("/audit").Subrouter()
+	apiAuditRouter.Use(auth.RequirePermission(auth.PermissionAuditView))
+	apiAuditRouter.Use(auth.RequireFeature(auth.FeatureAuditLog))
+	apiAuditRouter.HandleFunc("", auth.AuditLogHandler).Methods("GET")
+	apiAuditRouter.HandleFunc("/export", auth.AuditExportHandler).Methods("GET")
+
+	apiWebhooksRouter := apiMeteredRouter.PathPrefix("/webhooks").Subrouter()
+	apiWebhooksRouter.Use(auth.RequirePermission(auth.PermissionWebhooksManage))
+	apiWebhooksRouter.Use(auth.RequireFeature(auth.FeatureWebhooks))
+	apiWebhooksRouter.HandleFunc("", auth.WebhooksHandler).Methods("GET")
+	apiWebhooksRouter.HandleFunc("", auth.CreateWebhookHandler).Methods("POST")
+	apiWebhooksRouter.HandleFunc("/{id}", auth.DeleteWebhookHandler).Methods("DELETE")
+	apiWebhooksRouter.HandleFunc("/{id}/ping", auth.PingWebhookHandler).Methods("POST")
+	apiWebhooksRouter.HandleFunc("/deliveries/{id}/redeliver", auth.RedeliverWebhookHandler).Methods("POST")
+
+	// Admin API routes - require a PocketBase superuser
+	adminRouter := apiRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(auth.SuperuserMiddleware)
+	adminRouter.HandleFunc("/unlock", auth.UnlockLoginHandler).Methods("POST")
+
+	// Protected routes - require authentication
+	protectedRouter := r.PathPrefix("/").Subrouter()
+	protectedRouter.Use(auth.AuthMiddleware)
+	protectedRouter.Use(auth.OrganizationMiddleware)
+
+	// Dashboard/Home page (protected)
+	protectedRouter.HandleFunc("/", auth.HomeRenderer)
+
+	// Organization-scoped pages, e.g. /org/acme/
+	orgRouter := protectedRouter.PathPrefix("/org/{org}").Subrouter()
+	orgRouter.HandleFunc("/", auth.HomeRenderer)
+
+	// Organizations
+	protectedRouter.HandleFunc("/organizations", auth.OrganizationsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/organizations", auth.CreateOrganizationHandler).Methods("POST")
+	protectedRouter.HandleFunc("/organizations/{id}/switch", auth.SwitchOrganizationHandler).Methods("POST")
+	protectedRouter.HandleFunc("/organizations/members/{id}/transfer", auth.TransferOwnershipHandler).Methods("POST")
+
+	membersRouter := protectedRouter.PathPrefix("/organizations/invitations").Subrouter()
+	membersRouter.Use(auth.RequirePermission(auth.PermissionMembersManage))
+	membersRouter.HandleFunc("", auth.CreateInvitationHandler).Methods("POST")
+	membersRouter.HandleFunc("/{id}/resend", auth.ResendInvitationHandler).Methods("POST")
+	membersRouter.HandleFunc("/{id}/revoke", auth.RevokeInvitationHandler).Methods("POST")
+
+	memberManageRouter := protectedRouter.PathPrefix("/organizations/members").Subrouter()
+	memberManageRouter.Use(auth.RequirePermission(auth.PermissionMembersManage))
+	memberManageRouter.HandleFunc("/{id}/remove", auth.RemoveMemberHandler).Methods("POST")
+	memberManageRouter.HandleFunc("/{id}/role", auth.ChangeMemberRoleHandler).Methods("POST")
+
+	// Billing
+	billingRouter := protectedRouter.PathPrefix("/billing").Subrouter()
+	billingRouter.Use(auth.RequirePermission(auth.PermissionBillingView))
+	billingRouter.HandleFunc("", auth.BillingHandler).Methods("GET")
+
+	billingManageRouter := protectedRouter.PathPrefix("/billing").Subrouter()
+	billingManageRouter.Use(auth.RequirePermission(auth.PermissionBillingManage))
+	billingManageRouter.HandleFunc("/checkout", auth.CheckoutHandler).Methods("POST")
+	billingManageRouter.HandleFunc("/portal", auth.BillingPortalHandler).Methods("POST")
+
+	// Audit log - admins on plans with the audit_log feature
+	auditRouter := protectedRouter.PathPrefix("/audit").Subrouter()
+	auditRouter.Use(auth.RequirePermission(auth.PermissionAuditView))
+	auditRouter.Use(auth.RequireFeature(auth.FeatureAuditLog))
+	auditRouter.HandleFunc("", auth.AuditLogHandler).Methods("GET")
+	auditRouter.HandleFunc("/export", auth.AuditExportHandler).Methods("GET")
+
+	// Webhooks - admins on plans with the webhooks feature
+	webhooksRouter := protectedRouter.PathPrefix("/webhooks").Subrouter()
+	webhooksRouter.Use(auth.RequirePermission(auth.PermissionWebhooksManage))
+	webhooksRouter.Use(auth.RequireFeature(auth.FeatureWebhooks))
+	webhooksRouter.HandleFunc("", auth.WebhooksHandler).Methods("GET")
+	webhooksRouter.HandleFunc("", auth.CreateWebhookHandler).Methods("POST")
+	webhooksRouter.HandleFunc("/{id}/delete", auth.DeleteWebhookHandler).Methods("POST")
+	webhooksRouter.HandleFunc("/{id}/ping", auth.PingWebhookHandler).Methods("POST")
+	webhooksRouter.HandleFunc("/deliveries/{id}/redeliver", auth.RedeliverWebhookHandler).Methods("POST")
+
+	// Avatars
+	protectedRouter.HandleFunc("/avatars/{user}/{filename}", auth.AvatarHandler).Methods("GET")
+
+	// Account pages need a signed-in session, API tokens are refused
+	accountRouter := protectedRouter.NewRoute().Subrouter()
+	accountRouter.Use(auth.SessionOnlyMiddleware)
+
+	// Session management
+	accountRouter.HandleFunc("/sessions", auth.SessionsHandler).Methods("GET")
+	accountRouter.HandleFunc("/sessions/revoke-all", auth.RevokeAllSessionsHandler).Methods("POST")
+	accountRouter.HandleFunc("/sessions/{id}/revoke", auth.RevokeSessionHandler).Methods("POST")
+
+	// Account settings
+	accountRouter.HandleFunc("/settings", auth.SettingsHandler).Methods("GET")
+	accountRouter.HandleFunc("/settings/password", auth.ChangePasswordHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/email", auth.RequestEmailChangeHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/export", auth.RequestDataExportHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/export/{id}/download", auth.DownloadDataExportHandler).Methods("GET")
+	accountRouter.HandleFunc("/settings/delete", auth.RequestAccountDeletionHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/delete/cancel", auth.CancelAccountDeletionHandler).Methods("POST")
+
+	// Profile
+	accountRouter.HandleFunc("/profile", auth.ProfileHandler).Methods("GET", "POST")
+
+	// Personal access tokens and organization API keys
+	accountRouter.HandleFunc("/settings/tokens", auth.APITokensHandler).Methods("GET")
+	accountRouter.HandleFunc("/settings/tokens", auth.CreatePersonalTokenHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/tokens/{id}/revoke", auth.RevokePersonalTokenHandler).Methods("POST")
+
+	keysRouter := accountRouter.PathPrefix("/settings/api-keys").Subrouter()
+	keysRouter.Use(auth.RequirePermission(auth.PermissionAPIKeysManage))
+	keysRouter.HandleFunc("", auth.CreateOrganizationKeyHandler).Methods("POST")
+	keysRouter.HandleFunc("/{id}/revoke", auth.RevokeOrganizationKeyHandler).Methods("POST")
+
+	// Two-factor authentication settings
+	accountRouter.HandleFunc("/settings/2fa", auth.TwoFactorSettingsHandler).Methods("GET")
+	accountRouter.HandleFunc("/settings/2fa/setup", auth.TwoFactorSetupHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/2fa/enable", auth.TwoFactorEnableHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/2fa/disable", auth.TwoFactorDisableHandler).Methods("POST")
+	accountRouter.HandleFunc("/settings/2fa/recovery-codes", auth.TwoFactorRecoveryCodesHandler).Methods("POST")
+
+	return r
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/cobra"
+)
+
+// newRoutesCommand creates the command that prints the app's route table
+func newRoutesCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "routes",
+		Short:        "Print the app's routes",
+		Long:         "Print the app's routes in the order they are matched. Paths served by PocketBase, such as /_/ and /api/collections/, are not included.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "METHODS\tPATH")
+
+			err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+				// Subrouters and path prefixes without a handler aren't routes of their own
+				if route.GetHandler() == nil {
+					return nil
+				}
+
+				path, err := route.GetPathTemplate()
+				if err != nil {
+					return nil
+				}
+
+				methods, err := route.GetMethods()
+				if err != nil {
+					methods = []string{"ANY"}
+				}
+
+				fmt.Fprintf(table, "%s\t%s\n", strings.Join(methods, ","), path)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			return table.Flush()
+		},
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+	"github.com/yourusername/go-saas-template/internal/auth"
+)
+
+// seedDefaults creates the default plans that are missing and the superuser set by
+// PB_SUPERUSER_EMAIL and PB_SUPERUSER_PASSWORD. Running it again changes nothing.
+func seedDefaults(pb *pocketbase.PocketBase) error {
+	created, err := auth.SeedPlans(pb)
+	if err != nil {
+		return fmt.Errorf("failed to seed plans: %w", err)
+	}
+	if created > 0 {
+		log.Printf("🌱 Created %d default plan(s)", created)
+	}
+
+	email := os.Getenv("PB_SUPERUSER_EMAIL")
+	password := os.Getenv("PB_SUPERUSER_PASSWORD")
+	if email == "" || password == "" {
+		return nil
+	}
+
+	added, err := auth.EnsureSuperuser(pb, email, password)
+	if err != nil {
+		return fmt.Errorf("failed to create superuser: %w", err)
+	}
+	if added {
+		log.Printf("🔑 Created superuser %s", email)
+	}
+
+	return nil
+}
+
+// newSeedCommand creates the command that fills in default data
+func newSeedCommand(pb *pocketbase.PocketBase) *cobra.Command {
+	var email string
+	var password string
+
+	command := &cobra.Command{
+		Use:          "seed",
+		Short:        "Seed default plans, the superuser from the environment and optionally a demo user",
+		Long:         "Seed the default plans that are missing and the superuser set by PB_SUPERUSER_EMAIL and PB_SUPERUSER_PASSWORD, which the server also does on every start, and optionally a verified demo user. Existing records are left alone.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			if email != "" && password == "" {
+				return errors.New("--password is required with --email")
+			}
+
+			if err := prepareApp(pb); err != nil {
+				return err
+			}
+			fmt.Println("Default data is up to date")
+
+			if email == "" {
+				return nil
+			}
+
+			user, err := auth.CreateUser(email, password, "Demo User", true)
+			if errors.Is(err, auth.ErrUserExists) {
+				fmt.Printf("User %s already exists\n", email)
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create demo user: %w", err)
+			}
+
+			fmt.Printf("Created demo user %s (%s)\n", user.Email(), user.Id)
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&email, "email", "", "email of a verified demo user to create")
+	command.Flags().StringVar(&password, "password", "", "password of the demo user")
+
+	return command
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+	"github.com/yourusername/go-saas-template/internal/auth"
+)
+
+// newServeCommand creates the command that starts the web server
+func newServeCommand(pb *pocketbase.PocketBase, port string) *cobra.Command {
+	var httpAddr string
+
+	command := &cobra.Command{
+		Use:          "serve",
+		Short:        "Start the web server (the default when no command is given)",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			// Email links point at the port actually listened on
+			if _, listenPort, err := net.SplitHostPort(httpAddr); err == nil && listenPort != port {
+				configureAppURL(listenPort)
+			}
+
+			if err := prepareApp(pb); err != nil {
+				return err
+			}
+
+			// Meter usage, send queued webhooks and build exports and delete accounts in
+			// the background until shutdown
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			auth.StartUsageMeter(ctx)
+			auth.StartWebhookWorker(ctx)
+			auth.StartAccountWorker(ctx)
+
+			// PocketBase stops the server on Ctrl+C, SIGTERM and restarts after restoring a backup
+			pb.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+				log.Println("🛑 Shutting down")
+				stop()
+
+				// Write usage recorded since the last flush
+				if err := auth.FlushUsage(); err != nil {
+					log.Printf("⚠️ Failed to write usage: %v", err)
+				}
+
+				return e.Next()
+			})
+
+			r := newRouter()
+
+			// Serve the app's routes next to PocketBase's REST API, realtime and dashboard.
+			// PocketBase builds its router when the server starts, so wrap it after the other serve hooks ran.
+			pb.OnServe().BindFunc(func(e *core.ServeEvent) error {
+				if err := e.Next(); err != nil {
+					return err
+				}
+				e.Server.Handler = loggingMiddleware(auth.MountPocketBase(r, e.Server.Handler))
+
+				log.Println("🔐 PocketBase initialized successfully")
+				log.Printf("🚀 Starting HTTP server on %s (%s)", httpAddr, auth.AppURL)
+				log.Printf("🛠️ PocketBase dashboard at %s/_/", auth.AppURL)
+				log.Println("Press Ctrl+C to stop the server")
+				return nil
+			})
+
+			err := apis.Serve(pb, apis.ServeConfig{HttpAddr: httpAddr})
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+	}
+
+	command.Flags().StringVar(&httpAddr, "http", "0.0.0.0:"+port, "TCP address to listen on")
+
+	return command
+}
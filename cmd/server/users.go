@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/spf13/cobra"
+	"github.com/yourusername/go-saas-template/internal/auth"
+	"golang.org/x/term"
+)
+
+// readPassword reads a password from the first line of stdin, or prompts for it twice
+// without echo when stdin is a terminal. Passwords are never taken as arguments, where
+// they would end up in the shell history and the process list.
+func readPassword(command *cobra.Command, fromStdin bool) (string, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(command.InOrStdin()).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read the password from stdin: %w", err)
+		}
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", errors.New("no password on stdin")
+		}
+		return password, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal, pipe the password in with --password-stdin")
+	}
+
+	prompt := func(label string) (string, error) {
+		fmt.Fprint(os.Stderr, label)
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	password, err := prompt("Password: ")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the password: %w", err)
+	}
+	confirmation, err := prompt("Confirm password: ")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the password: %w", err)
+	}
+	if password != confirmation {
+		return "", errors.New("passwords do not match")
+	}
+
+	return password, nil
+}
+
+// newUsersCommand creates the commands that manage app users
+func newUsersCommand(pb *pocketbase.PocketBase) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "users",
+		Short: "Manage app users",
+		// Every subcommand works on the app's collections
+		PersistentPreRunE: func(command *cobra.Command, args []string) error {
+			return prepareApp(pb)
+		},
+	}
+
+	var name string
+	var verified bool
+	var passwordStdin bool
+	create := &cobra.Command{
+		Use:          "create EMAIL",
+		Short:        "Create a user, bypassing registration and the verification email",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			password, err := readPassword(command, passwordStdin)
+			if err != nil {
+				return err
+			}
+
+			user, err := auth.CreateUser(args[0], password, name, verified)
+			if err != nil {
+				return fmt.Errorf("failed to create user: %w", err)
+			}
+
+			fmt.Printf("Created user %s (%s)\n", user.Email(), user.Id)
+			return nil
+		},
+	}
+	create.Flags().StringVar(&name, "name", "", "display name")
+	create.Flags().BoolVar(&verified, "verified", false, "mark the email address as verified")
+	create.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from stdin instead of prompting")
+
+	list := &cobra.Command{
+		Use:          "list",
+		Short:        "List users, oldest first",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			users, err := pb.FindRecordsByFilter("users", "", "created", 0, 0)
+			if err != nil {
+				return fmt.Errorf("failed to list users: %w", err)
+			}
+
+			table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "ID\tEMAIL\tNAME\tVERIFIED\tCREATED")
+			for _, user := range users {
+				fmt.Fprintf(table, "%s\t%s\t%s\t%t\t%s\n",
+					user.Id,
+					user.Email(),
+					user.GetString("name"),
+					user.Verified(),
+					user.GetDateTime("created").Time().Format("2006-01-02 15:04"),
+				)
+			}
+			return table.Flush()
+		},
+	}
+
+	setPassword := &cobra.Command{
+		Use:          "set-password EMAIL",
+		Short:        "Replace a user's password, sign them out everywhere and lift their lockout",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			password, err := readPassword(command, passwordStdin)
+			if err != nil {
+				return err
+			}
+
+			if err := auth.SetUserPassword(args[0], password); err != nil {
+				return fmt.Errorf("failed to set password: %w", err)
+			}
+
+			fmt.Printf("Changed the password of %s\n", args[0])
+			return nil
+		},
+	}
+
+	setPassword.Flags().BoolVar(&passwordStdin, "password-stdin", false, "read the password from stdin instead of prompting")
+
+	del := &cobra.Command{
+		Use:          "delete EMAIL",
+		Short:        "Delete a user right away, skipping the deletion grace period",
+		Long:         "Delete a user right away, skipping the deletion grace period. Organizations the user was the only member of are deleted with them and shared organizations they were the only owner of pass to another member.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			err := auth.DeleteUser(args[0])
+			if errors.Is(err, auth.ErrDeletionBlocked) {
+				return errors.New("an organization only this user belongs to still has an active subscription; cancel it first")
+			}
+			if err != nil {
+				return fmt.Errorf("failed to delete user: %w", err)
+			}
+
+			fmt.Printf("Deleted user %s\n", args[0])
+			return nil
+		},
+	}
+
+	command.AddCommand(create, list, setPassword, del)
+
+	return command
+}
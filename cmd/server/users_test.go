@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func TestReadPasswordFromStdin(t *testing.T) {
+	read := func(input string) (string, error) {
+		command := &cobra.Command{}
+		command.SetIn(strings.NewReader(input))
+		return readPassword(command, true)
+	}
+
+	// Only the first line is the password, whatever the line ending
+	for input, want := range map[string]string{
+		"Corr3ct-Horse-Battery!\n":         "Corr3ct-Horse-Battery!",
+		"Corr3ct-Horse-Battery!\r\nnext\n": "Corr3ct-Horse-Battery!",
+		"Corr3ct-Horse-Battery!":           "Corr3ct-Horse-Battery!",
+		" spaced password \n":              " spaced password ",
+	} {
+		if password, err := read(input); err != nil || password != want {
+			t.Errorf("stdin %q: got %q, %v", input, password, err)
+		}
+	}
+
+	for _, input := range []string{"", "\n", "\r\n"} {
+		if _, err := read(input); err == nil {
+			t.Errorf("stdin %q: expected an error", input)
+		}
+	}
+}
+
+func TestReadPasswordWithoutTerminal(t *testing.T) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal")
+	}
+
+	// Without --password-stdin, scripts are told how to pass the password instead of hanging
+	_, err := readPassword(&cobra.Command{}, false)
+	if err == nil || !strings.Contains(err.Error(), "--password-stdin") {
+		t.Fatalf("expected a hint about --password-stdin, got %v", err)
+	}
+}
@@ -0,0 +1,558 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Account deletion statuses
+const (
+	deletionPending   = "pending"
+	deletionScheduled = "scheduled"
+)
+
+// How long the deletion confirmation link stays valid
+const deletionConfirmLifetime = 24 * time.Hour
+
+// Time between confirming a deletion and the account being deleted, during which
+// the user can still cancel it
+const accountDeletionGracePeriod = 14 * 24 * time.Hour
+
+// How often the account worker builds exports and deletes due accounts
+const accountWorkerInterval = time.Minute
+
+// Wakes the account worker up when an export is requested
+var accountWake = make(chan struct{}, 1)
+
+var errDeletionBlocked = errors.New("account deletion is blocked")
+
+// AccountDeletionInfo represents a requested account deletion
+type AccountDeletionInfo struct {
+	Status       string    `json:"status"`
+	ScheduledFor time.Time `json:"scheduledFor,omitempty"`
+}
+
+// DeletionBlocker is an organization that has to be dealt with before its owner can
+// delete their account
+type DeletionBlocker struct {
+	Organization string `json:"organization"`
+	Reason       string `json:"reason"`
+}
+
+// findAccountDeletion returns the user's deletion request
+func findAccountDeletion(userId string) (*core.Record, error) {
+	return PbClient.FindFirstRecordByData("account_deletions", "user", userId)
+}
+
+// accountDeletionInfo describes the user's deletion request, or nil when there is none
+func accountDeletionInfo(userId string) *AccountDeletionInfo {
+	deletion, err := findAccountDeletion(userId)
+	if err != nil {
+		return nil
+	}
+	if deletion.GetString("status") == deletionPending && deletion.GetDateTime("confirmExpires").Time().Before(time.Now()) {
+		return nil
+	}
+
+	return &AccountDeletionInfo{
+		Status:       deletion.GetString("status"),
+		ScheduledFor: deletion.GetDateTime("scheduledFor").Time(),
+	}
+}
+
+// ownerSuccessor returns the member that takes over an organization when its only
+// owner leaves: the longest-standing admin, or else the longest-standing member
+func ownerSuccessor(app core.App, organizationId string, userId string) (*core.Record, error) {
+	for _, role := range []string{RoleAdmin, RoleMember} {
+		candidates, err := app.FindRecordsByFilter(
+			"memberships",
+			"organization = {:organization} && user != {:user} && role = {:role}",
+			"created",
+			1,
+			0,
+			dbx.Params{"organization": organizationId, "user": userId, "role": role},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) > 0 {
+			return candidates[0], nil
+		}
+	}
+	return nil, errors.New("organization has no other members")
+}
+
+// deletionBlockers lists the organizations that keep the user from deleting their account:
+// shared organizations they are the only owner of, whose ownership has to be transferred
+// first, and organizations that would be deleted with the account while still paying
+// for a subscription
+func deletionBlockers(user *core.Record) ([]DeletionBlocker, error) {
+	memberships, err := listMemberships(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := []DeletionBlocker{}
+	for _, membership := range memberships {
+		organization := membership.ExpandedOne("organization")
+		if organization == nil || membership.GetString("role") != RoleOwner {
+			continue
+		}
+
+		members, err := listMembers(organization.Id)
+		if err != nil {
+			return nil, err
+		}
+		owners := 0
+		for _, member := range members {
+			if member.Role == RoleOwner {
+				owners++
+			}
+		}
+
+		switch {
+		case owners == 1 && len(members) > 1:
+			blockers = append(blockers, DeletionBlocker{
+				Organization: organization.GetString("name"),
+				Reason:       "Transfer ownership to another member first",
+			})
+		case len(members) == 1:
+			if subscription, err := findOrganizationSubscription(organization.Id); err == nil && isActiveSubscription(subscription) {
+				blockers = append(blockers, DeletionBlocker{
+					Organization: organization.GetString("name"),
+					Reason:       "Cancel its subscription first",
+				})
+			}
+		}
+	}
+
+	return blockers, nil
+}
+
+// sendAccountDeletionEmail emails the link that confirms an account deletion
+func sendAccountDeletionEmail(user *core.Record, token string) error {
+	confirmLink := fmt.Sprintf("%s/settings/delete/confirm?token=%s", AppURL, url.QueryEscape(token))
+
+	return sendEmail(user.Email(), "Confirm your account deletion", "account_deletion.html", EmailData{
+		Link: confirmLink,
+	})
+}
+
+// RequestAccountDeletionHandler emails a link that schedules the current user's account
+// for deletion once opened
+func RequestAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "delete your account") {
+		return
+	}
+
+	// Process form submission
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, r.FormValue("currentPassword"), SettingsData{}) {
+		return
+	}
+	clearLoginFailures(user.Email())
+
+	blockers, err := deletionBlockers(user)
+	if err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Failed to check your organizations"})
+		return
+	}
+	if len(blockers) > 0 {
+		renderSettings(w, r, user, SettingsData{Error: "Some of your organizations need your attention before you can delete your account"})
+		return
+	}
+
+	deletion, err := findAccountDeletion(user.Id)
+	if err == nil && deletion.GetString("status") == deletionScheduled {
+		renderSettings(w, r, user, SettingsData{Error: "Your account is already scheduled for deletion"})
+		return
+	}
+	if err != nil {
+		collection, err := PbClient.FindCollectionByNameOrId("account_deletions")
+		if err != nil {
+			renderSettings(w, r, user, SettingsData{Error: "Failed to request the deletion"})
+			return
+		}
+		deletion = core.NewRecord(collection)
+		deletion.Set("user", user.Id)
+	}
+
+	// Requesting again replaces the previous link
+	token := security.RandomString(48)
+	deletion.Set("status", deletionPending)
+	deletion.Set("tokenHash", hashToken(token))
+	deletion.Set("confirmExpires", time.Now().Add(deletionConfirmLifetime))
+	if err := PbClient.Save(deletion); err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Failed to request the deletion: " + err.Error()})
+		return
+	}
+
+	if err := sendAccountDeletionEmail(user, token); err != nil {
+		log.Printf("⚠️ Failed to send account deletion confirmation: %v", err)
+		renderSettings(w, r, user, SettingsData{Error: "Failed to send the confirmation email. Please try again later."})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditDeletionRequest, Target: "user:" + user.Id})
+
+	renderSettings(w, r, user, SettingsData{
+		Success: "We sent a confirmation link to " + user.Email() + ". Your account is only scheduled for deletion once you open it.",
+	})
+}
+
+// ConfirmAccountDeletionHandler schedules an account for deletion at the end of the grace
+// period, using the token from the confirmation email
+func ConfirmAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	deletion, err := PbClient.FindFirstRecordByData("account_deletions", "tokenHash", hashToken(r.URL.Query().Get("token")))
+	if err != nil || deletion.GetString("status") != deletionPending ||
+		deletion.GetDateTime("confirmExpires").Time().Before(time.Now()) {
+		http.Error(w, "Invalid or expired deletion link. Please request a new one from your settings.", http.StatusBadRequest)
+		return
+	}
+
+	user, err := PbClient.FindRecordById("users", deletion.GetString("user"))
+	if err != nil {
+		http.Error(w, "Invalid deletion link", http.StatusBadRequest)
+		return
+	}
+
+	// The link can only be used once
+	deletion.Set("status", deletionScheduled)
+	deletion.Set("tokenHash", hashToken(security.RandomString(48)))
+	deletion.Set("scheduledFor", time.Now().Add(accountDeletionGracePeriod))
+	if err := PbClient.Save(deletion); err != nil {
+		http.Error(w, "Failed to schedule the deletion: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:  AuditDeletionSchedule,
+		Actor:   user,
+		Target:  "user:" + user.Id,
+		Details: map[string]any{"scheduledFor": deletion.GetDateTime("scheduledFor").Time()},
+	})
+
+	http.Redirect(w, r, "/settings?deletion=scheduled", http.StatusSeeOther)
+}
+
+// CancelAccountDeletionHandler withdraws the current user's deletion request
+func CancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "cancel your account deletion") {
+		return
+	}
+
+	deletion, err := findAccountDeletion(user.Id)
+	if err == nil {
+		err = PbClient.Delete(deletion)
+	}
+	if err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Your account isn't scheduled for deletion"})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditDeletionCancel, Target: "user:" + user.Id})
+
+	renderSettings(w, r, user, SettingsData{Success: "Your account will not be deleted."})
+}
+
+// deleteAccount deletes a user and everything that belongs only to them. Organizations
+// they were the only member of are deleted, shared organizations they were the only
+// owner of pass to a successor, and their audit trail is kept but anonymized. It returns
+// the memberships promoted to owner.
+func deleteAccount(user *core.Record) ([]*core.Record, error) {
+	promoted := []*core.Record{}
+
+	err := PbClient.RunInTransaction(func(txApp core.App) error {
+		memberships, err := txApp.FindAllRecords("memberships", dbx.HashExp{"user": user.Id, "role": RoleOwner})
+		if err != nil {
+			return err
+		}
+
+		for _, membership := range memberships {
+			organizationId := membership.GetString("organization")
+
+			others, err := txApp.CountRecords("memberships", dbx.HashExp{"organization": organizationId}, dbx.Not(dbx.HashExp{"user": user.Id}))
+			if err != nil {
+				return err
+			}
+
+			if others == 0 {
+				if subscription, err := txApp.FindFirstRecordByData("subscriptions", "organization", organizationId); err == nil && isActiveSubscription(subscription) {
+					return errDeletionBlocked
+				}
+				organization, err := txApp.FindRecordById("organizations", organizationId)
+				if err != nil {
+					return err
+				}
+				if err := txApp.Delete(organization); err != nil {
+					return err
+				}
+				continue
+			}
+
+			owners, err := txApp.CountRecords("memberships", dbx.HashExp{"organization": organizationId, "role": RoleOwner}, dbx.Not(dbx.HashExp{"user": user.Id}))
+			if err != nil {
+				return err
+			}
+			if owners > 0 {
+				continue
+			}
+
+			// Members may have joined since the deletion was requested
+			successor, err := ownerSuccessor(txApp, organizationId, user.Id)
+			if err != nil {
+				return err
+			}
+			if err := transferOwnership(txApp, nil, successor); err != nil {
+				return err
+			}
+			promoted = append(promoted, successor)
+		}
+
+		if err := anonymizeAuditEvents(txApp, user); err != nil {
+			return err
+		}
+
+		// Sessions, tokens, memberships, exports and the avatar go with the user
+		return txApp.Delete(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, nil
+}
+
+// userEmailHistory returns the addresses a user has had or asked to change to, as
+// recorded by their email change events, lowercased like login attempt keys
+func userEmailHistory(txApp core.App, user *core.Record) ([]string, error) {
+	events, err := txApp.FindAllRecords("audit_events",
+		dbx.HashExp{"target": "user:" + user.Id, "action": []any{AuditEmailChangeSend, AuditEmailChange}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	emails := []string{}
+	add := func(email string) {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email != "" && !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+
+	add(user.Email())
+	for _, event := range events {
+		var details struct {
+			OldEmail string `json:"oldEmail"`
+			NewEmail string `json:"newEmail"`
+		}
+		if err := event.UnmarshalJSONField("details", &details); err == nil {
+			add(details.OldEmail)
+			add(details.NewEmail)
+		}
+	}
+
+	return emails, nil
+}
+
+// anonymizeAuditEvents strips a deleted user's personal data from the audit log, keeping
+// only their id. Besides the events they made or that target them, their addresses also
+// appear in failed logins and lockouts typed with them, in invitations sent to them and,
+// by id, in ownership transfers. The audit log is append-only, so the events are changed
+// directly in the database.
+func anonymizeAuditEvents(txApp core.App, user *core.Record) error {
+	// Read the former addresses before the email change events lose their details
+	emails, err := userEmailHistory(txApp, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = txApp.DB().Update("audit_events", dbx.Params{"details": nil}, dbx.HashExp{"target": "user:" + user.Id}).Execute()
+	if err != nil {
+		return err
+	}
+
+	_, err = txApp.DB().Update(
+		"audit_events",
+		dbx.Params{"details": dbx.NewExp("json_remove(details, '$.user')")},
+		dbx.NewExp("json_valid(details) AND json_extract(details, '$.user') = {:user}", dbx.Params{"user": user.Id}),
+	).Execute()
+	if err != nil {
+		return err
+	}
+
+	_, err = txApp.DB().Update("audit_events", dbx.Params{"actorEmail": "", "ip": "", "userAgent": ""}, dbx.HashExp{"actor": user.Id}).Execute()
+	if err != nil {
+		return err
+	}
+
+	for _, email := range emails {
+		_, err = txApp.DB().Update(
+			"audit_events",
+			dbx.Params{"actorEmail": "", "ip": "", "userAgent": ""},
+			dbx.NewExp("LOWER(TRIM(actorEmail)) = {:email}", dbx.Params{"email": email}),
+		).Execute()
+		if err != nil {
+			return err
+		}
+
+		// Lockouts name the locked account by the address that was typed
+		_, err = txApp.DB().Update(
+			"audit_events",
+			dbx.Params{"target": "user:" + user.Id},
+			dbx.NewExp("LOWER(TRIM(target)) = {:target}", dbx.Params{"target": accountAttemptKey(email)}),
+		).Execute()
+		if err != nil {
+			return err
+		}
+
+		_, err = txApp.DB().Update(
+			"audit_events",
+			dbx.Params{"details": dbx.NewExp("json_remove(details, '$.email')")},
+			dbx.NewExp("json_valid(details) AND LOWER(TRIM(json_extract(details, '$.email'))) = {:email}", dbx.Params{"email": email}),
+		).Execute()
+		if err != nil {
+			return err
+		}
+
+		keys := []any{accountAttemptKey(email)}
+		for _, kind := range throttledEmails {
+			keys = append(keys, emailSendKey(kind, email))
+		}
+		_, err = txApp.DB().Delete("login_attempts", dbx.HashExp{"key": keys}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditAccountDeletion records a deleted account and the owners promoted in its place.
+// Deletions not made by the account worker name their source.
+func auditAccountDeletion(user *core.Record, promoted []*core.Record, source string) {
+	for _, membership := range promoted {
+		RecordAuditEvent(nil, AuditEvent{
+			Action:       AuditOwnershipTransfer,
+			Organization: membership.GetString("organization"),
+			Target:       "membership:" + membership.Id,
+			Details:      map[string]any{"user": membership.GetString("user"), "reason": "owner deleted their account"},
+		})
+	}
+
+	event := AuditEvent{Action: AuditAccountDelete, Target: "user:" + user.Id}
+	if source != "" {
+		event.Details = map[string]any{"source": source}
+	}
+	RecordAuditEvent(nil, event)
+}
+
+// deleteDueAccounts deletes the accounts whose grace period is over and drops
+// deletion requests that were never confirmed
+func deleteDueAccounts() {
+	due, err := PbClient.FindRecordsByFilter(
+		"account_deletions",
+		"status = {:status} && scheduledFor <= {:now}",
+		"scheduledFor",
+		0,
+		0,
+		dbx.Params{"status": deletionScheduled, "now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		log.Printf("⚠️ Failed to load account deletions: %v", err)
+		return
+	}
+
+	for _, deletion := range due {
+		user, err := PbClient.FindRecordById("users", deletion.GetString("user"))
+		if err != nil {
+			continue
+		}
+		email := user.Email()
+
+		promoted, err := deleteAccount(user)
+		if errors.Is(err, errDeletionBlocked) {
+			// Retry once the subscription has been canceled
+			log.Printf("⚠️ Postponing deletion of user %s: an organization they own still has an active subscription", user.Id)
+			deletion.Set("scheduledFor", time.Now().Add(24*time.Hour))
+			if err := PbClient.Save(deletion); err != nil {
+				log.Printf("⚠️ Failed to postpone account deletion: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("⚠️ Failed to delete user %s: %v", user.Id, err)
+			continue
+		}
+
+		auditAccountDeletion(user, promoted, "")
+
+		if err := sendEmail(email, "Your account has been deleted", "account_deleted.html", EmailData{}); err != nil {
+			log.Printf("⚠️ Failed to send account deletion notice: %v", err)
+		}
+	}
+
+	_, err = PbClient.DB().Delete("account_deletions", dbx.And(
+		dbx.HashExp{"status": deletionPending},
+		dbx.NewExp("confirmExpires <= {:now}", dbx.Params{"now": types.NowDateTime().String()}),
+	)).Execute()
+	if err != nil {
+		log.Printf("⚠️ Failed to remove expired account deletion requests: %v", err)
+	}
+}
+
+// wakeAccountWorker makes the account worker run without waiting for its next tick
+func wakeAccountWorker() {
+	select {
+	case accountWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartAccountWorker builds requested data exports and deletes accounts at the end of
+// their grace period until the context is canceled
+func StartAccountWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(accountWorkerInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-accountWake:
+			}
+			processDataExports()
+			deleteDueAccounts()
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeleteAccountAnonymizesAuditDetails(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, "owner@example.com")
+	user := createTestUser(t, "leaving@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	r.RemoteAddr = "198.51.100.7:4000"
+
+	// The user used to go by another address
+	RecordAuditEvent(r, AuditEvent{
+		Action:  AuditEmailChange,
+		Actor:   user,
+		Target:  "user:" + user.Id,
+		Details: map[string]any{"oldEmail": "old@example.com", "newEmail": "leaving@example.com"},
+	})
+	RecordAuditEvent(r, AuditEvent{Action: AuditLogin, Outcome: AuditFailure, ActorEmail: "Old@example.com", Details: map[string]any{"method": "password"}})
+	RecordAuditEvent(r, AuditEvent{Action: AuditLockout, Outcome: AuditDenied, ActorEmail: "LEAVING@example.com", Target: "account:LEAVING@example.com"})
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationCreate,
+		Actor:        owner,
+		Organization: organization.Id,
+		Target:       "invitation:leaving",
+		Details:      map[string]any{"email": "leaving@example.com", "role": RoleMember},
+	})
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationCreate,
+		Actor:        owner,
+		Organization: organization.Id,
+		Target:       "invitation:other",
+		Details:      map[string]any{"email": "other@example.com", "role": RoleMember},
+	})
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditOwnershipTransfer,
+		Actor:        owner,
+		Organization: organization.Id,
+		Target:       "membership:leaving",
+		Details:      map[string]any{"user": user.Id, "reason": "handover"},
+	})
+	registerFailure(accountAttemptKey("old@example.com"), accountLockoutThreshold, time.Now())
+
+	if _, err := deleteAccount(user); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := app.FindAllRecords("audit_events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		row := strings.ToLower(strings.Join([]string{
+			event.GetString("actorEmail"),
+			event.GetString("target"),
+			event.GetString("details"),
+		}, " "))
+		if strings.Contains(row, "leaving@example.com") || strings.Contains(row, "old@example.com") {
+			t.Errorf("%s event still names the deleted user: %s", event.GetString("action"), row)
+		}
+		if event.GetString("actor") != owner.Id && event.GetString("ip") != "" {
+			t.Errorf("%s event still has the deleted user's IP", event.GetString("action"))
+		}
+
+		switch event.GetString("target") {
+		case "invitation:leaving":
+			if !strings.Contains(event.GetString("details"), RoleMember) {
+				t.Errorf("invitation event lost its role: %s", event.GetString("details"))
+			}
+		case "invitation:other":
+			if !strings.Contains(event.GetString("details"), "other@example.com") {
+				t.Errorf("invitation to someone else was anonymized: %s", event.GetString("details"))
+			}
+		case "membership:leaving":
+			if details := event.GetString("details"); strings.Contains(details, user.Id) || !strings.Contains(details, "handover") {
+				t.Errorf("unexpected ownership transfer details %s", details)
+			}
+		}
+	}
+
+	if attempts, _ := app.CountRecords("login_attempts"); attempts != 0 {
+		t.Fatalf("%d login attempts kept for the deleted user's addresses", attempts)
+	}
+}
@@ -0,0 +1,466 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Prefixes that tell API tokens apart from session tokens
+const (
+	personalTokenPrefix   = "pat_"
+	organizationKeyPrefix = "oak_"
+)
+
+// Kinds of API tokens
+const (
+	TokenPersonal     = "personal"
+	TokenOrganization = "organization"
+)
+
+// Scopes limiting what an API token can do. Tokens also need the permission
+// scopes of the routes they call, e.g. "billing:view".
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Scopes offered when creating a token
+var TokenScopes = []string{
+	ScopeRead,
+	ScopeWrite,
+	PermissionMembersManage,
+	PermissionBillingView,
+	PermissionBillingManage,
+	PermissionAuditView,
+	PermissionWebhooksManage,
+}
+
+// Lifetimes offered when creating a token, in days, with 0 meaning no expiry
+var TokenLifetimes = []int{7, 30, 90, 365, 0}
+
+// Random characters after the prefix of a token
+const apiTokenLength = 40
+
+// Characters of a token shown to identify it after creation
+const apiTokenHintLength = 12
+
+// Minimum interval between lastUsed updates of a token
+const apiTokenTouchInterval = time.Minute
+
+const apiTokenContextKey contextKey = "apiToken"
+
+// APITokenInfo represents a personal access token or organization API key
+type APITokenInfo struct {
+	Id         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Hint       string    `json:"hint"`
+	Scopes     []string  `json:"scopes"`
+	CreatedBy  string    `json:"createdBy,omitempty"`
+	Expires    time.Time `json:"expires,omitempty"`
+	LastUsed   time.Time `json:"lastUsed,omitempty"`
+	LastUsedIP string    `json:"lastUsedIp,omitempty"`
+	Created    time.Time `json:"created"`
+	Expired    bool      `json:"expired"`
+	// Token is only set in the response that creates it
+	Token string `json:"token,omitempty"`
+}
+
+// APITokensData represents the data for the API tokens page
+type APITokensData struct {
+	Email         string         `json:"email"`
+	Organization  string         `json:"organization"`
+	Tokens        []APITokenInfo `json:"tokens"`
+	Keys          []APITokenInfo `json:"keys,omitempty"`
+	CanManageKeys bool           `json:"canManageKeys"`
+	Scopes        []string       `json:"-"`
+	Lifetimes     []int          `json:"-"`
+	Created       *APITokenInfo  `json:"created,omitempty"`
+	Error         string         `json:"error,omitempty"`
+	Success       string         `json:"success,omitempty"`
+}
+
+// isAPIToken reports whether a bearer token is a personal access token or organization API key
+func isAPIToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix) || strings.HasPrefix(token, organizationKeyPrefix)
+}
+
+// tokenScopeGranted reports whether a token's scopes include a scope, honoring
+// "resource:*" entries the same way role permissions do
+func tokenScopeGranted(token *core.Record, scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+
+	for _, granted := range token.GetStringSlice("scopes") {
+		if granted == scope || granted == resource+":*" {
+			return true
+		}
+		// Write access includes read access
+		if scope == ScopeRead && granted == ScopeWrite {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenAllowsMethod reports whether a token's read or write scope covers a request method
+func tokenAllowsMethod(token *core.Record, method string) bool {
+	if isSafeMethod(method) {
+		return tokenScopeGranted(token, ScopeRead)
+	}
+	return tokenScopeGranted(token, ScopeWrite)
+}
+
+// authenticateAPIToken resolves the user and token record of a personal access token or
+// organization API key. Organization keys act as the member who created them.
+func authenticateAPIToken(token string) (*core.Record, *core.Record, error) {
+	record, err := PbClient.FindFirstRecordByData("api_tokens", "tokenHash", hashToken(token))
+	if err != nil {
+		return nil, nil, errors.New("unknown token")
+	}
+
+	expires := record.GetDateTime("expires")
+	if !expires.IsZero() && expires.Time().Before(time.Now()) {
+		return nil, nil, errors.New("token has expired")
+	}
+
+	user, err := PbClient.FindRecordById("users", record.GetString("user"))
+	if err != nil {
+		return nil, nil, errors.New("token owner not found")
+	}
+
+	return user, record, nil
+}
+
+// touchAPIToken records use of a token, throttled to avoid a write per request
+func touchAPIToken(token *core.Record, r *http.Request) {
+	if time.Since(token.GetDateTime("lastUsed").Time()) < apiTokenTouchInterval {
+		return
+	}
+
+	token.Set("lastUsed", time.Now())
+	token.Set("lastUsedIp", clientIP(r))
+
+	if err := PbClient.Save(token); err != nil {
+		log.Printf("⚠️ Failed to update token usage: %v", err)
+	}
+}
+
+// GetCurrentAPIToken returns the API token the request authenticated with, or nil
+// for requests authenticated with a session
+func GetCurrentAPIToken(r *http.Request) *core.Record {
+	token, _ := r.Context().Value(apiTokenContextKey).(*core.Record)
+	return token
+}
+
+// apiTokenInfo converts an API token record
+func apiTokenInfo(token *core.Record) APITokenInfo {
+	info := APITokenInfo{
+		Id:         token.Id,
+		Kind:       token.GetString("kind"),
+		Name:       token.GetString("name"),
+		Hint:       token.GetString("hint"),
+		Scopes:     token.GetStringSlice("scopes"),
+		Expires:    token.GetDateTime("expires").Time(),
+		LastUsed:   token.GetDateTime("lastUsed").Time(),
+		LastUsedIP: token.GetString("lastUsedIp"),
+		Created:    token.GetDateTime("created").Time(),
+	}
+	info.Expired = !info.Expires.IsZero() && info.Expires.Before(time.Now())
+
+	if info.Kind == TokenOrganization {
+		if user, err := PbClient.FindRecordById("users", token.GetString("user")); err == nil {
+			info.CreatedBy = user.Email()
+		}
+	}
+
+	return info
+}
+
+// listAPITokens returns the tokens matching a filter, newest first
+func listAPITokens(filter string, params dbx.Params) ([]APITokenInfo, error) {
+	records, err := PbClient.FindRecordsByFilter("api_tokens", filter, "-created", 0, 0, params)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]APITokenInfo, 0, len(records))
+	for _, record := range records {
+		tokens = append(tokens, apiTokenInfo(record))
+	}
+
+	return tokens, nil
+}
+
+// renderAPITokens responds with the API tokens page or its JSON for API clients
+func renderAPITokens(w http.ResponseWriter, r *http.Request, data APITokensData) {
+	user := GetCurrentUser(r)
+	organization := GetCurrentOrganization(r)
+
+	data.Email = user.Email()
+	data.Scopes = TokenScopes
+	data.Lifetimes = TokenLifetimes
+	data.Tokens, _ = listAPITokens(
+		"kind = {:kind} && user = {:user}",
+		dbx.Params{"kind": TokenPersonal, "user": user.Id},
+	)
+	if data.Tokens == nil {
+		data.Tokens = []APITokenInfo{}
+	}
+
+	if organization != nil {
+		data.Organization = organization.GetString("name")
+		data.CanManageKeys = HasPermission(r, PermissionAPIKeysManage)
+	}
+	if data.CanManageKeys {
+		data.Keys, _ = listAPITokens(
+			"kind = {:kind} && organization = {:organization}",
+			dbx.Params{"kind": TokenOrganization, "organization": organization.Id},
+		)
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "api_tokens.html", data); err != nil {
+		http.Error(w, "Error rendering API tokens page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// rejectAPITokenAuth refuses an action that needs a signed-in session when the request
+// is authenticated with an API token, so a leaked token can't mint new ones or take over
+// the account. It reports whether the request was rejected.
+func rejectAPITokenAuth(w http.ResponseWriter, r *http.Request, action string) bool {
+	if GetCurrentAPIToken(r) == nil {
+		return false
+	}
+	forbidden(w, r, "API tokens can't be used to "+action+". Sign in to do this instead.")
+	return true
+}
+
+// SessionOnlyMiddleware keeps API tokens off the account routes: sessions, profile,
+// settings, API token management and two-factor authentication. Tokens are meant for the
+// product API, and a leaked one mustn't be able to sign the user out everywhere or change
+// their account. It has to run after AuthMiddleware.
+func SessionOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rejectAPITokenAuth(w, r, "manage your account") {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APITokensHandler lists the current user's personal access tokens and, for members
+// allowed to manage them, the active organization's API keys
+func APITokensHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentUser(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	renderAPITokens(w, r, APITokensData{})
+}
+
+// createAPIToken parses the token form and stores a new token, returning it with the
+// plain token that is only shown once
+func createAPIToken(r *http.Request, kind string) (*APITokenInfo, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, errors.New("Failed to parse form")
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return nil, errors.New("Give the token a name")
+	}
+
+	scopes := []string{}
+	for _, scope := range r.Form["scopes"] {
+		for _, known := range TokenScopes {
+			if scope != known {
+				continue
+			}
+			// Tokens can't grant permissions their creator doesn't have
+			if scope != ScopeRead && scope != ScopeWrite && !HasPermission(r, scope) {
+				return nil, errors.New("You don't have the " + scope + " permission")
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("Select at least one scope")
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expires"))
+	if err != nil || days < 0 {
+		return nil, errors.New("Select when the token expires")
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("api_tokens")
+	if err != nil {
+		return nil, errors.New("API tokens are not configured correctly")
+	}
+
+	prefix := personalTokenPrefix
+	if kind == TokenOrganization {
+		prefix = organizationKeyPrefix
+	}
+	token := prefix + security.RandomString(apiTokenLength)
+
+	record := core.NewRecord(collection)
+	record.Set("kind", kind)
+	record.Set("user", GetCurrentUser(r).Id)
+	if kind == TokenOrganization {
+		record.Set("organization", GetCurrentOrganization(r).Id)
+	}
+	record.Set("name", name)
+	record.Set("hint", token[:apiTokenHintLength])
+	record.Set("tokenHash", hashToken(token))
+	record.Set("scopes", scopes)
+	if days > 0 {
+		record.Set("expires", time.Now().AddDate(0, 0, days))
+	}
+
+	if err := PbClient.Save(record); err != nil {
+		return nil, errors.New("Failed to create token: " + err.Error())
+	}
+
+	event := AuditEvent{
+		Action:  AuditAPITokenCreate,
+		Target:  "api_token:" + record.Id,
+		Details: map[string]any{"kind": kind, "name": name, "scopes": scopes},
+	}
+	if kind == TokenOrganization {
+		event.Organization = record.GetString("organization")
+	}
+	RecordAuditEvent(r, event)
+
+	info := apiTokenInfo(record)
+	info.Token = token
+	return &info, nil
+}
+
+// respondTokenCreated shows a newly created token once
+func respondTokenCreated(w http.ResponseWriter, r *http.Request, info *APITokenInfo, err error) {
+	if err != nil {
+		renderAPITokens(w, r, APITokensData{Error: err.Error()})
+		return
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+		return
+	}
+
+	renderAPITokens(w, r, APITokensData{Created: info})
+}
+
+// CreatePersonalTokenHandler creates a personal access token for the current user
+func CreatePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentUser(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "create API tokens") {
+		return
+	}
+
+	info, err := createAPIToken(r, TokenPersonal)
+	respondTokenCreated(w, r, info, err)
+}
+
+// CreateOrganizationKeyHandler creates an API key for the active organization
+func CreateOrganizationKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentOrganization(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "create organization API keys") {
+		return
+	}
+
+	info, err := createAPIToken(r, TokenOrganization)
+	respondTokenCreated(w, r, info, err)
+}
+
+// revokeAPIToken deletes a token after checking it belongs to the user or organization
+func revokeAPIToken(w http.ResponseWriter, r *http.Request, kind string) {
+	if GetCurrentUser(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "revoke API tokens") {
+		return
+	}
+
+	token, err := PbClient.FindRecordById("api_tokens", mux.Vars(r)["id"])
+	if err == nil && token.GetString("kind") != kind {
+		err = errors.New("not found")
+	}
+	if err == nil && kind == TokenPersonal && token.GetString("user") != GetCurrentUser(r).Id {
+		err = errors.New("not found")
+	}
+	if err == nil && kind == TokenOrganization && token.GetString("organization") != GetCurrentOrganization(r).Id {
+		err = errors.New("not found")
+	}
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusNotFound, "Token not found")
+			return
+		}
+		renderAPITokens(w, r, APITokensData{Error: "Token not found"})
+		return
+	}
+
+	if err := PbClient.Delete(token); err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to revoke token")
+			return
+		}
+		renderAPITokens(w, r, APITokensData{Error: "Failed to revoke token"})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditAPITokenRevoke,
+		Organization: token.GetString("organization"),
+		Target:       "api_token:" + token.Id,
+		Details:      map[string]any{"kind": kind, "name": token.GetString("name")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	renderAPITokens(w, r, APITokensData{Success: "\"" + token.GetString("name") + "\" has been revoked."})
+}
+
+// RevokePersonalTokenHandler revokes one of the current user's personal access tokens
+func RevokePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	revokeAPIToken(w, r, TokenPersonal)
+}
+
+// RevokeOrganizationKeyHandler revokes one of the active organization's API keys
+func RevokeOrganizationKeyHandler(w http.ResponseWriter, r *http.Request) {
+	revokeAPIToken(w, r, TokenOrganization)
+}
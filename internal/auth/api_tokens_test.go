@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// newTestAPIToken stores an API token for a user, and for organization keys an
+// organization, returning the plain token
+func newTestAPIToken(t *testing.T, user *core.Record, kind string, organizationId string, scopes ...string) string {
+	t.Helper()
+
+	collection, err := PbClient.FindCollectionByNameOrId("api_tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := personalTokenPrefix
+	if kind == TokenOrganization {
+		prefix = organizationKeyPrefix
+	}
+	token := prefix + security.RandomString(apiTokenLength)
+
+	record := core.NewRecord(collection)
+	record.Set("kind", kind)
+	record.Set("user", user.Id)
+	record.Set("organization", organizationId)
+	record.Set("name", "test")
+	record.Set("hint", token[:apiTokenHintLength])
+	record.Set("tokenHash", hashToken(token))
+	record.Set("scopes", scopes)
+	if err := PbClient.Save(record); err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// protected wraps a handler in the middleware of the app's protected API routes
+func protected(handler http.HandlerFunc, middleware ...func(http.Handler) http.Handler) http.Handler {
+	var h http.Handler = handler
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return AuthMiddleware(OrganizationMiddleware(h))
+}
+
+// callWithToken sends an API request authenticated with a bearer token
+func callWithToken(handler http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPITokensRefusedOnAccountRoutes(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "tokens@example.com")
+	memberships, err := listMemberships(user.Id)
+	if err != nil || len(memberships) == 0 {
+		t.Fatal("user has no workspace")
+	}
+	workspace := memberships[0].GetString("organization")
+
+	session := signIn(t, user)
+
+	tokens := map[string]string{
+		"a personal access token":   newTestAPIToken(t, user, TokenPersonal, "", ScopeRead, ScopeWrite),
+		"an organization write key": newTestAPIToken(t, user, TokenOrganization, workspace, ScopeRead, ScopeWrite),
+	}
+	routes := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"profile update", http.MethodPost, ProfileHandler, "name=Taken"},
+		{"session revocation", http.MethodDelete, RevokeAllSessionsHandler, ""},
+		{"password change", http.MethodPost, ChangePasswordHandler, "currentPassword=" + testPassword},
+		{"2FA disable", http.MethodPost, TwoFactorDisableHandler, "password=" + testPassword},
+	}
+
+	for kind, token := range tokens {
+		for _, route := range routes {
+			w := callWithToken(protected(route.handler, SessionOnlyMiddleware), route.method, "/api/account", token, route.body)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s with %s: got %d, want 403", route.name, kind, w.Code)
+			}
+		}
+	}
+
+	if _, _, err := authenticateToken(session); err != nil {
+		t.Fatal("the user's session was revoked with an API token")
+	}
+	if stored, _ := PbClient.FindRecordById("users", user.Id); stored.GetString("name") == "Taken" {
+		t.Fatal("profile was changed with an API token")
+	}
+
+	// Product routes still accept tokens
+	w := callWithToken(protected(OrganizationsHandler), http.MethodGet, "/api/organizations", tokens["a personal access token"], "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("organizations with a personal access token: got %d", w.Code)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "scopes@example.com")
+	memberships, err := listMemberships(user.Id)
+	if err != nil || len(memberships) == 0 {
+		t.Fatal("user has no workspace")
+	}
+	workspace := memberships[0].GetString("organization")
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	readOnly := newTestAPIToken(t, user, TokenOrganization, workspace, ScopeRead, PermissionBillingView)
+
+	// The read scope doesn't allow changes, even where the owner's role would
+	if w := callWithToken(protected(ok), http.MethodGet, "/api/organizations", readOnly, ""); w.Code != http.StatusOK {
+		t.Fatalf("read with a read-only key: got %d", w.Code)
+	}
+	w := callWithToken(protected(ok), http.MethodPost, "/api/organizations", readOnly, "name=Acme")
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "scope") {
+		t.Fatalf("write with a read-only key: got %d %s", w.Code, w.Body.String())
+	}
+
+	// Permission scopes narrow the role down to what the key was granted
+	if w := callWithToken(protected(ok, RequirePermission(PermissionBillingView)), http.MethodGet, "/api/billing", readOnly, ""); w.Code != http.StatusOK {
+		t.Fatalf("granted permission: got %d", w.Code)
+	}
+	if w := callWithToken(protected(ok, RequirePermission(PermissionAuditView)), http.MethodGet, "/api/audit", readOnly, ""); w.Code != http.StatusForbidden {
+		t.Fatalf("permission the key wasn't granted: got %d, want 403", w.Code)
+	}
+
+	// Write access includes read access, and resource wildcards cover each action
+	writer := newTestAPIToken(t, user, TokenPersonal, "", ScopeWrite, "billing:*")
+	if w := callWithToken(protected(ok, RequirePermission(PermissionBillingManage)), http.MethodPost, "/api/billing/portal", writer, "plan=pro"); w.Code != http.StatusOK {
+		t.Fatalf("write token with billing:*: got %d %s", w.Code, w.Body.String())
+	}
+	if w := callWithToken(protected(ok), http.MethodGet, "/api/organizations", writer, ""); w.Code != http.StatusOK {
+		t.Fatalf("read with a write token: got %d", w.Code)
+	}
+}
+
+func TestRevokedAndExpiredAPITokens(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "revoke@example.com")
+	session := signIn(t, user)
+
+	ok := protected(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	token := newTestAPIToken(t, user, TokenPersonal, "", ScopeRead)
+	if w := callWithToken(ok, http.MethodGet, "/api/organizations", token, ""); w.Code != http.StatusOK {
+		t.Fatalf("new token: got %d", w.Code)
+	}
+	record, err := app.FindFirstRecordByData("api_tokens", "tokenHash", hashToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.GetDateTime("lastUsed").IsZero() {
+		t.Fatal("token use wasn't recorded")
+	}
+
+	// Tokens can't revoke tokens, the owner's session can
+	revoke := func(bearer string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/api/tokens/"+record.Id, nil)
+		r.Header.Set("Authorization", "Bearer "+bearer)
+		r = mux.SetURLVars(r, map[string]string{"id": record.Id})
+		w := httptest.NewRecorder()
+		protected(RevokePersonalTokenHandler).ServeHTTP(w, r)
+		return w
+	}
+	if w := revoke(token); w.Code != http.StatusForbidden {
+		t.Fatalf("revoking with the token itself: got %d, want 403", w.Code)
+	}
+	if w := revoke(session); w.Code != http.StatusNoContent {
+		t.Fatalf("revoking with a session: got %d %s", w.Code, w.Body.String())
+	}
+
+	w := callWithToken(ok, http.MethodGet, "/api/organizations", token, "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("revoked token: got %d, want 401", w.Code)
+	}
+
+	expired := newTestAPIToken(t, user, TokenPersonal, "", ScopeRead)
+	record, err = app.FindFirstRecordByData("api_tokens", "tokenHash", hashToken(expired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.Set("expires", time.Now().Add(-time.Minute))
+	if err := app.Save(record); err != nil {
+		t.Fatal(err)
+	}
+	if w := callWithToken(ok, http.MethodGet, "/api/organizations", expired, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expired token: got %d, want 401", w.Code)
+	}
+}
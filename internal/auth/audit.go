@@ -0,0 +1,511 @@
+package auth
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Outcomes of audited actions
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// Audited actions recorded by the auth package
+const (
+	AuditLogin              = "auth.login"
+	AuditLockout            = "auth.lockout"
+	AuditRegister           = "auth.register"
+	AuditLogout             = "auth.logout"
+	AuditTokenRefresh       = "auth.token_refresh"
+	AuditPasswordResetSend  = "auth.password_reset_request"
+	AuditPasswordReset      = "auth.password_reset"
+	AuditPasswordChange     = "auth.password_change"
+	AuditEmailChangeSend    = "auth.email_change_request"
+	AuditEmailChange        = "auth.email_change"
+	AuditTwoFactorEnable    = "auth.2fa_enable"
+	AuditTwoFactorDisable   = "auth.2fa_disable"
+	AuditRecoveryCodes      = "auth.recovery_codes"
+	AuditSessionRevoke      = "session.revoke"
+	AuditSessionRevokeAll   = "session.revoke_all"
+	AuditOrganizationCreate = "organization.create"
+	AuditOwnershipTransfer  = "organization.transfer"
+	AuditMemberRemove       = "member.remove"
+	AuditMemberRoleChange   = "member.role_change"
+	AuditInvitationCreate   = "invitation.create"
+	AuditInvitationResend   = "invitation.resend"
+	AuditInvitationRevoke   = "invitation.revoke"
+	AuditInvitationAccept   = "invitation.accept"
+	AuditPermissionDenied   = "permission.denied"
+	AuditWebhookCreate      = "webhook.create"
+	AuditWebhookDelete      = "webhook.delete"
+	AuditAPITokenCreate     = "api_token.create"
+	AuditAPITokenRevoke     = "api_token.revoke"
+	AuditExport             = "audit.export"
+	AuditDataExport         = "account.export"
+	AuditDeletionRequest    = "account.delete_request"
+	AuditDeletionSchedule   = "account.delete_schedule"
+	AuditDeletionCancel     = "account.delete_cancel"
+	AuditAccountDelete      = "account.delete"
+)
+
+// Audit events shown per page of the audit log
+const auditEventsPerPage = 50
+
+// Audit events read at a time while exporting
+const auditExportBatchSize = 500
+
+// Actions offered by the audit log filter
+var auditActions = []string{
+	AuditLogin, AuditLockout, AuditRegister, AuditLogout, AuditTokenRefresh,
+	AuditPasswordResetSend, AuditPasswordReset, AuditPasswordChange, AuditEmailChangeSend,
+	AuditEmailChange, AuditTwoFactorEnable, AuditTwoFactorDisable,
+	AuditRecoveryCodes, AuditSessionRevoke, AuditSessionRevokeAll, AuditOrganizationCreate,
+	AuditOwnershipTransfer, AuditMemberRemove, AuditMemberRoleChange, AuditInvitationCreate,
+	AuditInvitationResend, AuditInvitationRevoke, AuditInvitationAccept, AuditPermissionDenied,
+	AuditWebhookCreate, AuditWebhookDelete,
+	AuditAPITokenCreate, AuditAPITokenRevoke, AuditExport, AuditDataExport, AuditDeletionRequest,
+	AuditDeletionSchedule, AuditDeletionCancel, AuditAccountDelete,
+}
+
+var errAuditAppendOnly = errors.New("audit events are append-only")
+
+// AuditEvent describes a security-relevant action to record
+type AuditEvent struct {
+	Action  string
+	Outcome string
+	// Actor defaults to the authenticated user of the request
+	Actor *core.Record
+	// ActorEmail identifies actors without an account, e.g. failed logins for unknown emails
+	ActorEmail string
+	// Organization the event belongs to. User-level events such as logins are recorded
+	// without one and show up in the audit log of every organization the user is in.
+	Organization string
+	// Target is what the action applied to, as "type:id"
+	Target  string
+	Details map[string]any
+}
+
+// AuditEntry represents a recorded audit event
+type AuditEntry struct {
+	Id         string         `json:"id"`
+	Created    time.Time      `json:"created"`
+	Action     string         `json:"action"`
+	Outcome    string         `json:"outcome"`
+	Actor      string         `json:"actor"`
+	ActorEmail string         `json:"actorEmail"`
+	Target     string         `json:"target"`
+	IP         string         `json:"ip"`
+	UserAgent  string         `json:"userAgent"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+// AuditFilter narrows down the audit log
+type AuditFilter struct {
+	Action  string `json:"action,omitempty"`
+	Outcome string `json:"outcome,omitempty"`
+	Actor   string `json:"actor,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+}
+
+// AuditData represents the data for the audit log page
+type AuditData struct {
+	Organization string       `json:"organization"`
+	Events       []AuditEntry `json:"events"`
+	Filter       AuditFilter  `json:"filter"`
+	Actions      []string     `json:"-"`
+	Page         int          `json:"page"`
+	PerPage      int          `json:"perPage"`
+	TotalItems   int          `json:"totalItems"`
+	TotalPages   int          `json:"totalPages"`
+	PrevURL      string       `json:"-"`
+	NextURL      string       `json:"-"`
+	CSVURL       string       `json:"-"`
+	JSONURL      string       `json:"-"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// RecordAuditEvent appends an event to the audit log with the request's IP and user agent.
+// Background jobs pass a nil request. Failing to record is logged and never fails the request.
+func RecordAuditEvent(r *http.Request, event AuditEvent) {
+	if PbClient == nil {
+		return
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("audit_events")
+	if err != nil {
+		log.Printf("⚠️ Failed to record audit event: %v", err)
+		return
+	}
+
+	actor := event.Actor
+	if actor == nil && r != nil {
+		actor = GetCurrentUser(r)
+	}
+
+	outcome := event.Outcome
+	if outcome == "" {
+		outcome = AuditSuccess
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("organization", event.Organization)
+	record.Set("action", event.Action)
+	record.Set("outcome", outcome)
+	record.Set("actorEmail", event.ActorEmail)
+	if actor != nil {
+		record.Set("actor", actor.Id)
+		record.Set("actorEmail", actor.Email())
+	}
+	record.Set("target", event.Target)
+	if r != nil {
+		record.Set("ip", clientIP(r))
+		record.Set("userAgent", r.UserAgent())
+	}
+	if event.Details != nil {
+		record.Set("details", event.Details)
+	}
+
+	if err := PbClient.Save(record); err != nil {
+		log.Printf("⚠️ Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// auditLogin records a login attempt made with the given method
+func auditLogin(r *http.Request, user *core.Record, email string, method string, outcome string) {
+	RecordAuditEvent(r, AuditEvent{
+		Action:     AuditLogin,
+		Outcome:    outcome,
+		Actor:      user,
+		ActorEmail: email,
+		Details:    map[string]any{"method": method},
+	})
+}
+
+// RegisterAuditHooks keeps the audit log append-only, even for superusers
+func RegisterAuditHooks(app core.App) {
+	app.OnRecordUpdate("audit_events").BindFunc(func(e *core.RecordEvent) error {
+		return errAuditAppendOnly
+	})
+	app.OnRecordDelete("audit_events").BindFunc(func(e *core.RecordEvent) error {
+		return errAuditAppendOnly
+	})
+}
+
+// auditFilterFromRequest reads the audit log filter from the query string
+func auditFilterFromRequest(r *http.Request) (AuditFilter, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		Action:  query.Get("action"),
+		Outcome: query.Get("outcome"),
+		Actor:   query.Get("actor"),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+	}
+
+	for _, date := range []string{filter.From, filter.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return filter, errors.New("Dates must be formatted as YYYY-MM-DD")
+		}
+	}
+
+	return filter, nil
+}
+
+// values encodes the filter as query parameters
+func (f AuditFilter) values() url.Values {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"action":  f.Action,
+		"outcome": f.Outcome,
+		"actor":   f.Actor,
+		"from":    f.From,
+		"to":      f.To,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	return values
+}
+
+// auditCondition selects the audit events visible to an organization: its own events
+// plus user-level events, such as logins, of its current members
+func auditCondition(organizationId string, filter AuditFilter) (dbx.Expression, error) {
+	memberships, err := PbClient.FindAllRecords("memberships", dbx.HashExp{"organization": organizationId})
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]any, 0, len(memberships))
+	for _, membership := range memberships {
+		members = append(members, membership.GetString("user"))
+	}
+
+	conditions := []dbx.Expression{
+		dbx.Or(
+			dbx.HashExp{"organization": organizationId},
+			dbx.And(dbx.HashExp{"organization": ""}, dbx.In("actor", members...)),
+		),
+	}
+
+	// An action ending in "." matches every action of that kind, e.g. "auth."
+	if filter.Action != "" {
+		conditions = append(conditions, dbx.Like("action", filter.Action).Match(false, filter.Action[len(filter.Action)-1] == '.'))
+	}
+	if filter.Outcome != "" {
+		conditions = append(conditions, dbx.HashExp{"outcome": filter.Outcome})
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, dbx.Like("actorEmail", filter.Actor))
+	}
+	if filter.From != "" {
+		conditions = append(conditions, dbx.NewExp("created >= {:from}", dbx.Params{"from": filter.From}))
+	}
+	if filter.To != "" {
+		to, _ := time.Parse(time.DateOnly, filter.To)
+		conditions = append(conditions, dbx.NewExp("created < {:to}", dbx.Params{"to": to.AddDate(0, 0, 1).Format(time.DateOnly)}))
+	}
+
+	return dbx.And(conditions...), nil
+}
+
+// auditEntry converts an audit event record
+func auditEntry(record *core.Record) AuditEntry {
+	entry := AuditEntry{
+		Id:         record.Id,
+		Created:    record.GetDateTime("created").Time(),
+		Action:     record.GetString("action"),
+		Outcome:    record.GetString("outcome"),
+		Actor:      record.GetString("actor"),
+		ActorEmail: record.GetString("actorEmail"),
+		Target:     record.GetString("target"),
+		IP:         record.GetString("ip"),
+		UserAgent:  record.GetString("userAgent"),
+	}
+	record.UnmarshalJSONField("details", &entry.Details)
+	return entry
+}
+
+// findAuditEvents returns a page of audit events in the given order
+func findAuditEvents(condition dbx.Expression, order string, limit int, offset int) ([]AuditEntry, error) {
+	records := []*core.Record{}
+	err := PbClient.RecordQuery("audit_events").
+		AndWhere(condition).
+		OrderBy("created "+order, "id "+order).
+		Limit(int64(limit)).
+		Offset(int64(offset)).
+		All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, auditEntry(record))
+	}
+	return entries, nil
+}
+
+// AuditLogHandler lists the active organization's audit events, filtered and paginated
+func AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	data := AuditData{
+		Organization: organization.GetString("name"),
+		Actions:      auditActions,
+		Events:       []AuditEntry{},
+		Page:         1,
+		PerPage:      auditEventsPerPage,
+	}
+
+	if page, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && page > 1 {
+		data.Page = page
+	}
+
+	filter, err := auditFilterFromRequest(r)
+	data.Filter = filter
+	for format, target := range map[string]*string{"csv": &data.CSVURL, "json": &data.JSONURL} {
+		values := filter.values()
+		values.Set("format", format)
+		*target = "/audit/export?" + values.Encode()
+	}
+	if err != nil {
+		data.Error = err.Error()
+		renderAuditLog(w, r, data, http.StatusBadRequest)
+		return
+	}
+
+	condition, err := auditCondition(organization.Id, filter)
+	if err == nil {
+		err = PbClient.DB().Select("count(*)").From("audit_events").Where(condition).Row(&data.TotalItems)
+	}
+	if err == nil {
+		data.Events, err = findAuditEvents(condition, "DESC", data.PerPage, (data.Page-1)*data.PerPage)
+	}
+	if err != nil {
+		log.Printf("⚠️ Failed to load audit events: %v", err)
+		data.Error = "Failed to load audit events"
+		renderAuditLog(w, r, data, http.StatusInternalServerError)
+		return
+	}
+
+	data.TotalPages = (data.TotalItems + data.PerPage - 1) / data.PerPage
+	pageURL := func(page int) string {
+		values := filter.values()
+		values.Set("page", strconv.Itoa(page))
+		return "/audit?" + values.Encode()
+	}
+	if data.Page > 1 {
+		data.PrevURL = pageURL(data.Page - 1)
+	}
+	if data.Page < data.TotalPages {
+		data.NextURL = pageURL(data.Page + 1)
+	}
+
+	renderAuditLog(w, r, data, http.StatusOK)
+}
+
+// renderAuditLog responds with the audit log page or its JSON for API clients
+func renderAuditLog(w http.ResponseWriter, r *http.Request, data AuditData, status int) {
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := renderTemplate(w, r, "audit.html", data); err != nil {
+		http.Error(w, "Error rendering audit log: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// AuditExportHandler downloads the filtered audit log as CSV (the default) or JSON
+func AuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		writeJSONError(w, http.StatusBadRequest, "Format must be csv or json")
+		return
+	}
+
+	filter, err := auditFilterFromRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	condition, err := auditCondition(organization.Id, filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to export audit events")
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditExport,
+		Organization: organization.Id,
+		Target:       "organization:" + organization.Id,
+		Details:      map[string]any{"format": format, "filter": filter},
+	})
+
+	filename := fmt.Sprintf("audit-%s-%s.%s", organization.GetString("slug"), time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Events are streamed oldest first in batches, so large logs aren't held in memory
+	// and events recorded during the export don't shift the batches
+	var writeEntry func(entry AuditEntry) error
+	var finish func() error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"id", "created", "action", "outcome", "actor", "actorEmail", "target", "ip", "userAgent", "details"})
+		writeEntry = func(entry AuditEntry) error {
+			details := ""
+			if entry.Details != nil {
+				encoded, _ := json.Marshal(entry.Details)
+				details = string(encoded)
+			}
+			return writer.Write([]string{
+				entry.Id, entry.Created.UTC().Format(time.RFC3339), entry.Action, entry.Outcome,
+				entry.Actor, entry.ActorEmail, entry.Target, entry.IP, entry.UserAgent, details,
+			})
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+		first := true
+		writeEntry = func(entry AuditEntry) error {
+			if !first {
+				w.Write([]byte(","))
+			}
+			first = false
+			encoded, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(encoded)
+			return err
+		}
+		finish = func() error {
+			_, err := w.Write([]byte("]\n"))
+			return err
+		}
+	}
+
+	for offset := 0; ; offset += auditExportBatchSize {
+		entries, err := findAuditEvents(condition, "ASC", auditExportBatchSize, offset)
+		if err != nil {
+			log.Printf("⚠️ Failed to export audit events: %v", err)
+			return
+		}
+
+		for _, entry := range entries {
+			if err := writeEntry(entry); err != nil {
+				return
+			}
+		}
+
+		if len(entries) < auditExportBatchSize {
+			break
+		}
+	}
+
+	if err := finish(); err != nil {
+		log.Printf("⚠️ Failed to export audit events: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestLoginFailuresAudited(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "audited@example.com")
+
+	apiLogin(user.Email(), "wrong", "198.51.100.20")
+	apiLogin("nobody@example.com", "wrong", "198.51.100.21")
+
+	for email, ip := range map[string]string{user.Email(): "198.51.100.20", "nobody@example.com": "198.51.100.21"} {
+		record, err := app.FindFirstRecordByFilter("audit_events", "action = {:action} && actorEmail = {:email}", dbx.Params{"action": AuditLogin, "email": email})
+		if err != nil {
+			t.Fatalf("no login audited for %s: %v", email, err)
+		}
+		if record.GetString("outcome") != AuditFailure || record.GetString("ip") != ip {
+			t.Errorf("login for %s: got %s from %q", email, record.GetString("outcome"), record.GetString("ip"))
+		}
+	}
+}
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	app := newTestApp(t)
+	RegisterAuditHooks(app)
+	user := createTestUser(t, "appendonly@example.com")
+
+	RecordAuditEvent(nil, AuditEvent{Action: AuditLogout, Actor: user})
+	record, err := app.FindFirstRecordByData("audit_events", "actor", user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record.Set("outcome", AuditFailure)
+	if err := app.Save(record); err == nil {
+		t.Fatal("an audit event was changed")
+	}
+	if err := app.Delete(record); err == nil {
+		t.Fatal("an audit event was deleted")
+	}
+}
+
+func TestAuditLogScopedToOrganization(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, "auditor@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := createTestUser(t, "member@example.com")
+	addTestMember(t, organization, member, RoleMember)
+	outsider := createTestUser(t, "outsider@example.com")
+	other, err := createOrganization(app, outsider, "Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Members' logins show up, other organizations' events don't
+	RecordAuditEvent(nil, AuditEvent{Action: AuditLogin, Actor: member})
+	RecordAuditEvent(nil, AuditEvent{Action: AuditLogin, Actor: outsider})
+	RecordAuditEvent(nil, AuditEvent{Action: AuditWebhookCreate, Actor: outsider, Organization: other.Id})
+
+	session := signIn(t, owner)
+	call := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.AddCookie(&http.Cookie{Name: "pb_auth", Value: session})
+		r.AddCookie(&http.Cookie{Name: "pb_org", Value: organization.Id})
+		w := httptest.NewRecorder()
+		protected(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	w := call(AuditLogHandler, "/api/audit?action=auth.")
+	var data AuditData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("audit log: got %d: %v", w.Code, err)
+	}
+	memberLogin := false
+	for _, event := range data.Events {
+		if event.Actor == outsider.Id {
+			t.Fatalf("audit log shows another organization's event %+v", event)
+		}
+		memberLogin = memberLogin || event.Actor == member.Id && event.Action == AuditLogin
+	}
+	if !memberLogin {
+		t.Fatalf("audit log is missing the member's login: %+v", data.Events)
+	}
+
+	if w := call(AuditLogHandler, "/api/audit?from=yesterday"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid date: got %d", w.Code)
+	}
+
+	// Exports apply the same filter and are audited themselves
+	w = call(AuditExportHandler, "/audit/export?format=csv&action=auth.login&actor=member@example.com")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("CSV export: got %d", w.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], AuditLogin) {
+		t.Fatalf("unexpected CSV export %q", w.Body.String())
+	}
+	if total, _ := app.CountRecords("audit_events", dbx.HashExp{"action": AuditExport, "organization": organization.Id}); total != 1 {
+		t.Fatalf("expected the export in the audit log, got %d", total)
+	}
+
+	if w := call(AuditExportHandler, "/audit/export?format=xml"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: got %d", w.Code)
+	}
+}
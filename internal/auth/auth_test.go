@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	_ "github.com/yourusername/go-saas-template/migrations"
+)
+
+// Password that satisfies the default password policy
+const testPassword = "Corr3ct-Horse-Battery!"
+
+// newTestApp creates a PocketBase app in a temporary directory with the app's
+// migrations applied and the default plans seeded, and makes it the PbClient
+func newTestApp(t *testing.T) *pocketbase.PocketBase {
+	t.Helper()
+
+	app := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatalf("failed to bootstrap app: %v", err)
+	}
+	if err := app.RunAllMigrations(); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	if _, err := SeedPlans(app); err != nil {
+		t.Fatalf("failed to seed plans: %v", err)
+	}
+
+	previous := PbClient
+	PbClient = app
+	t.Cleanup(func() {
+		PbClient = previous
+		app.ResetBootstrapState()
+
+		// PocketBase deletes the storage files of deleted records in the background,
+		// which can race the removal of the temporary directory
+		for i := 0; i < 10 && os.RemoveAll(app.DataDir()) != nil; i++ {
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+
+	return app
+}
+
+// createTestUser creates a verified user with testPassword
+func createTestUser(t *testing.T, email string) *core.Record {
+	t.Helper()
+
+	user, err := CreateUser(email, testPassword, "", true)
+	if err != nil {
+		t.Fatalf("failed to create user %s: %v", email, err)
+	}
+	return user
+}
+
+// signIn starts a session for a user and returns its token
+func signIn(t *testing.T, user *core.Record) string {
+	t.Helper()
+
+	token, err := startSession(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/login", nil), user)
+	if err != nil {
+		t.Fatalf("failed to sign in %s: %v", user.Email(), err)
+	}
+	return token
+}
+
+// postForm calls a handler with a form submission
+func postForm(handler http.HandlerFunc, target string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// capturedEmail is a message received by the SMTP capture server
+type capturedEmail struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// smtpCapture is a minimal SMTP server that keeps every message it receives,
+// standing in for a local capture server such as Mailpit
+type smtpCapture struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []capturedEmail
+	received chan struct{}
+}
+
+// newSMTPCapture starts a capture server on a random local port and points the
+// app's mailer at it
+func newSMTPCapture(t *testing.T, app core.App) *smtpCapture {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	capture := &smtpCapture{listener: listener, received: make(chan struct{}, 100)}
+	go capture.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	settings := app.Settings()
+	settings.SMTP.Enabled = true
+	settings.SMTP.Host = host
+	settings.SMTP.Port, _ = strconv.Atoi(port)
+	settings.SMTP.TLS = false
+	settings.Meta.SenderAddress = "noreply@example.com"
+	settings.Meta.SenderName = "Test"
+
+	return capture
+}
+
+func (c *smtpCapture) serve() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *smtpCapture) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost capture")
+	message := capturedEmail{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = capturedEmail{From: strings.Trim(strings.TrimSpace(line)[10:], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, strings.Trim(strings.TrimSpace(line)[8:], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data bytes.Buffer
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			message.Data = data.Bytes()
+
+			c.mu.Lock()
+			c.messages = append(c.messages, message)
+			c.mu.Unlock()
+			c.received <- struct{}{}
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// waitForEmail returns the next message sent to the address
+func (c *smtpCapture) waitForEmail(t *testing.T, to string) capturedEmail {
+	t.Helper()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		c.mu.Lock()
+		for i, message := range c.messages {
+			for _, recipient := range message.To {
+				if recipient == to {
+					c.messages = append(c.messages[:i], c.messages[i+1:]...)
+					c.mu.Unlock()
+					return message
+				}
+			}
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.received:
+		case <-deadline:
+			t.Fatalf("no email sent to %s", to)
+		}
+	}
+}
+
+// count returns how many messages haven't been picked up yet
+func (c *smtpCapture) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.messages)
+}
+
+// htmlBody decodes the HTML part of the message
+func (m capturedEmail) htmlBody(t *testing.T) string {
+	t.Helper()
+
+	message, err := mail.ReadMessage(bytes.NewReader(m.Data))
+	if err != nil {
+		t.Fatalf("failed to parse email: %v", err)
+	}
+
+	body, ok := findHTMLPart(message.Header.Get("Content-Type"), message.Header.Get("Content-Transfer-Encoding"), message.Body)
+	if !ok {
+		t.Fatalf("email has no HTML part")
+	}
+	return body
+}
+
+// findHTMLPart walks a MIME body and returns the first decoded text/html part
+func findHTMLPart(contentType string, encoding string, body io.Reader) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err != nil {
+				return "", false
+			}
+			if html, ok := findHTMLPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part); ok {
+				return html, true
+			}
+		}
+	}
+
+	if mediaType != "text/html" {
+		return "", false
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
@@ -0,0 +1,693 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Largest webhook payload accepted from the payment provider
+const maxWebhookPayload = 1 << 20
+
+// Subscription statuses that keep the organization on its paid plan
+var activeSubscriptionStatuses = []string{"active", "trialing", "past_due"}
+
+// PaymentProvider is implemented by the payment processors billing can run on
+type PaymentProvider interface {
+	// Name identifies the provider, e.g. "stripe"
+	Name() string
+
+	// CreateCheckoutSession starts a subscription checkout and returns the URL to send the customer to
+	CreateCheckoutSession(ctx context.Context, checkout CheckoutRequest) (string, error)
+
+	// CreatePortalSession returns the URL of the customer's self-service billing portal
+	CreatePortalSession(ctx context.Context, customerId string, returnURL string) (string, error)
+
+	// ParseWebhook verifies the signature of a webhook request and translates its payload
+	ParseWebhook(payload []byte, header http.Header) (*BillingEvent, error)
+}
+
+// CheckoutRequest describes the subscription a checkout session is started for
+type CheckoutRequest struct {
+	OrganizationId string
+	CustomerId     string
+	CustomerEmail  string
+	PlanCode       string
+	PriceId        string
+	Amount         int
+	Currency       string
+	Interval       string
+	SuccessURL     string
+	CancelURL      string
+}
+
+// BillingEvent is a provider webhook translated to the subscription and invoice changes it carries
+type BillingEvent struct {
+	Id           string
+	Type         string
+	Created      time.Time
+	Subscription *SubscriptionUpdate
+	Invoice      *InvoiceUpdate
+}
+
+// SubscriptionUpdate is the latest known state of a subscription. Empty fields are left unchanged.
+type SubscriptionUpdate struct {
+	OrganizationId    string
+	CustomerId        string
+	SubscriptionId    string
+	PriceId           string
+	PlanCode          string
+	Status            string
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+}
+
+// InvoiceUpdate is the latest known state of an invoice
+type InvoiceUpdate struct {
+	InvoiceId      string
+	CustomerId     string
+	SubscriptionId string
+	Number         string
+	Status         string
+	AmountDue      int
+	AmountPaid     int
+	Currency       string
+	HostedURL      string
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+}
+
+// Payment provider used for checkouts and webhooks, set by ConfigureBilling. Billing is disabled when nil.
+var BillingProvider PaymentProvider
+
+// PlanInfo represents a plan on the billing page
+type PlanInfo struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Price       int      `json:"price"`
+	Currency    string   `json:"currency"`
+	Interval    string   `json:"interval"`
+	Features    []string `json:"features"`
+	Current     bool     `json:"current"`
+}
+
+// SubscriptionInfo represents the subscription of an organization
+type SubscriptionInfo struct {
+	Plan              string    `json:"plan"`
+	PlanName          string    `json:"planName"`
+	Status            string    `json:"status"`
+	CurrentPeriodEnd  time.Time `json:"currentPeriodEnd"`
+	CancelAtPeriodEnd bool      `json:"cancelAtPeriodEnd"`
+	HasCustomer       bool      `json:"hasCustomer"`
+}
+
+// InvoiceInfo represents an invoice in the billing history
+type InvoiceInfo struct {
+	Id        string    `json:"id"`
+	Number    string    `json:"number"`
+	Status    string    `json:"status"`
+	Amount    int       `json:"amount"`
+	Currency  string    `json:"currency"`
+	HostedURL string    `json:"hostedUrl,omitempty"`
+	Date      time.Time `json:"date"`
+}
+
+// BillingData represents the data for the billing page
+type BillingData struct {
+	Organization string            `json:"organization"`
+	Enabled      bool              `json:"enabled"`
+	Plans        []PlanInfo        `json:"plans"`
+	Subscription *SubscriptionInfo `json:"subscription"`
+	Subscribed   bool              `json:"subscribed"`
+	Invoices     []InvoiceInfo     `json:"invoices"`
+	Error        string            `json:"error,omitempty"`
+	Success      string            `json:"success,omitempty"`
+}
+
+// formatAmount formats an amount in the currency's minor unit, e.g. 1900 usd as $19.00
+func formatAmount(amount int, currency string) string {
+	value := fmt.Sprintf("%d.%02d", amount/100, amount%100)
+
+	switch strings.ToLower(currency) {
+	case "usd":
+		return "$" + value
+	case "eur":
+		return "€" + value
+	case "gbp":
+		return "£" + value
+	}
+
+	return value + " " + strings.ToUpper(currency)
+}
+
+// PriceLabel formats the plan's price for display
+func (p PlanInfo) PriceLabel() string {
+	if p.Price == 0 {
+		return "Free"
+	}
+	return formatAmount(p.Price, p.Currency) + " / " + p.Interval
+}
+
+// AmountLabel formats the invoice amount for display
+func (i InvoiceInfo) AmountLabel() string {
+	return formatAmount(i.Amount, i.Currency)
+}
+
+// ConfigureBilling picks the payment provider from the environment and applies Stripe price
+// ids to the plans. Billing stays disabled when no provider is configured.
+func ConfigureBilling(app core.App) error {
+	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+
+	switch provider := os.Getenv("BILLING_PROVIDER"); {
+	case provider == "fake":
+		BillingProvider = NewFakeProvider(webhookSecret)
+	case provider == "stripe" || (provider == "" && os.Getenv("STRIPE_SECRET_KEY") != ""):
+		secretKey := os.Getenv("STRIPE_SECRET_KEY")
+		if secretKey == "" {
+			return errors.New("STRIPE_SECRET_KEY is required for the stripe billing provider")
+		}
+		BillingProvider = NewStripeProvider(secretKey, webhookSecret)
+	case provider == "":
+		return nil
+	default:
+		return fmt.Errorf("unknown billing provider %q", provider)
+	}
+
+	if webhookSecret == "" {
+		log.Printf("⚠️ STRIPE_WEBHOOK_SECRET is not set, billing webhooks will be rejected")
+	}
+
+	plans, err := app.FindAllRecords("plans")
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		priceId := os.Getenv("STRIPE_PRICE_" + strings.ToUpper(plan.GetString("code")))
+		if priceId == "" || priceId == plan.GetString("providerPriceId") {
+			continue
+		}
+
+		plan.Set("providerPriceId", priceId)
+		if err := app.Save(plan); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("💳 Billing enabled with the %s provider", BillingProvider.Name())
+
+	return nil
+}
+
+// findPlan returns the plan matching a provider price id, falling back to the plan code
+func findPlan(app core.App, priceId string, code string) (*core.Record, error) {
+	if priceId != "" {
+		if plan, err := app.FindFirstRecordByData("plans", "providerPriceId", priceId); err == nil {
+			return plan, nil
+		}
+	}
+
+	if code != "" {
+		return app.FindFirstRecordByData("plans", "code", code)
+	}
+
+	return nil, errors.New("plan not found")
+}
+
+// findOrganizationSubscription returns the subscription record of an organization
+func findOrganizationSubscription(organizationId string) (*core.Record, error) {
+	return PbClient.FindFirstRecordByData("subscriptions", "organization", organizationId)
+}
+
+// isActiveSubscription reports whether a subscription keeps its organization on the paid plan
+func isActiveSubscription(subscription *core.Record) bool {
+	if subscription == nil {
+		return false
+	}
+
+	status := subscription.GetString("status")
+	for _, active := range activeSubscriptionStatuses {
+		if status == active {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applySubscriptionUpdate stores the new state of a subscription. Updates are matched by
+// subscription id, then organization, then customer, since checkout completion and the
+// subscription events can arrive in any order. An event created before the last one applied
+// to the subscription is ignored.
+func applySubscriptionUpdate(app core.App, update *SubscriptionUpdate, created time.Time) error {
+	var subscription *core.Record
+	matchedById := false
+
+	if update.SubscriptionId != "" {
+		if record, err := app.FindFirstRecordByData("subscriptions", "providerSubscriptionId", update.SubscriptionId); err == nil {
+			subscription = record
+			matchedById = true
+		}
+	}
+	if subscription == nil && update.OrganizationId != "" {
+		subscription, _ = app.FindFirstRecordByData("subscriptions", "organization", update.OrganizationId)
+	}
+	if subscription == nil && update.CustomerId != "" {
+		subscription, _ = app.FindFirstRecordByData("subscriptions", "providerCustomerId", update.CustomerId)
+	}
+
+	if subscription == nil {
+		if update.OrganizationId == "" {
+			log.Printf("⚠️ Ignoring billing event for unknown subscription %s", update.SubscriptionId)
+			return nil
+		}
+		if _, err := app.FindRecordById("organizations", update.OrganizationId); err != nil {
+			log.Printf("⚠️ Ignoring billing event for unknown organization %s", update.OrganizationId)
+			return nil
+		}
+
+		subscriptions, err := app.FindCollectionByNameOrId("subscriptions")
+		if err != nil {
+			return err
+		}
+		subscription = core.NewRecord(subscriptions)
+		subscription.Set("organization", update.OrganizationId)
+		subscription.Set("status", "incomplete")
+	} else if staleBillingEvent(subscription, created) {
+		log.Printf("⚠️ Ignoring billing event from %s older than the last update of subscription %s", created, subscription.Id)
+		return nil
+	} else if !matchedById && update.Status != "" && subscription.GetString("providerSubscriptionId") != "" && isActiveSubscription(subscription) {
+		// A late event about an older subscription must not overwrite the one that replaced it
+		if status := update.Status; status != "active" && status != "trialing" {
+			log.Printf("⚠️ Ignoring %s update for replaced subscription %s", status, update.SubscriptionId)
+			return nil
+		}
+	}
+
+	if update.CustomerId != "" {
+		subscription.Set("providerCustomerId", update.CustomerId)
+	}
+	if update.SubscriptionId != "" {
+		subscription.Set("providerSubscriptionId", update.SubscriptionId)
+	}
+	if plan, err := findPlan(app, update.PriceId, update.PlanCode); err == nil {
+		subscription.Set("plan", plan.Id)
+	}
+	if !update.CurrentPeriodEnd.IsZero() {
+		subscription.Set("currentPeriodEnd", update.CurrentPeriodEnd)
+	}
+
+	// Checkout completion carries no status of its own
+	if update.Status != "" {
+		subscription.Set("status", update.Status)
+		subscription.Set("cancelAtPeriodEnd", update.CancelAtPeriodEnd)
+	}
+	if !created.IsZero() {
+		subscription.Set("lastEventAt", created)
+	}
+
+	return app.Save(subscription)
+}
+
+// applyInvoiceUpdate stores the new state of an invoice of a known subscription, unless
+// a later event already updated it
+func applyInvoiceUpdate(app core.App, update *InvoiceUpdate, created time.Time) error {
+	var subscription *core.Record
+	if update.SubscriptionId != "" {
+		subscription, _ = app.FindFirstRecordByData("subscriptions", "providerSubscriptionId", update.SubscriptionId)
+	}
+	if subscription == nil && update.CustomerId != "" {
+		subscription, _ = app.FindFirstRecordByData("subscriptions", "providerCustomerId", update.CustomerId)
+	}
+	if subscription == nil {
+		log.Printf("⚠️ Ignoring invoice %s for unknown customer %s", update.InvoiceId, update.CustomerId)
+		return nil
+	}
+
+	invoice, err := app.FindFirstRecordByData("invoices", "providerInvoiceId", update.InvoiceId)
+	if err != nil {
+		invoices, err := app.FindCollectionByNameOrId("invoices")
+		if err != nil {
+			return err
+		}
+		invoice = core.NewRecord(invoices)
+		invoice.Set("providerInvoiceId", update.InvoiceId)
+	} else if staleBillingEvent(invoice, created) {
+		log.Printf("⚠️ Ignoring billing event from %s older than the last update of invoice %s", created, update.InvoiceId)
+		return nil
+	}
+
+	invoice.Set("organization", subscription.GetString("organization"))
+	invoice.Set("subscription", subscription.Id)
+	invoice.Set("number", update.Number)
+	invoice.Set("status", update.Status)
+	invoice.Set("amountDue", update.AmountDue)
+	invoice.Set("amountPaid", update.AmountPaid)
+	invoice.Set("currency", strings.ToLower(update.Currency))
+	invoice.Set("hostedUrl", update.HostedURL)
+	if !update.PeriodStart.IsZero() {
+		invoice.Set("periodStart", update.PeriodStart)
+	}
+	if !update.PeriodEnd.IsZero() {
+		invoice.Set("periodEnd", update.PeriodEnd)
+	}
+	if !created.IsZero() {
+		invoice.Set("lastEventAt", created)
+	}
+
+	return app.Save(invoice)
+}
+
+// staleBillingEvent reports whether a subscription or invoice was already updated by an
+// event created after this one. Events created within the same second are all applied.
+func staleBillingEvent(record *core.Record, created time.Time) bool {
+	return !created.IsZero() && record.GetDateTime("lastEventAt").Time().After(created)
+}
+
+// applyBillingEvent stores the subscription and invoice changes of a webhook event.
+// Events are recorded by id in the same transaction, so a redelivered event is skipped
+// and one that failed is applied when the provider retries it.
+func applyBillingEvent(event *BillingEvent) error {
+	return PbClient.RunInTransaction(func(txApp core.App) error {
+		if event.Id != "" {
+			if _, err := txApp.FindFirstRecordByData("billing_events", "eventId", event.Id); err == nil {
+				log.Printf("💳 Skipping already processed billing event %s (%s)", event.Id, event.Type)
+				return nil
+			}
+
+			collection, err := txApp.FindCollectionByNameOrId("billing_events")
+			if err != nil {
+				return err
+			}
+			record := core.NewRecord(collection)
+			record.Set("eventId", event.Id)
+			record.Set("type", event.Type)
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+
+		if event.Subscription != nil {
+			if err := applySubscriptionUpdate(txApp, event.Subscription, event.Created); err != nil {
+				return err
+			}
+		}
+
+		if event.Invoice != nil {
+			if err := applyInvoiceUpdate(txApp, event.Invoice, event.Created); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// listPlans returns the active plans, cheapest first
+func listPlans(currentCode string) ([]PlanInfo, error) {
+	records, err := PbClient.FindRecordsByFilter("plans", "active = true", "price", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]PlanInfo, 0, len(records))
+	for _, record := range records {
+		var features []string
+		record.UnmarshalJSONField("features", &features)
+
+		plans = append(plans, PlanInfo{
+			Code:        record.GetString("code"),
+			Name:        record.GetString("name"),
+			Description: record.GetString("description"),
+			Price:       record.GetInt("price"),
+			Currency:    record.GetString("currency"),
+			Interval:    record.GetString("interval"),
+			Features:    features,
+			Current:     record.GetString("code") == currentCode,
+		})
+	}
+
+	return plans, nil
+}
+
+// subscriptionInfo describes an organization's subscription for display
+func subscriptionInfo(subscription *core.Record) *SubscriptionInfo {
+	info := &SubscriptionInfo{
+		Status:            subscription.GetString("status"),
+		CurrentPeriodEnd:  subscription.GetDateTime("currentPeriodEnd").Time(),
+		CancelAtPeriodEnd: subscription.GetBool("cancelAtPeriodEnd"),
+		HasCustomer:       subscription.GetString("providerCustomerId") != "",
+	}
+
+	if plan, err := PbClient.FindRecordById("plans", subscription.GetString("plan")); err == nil {
+		info.Plan = plan.GetString("code")
+		info.PlanName = plan.GetString("name")
+	}
+
+	return info
+}
+
+// listInvoices returns the invoices of an organization, newest first
+func listInvoices(organizationId string) ([]InvoiceInfo, error) {
+	records, err := PbClient.FindRecordsByFilter(
+		"invoices",
+		"organization = {:organization}",
+		"-created",
+		50,
+		0,
+		dbx.Params{"organization": organizationId},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices := make([]InvoiceInfo, 0, len(records))
+	for _, record := range records {
+		amount := record.GetInt("amountPaid")
+		if record.GetString("status") != "paid" {
+			amount = record.GetInt("amountDue")
+		}
+
+		date := record.GetDateTime("periodStart").Time()
+		if date.IsZero() {
+			date = record.GetDateTime("created").Time()
+		}
+
+		invoices = append(invoices, InvoiceInfo{
+			Id:        record.Id,
+			Number:    record.GetString("number"),
+			Status:    record.GetString("status"),
+			Amount:    amount,
+			Currency:  record.GetString("currency"),
+			HostedURL: record.GetString("hostedUrl"),
+			Date:      date,
+		})
+	}
+
+	return invoices, nil
+}
+
+// renderBilling renders the billing page, or the same data as JSON for API clients
+func renderBilling(w http.ResponseWriter, r *http.Request, data BillingData) {
+	organization := GetCurrentOrganization(r)
+
+	data.Organization = organization.GetString("name")
+	data.Enabled = BillingProvider != nil
+
+	currentPlan := "free"
+	if subscription, err := findOrganizationSubscription(organization.Id); err == nil {
+		data.Subscription = subscriptionInfo(subscription)
+		data.Subscribed = isActiveSubscription(subscription)
+		if data.Subscribed && data.Subscription.Plan != "" {
+			currentPlan = data.Subscription.Plan
+		}
+	}
+
+	if plans, err := listPlans(currentPlan); err == nil {
+		data.Plans = plans
+	}
+
+	if invoices, err := listInvoices(organization.Id); err == nil {
+		data.Invoices = invoices
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "billing.html", data); err != nil {
+		http.Error(w, "Error rendering billing page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// BillingHandler shows the plans, subscription and invoices of the active organization
+func BillingHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentOrganization(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	data := BillingData{}
+	switch r.URL.Query().Get("checkout") {
+	case "success":
+		data.Success = "Thanks for subscribing! Your plan will be updated as soon as the payment is confirmed."
+	case "canceled":
+		data.Error = "Checkout was canceled and you have not been charged."
+	}
+
+	renderBilling(w, r, data)
+}
+
+// redirectToProvider sends the browser to a checkout or portal URL, or returns it to API clients
+func redirectToProvider(w http.ResponseWriter, r *http.Request, url string) {
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"url": url,
+		})
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// CheckoutHandler starts a checkout for the selected plan
+func CheckoutHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	organization := GetCurrentOrganization(r)
+	if user == nil || organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if BillingProvider == nil {
+		renderBilling(w, r, BillingData{Error: "Billing is not configured"})
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := PbClient.FindFirstRecordByData("plans", "code", r.FormValue("plan"))
+	if err != nil || !plan.GetBool("active") {
+		renderBilling(w, r, BillingData{Error: "Plan not found"})
+		return
+	}
+	if plan.GetInt("price") == 0 {
+		renderBilling(w, r, BillingData{Error: "The " + plan.GetString("name") + " plan doesn't need a checkout. Cancel your subscription from the billing portal to switch to it."})
+		return
+	}
+
+	checkout := CheckoutRequest{
+		OrganizationId: organization.Id,
+		CustomerEmail:  user.Email(),
+		PlanCode:       plan.GetString("code"),
+		PriceId:        plan.GetString("providerPriceId"),
+		Amount:         plan.GetInt("price"),
+		Currency:       plan.GetString("currency"),
+		Interval:       plan.GetString("interval"),
+		SuccessURL:     AppURL + "/billing?checkout=success",
+		CancelURL:      AppURL + "/billing?checkout=canceled",
+	}
+
+	if subscription, err := findOrganizationSubscription(organization.Id); err == nil {
+		// Plan changes of an existing subscription go through the portal to avoid double billing
+		if isActiveSubscription(subscription) {
+			renderBilling(w, r, BillingData{Error: "Your organization already has a subscription. Use Manage Billing to change your plan."})
+			return
+		}
+		checkout.CustomerId = subscription.GetString("providerCustomerId")
+	}
+
+	url, err := BillingProvider.CreateCheckoutSession(r.Context(), checkout)
+	if err != nil {
+		log.Printf("⚠️ Failed to create checkout session: %v", err)
+		renderBilling(w, r, BillingData{Error: "Failed to start checkout. Please try again later."})
+		return
+	}
+
+	redirectToProvider(w, r, url)
+}
+
+// BillingPortalHandler sends the customer to the provider's billing portal
+func BillingPortalHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if BillingProvider == nil {
+		renderBilling(w, r, BillingData{Error: "Billing is not configured"})
+		return
+	}
+
+	subscription, err := findOrganizationSubscription(organization.Id)
+	if err != nil || subscription.GetString("providerCustomerId") == "" {
+		renderBilling(w, r, BillingData{Error: "Your organization doesn't have a billing account yet. Choose a plan first."})
+		return
+	}
+
+	url, err := BillingProvider.CreatePortalSession(r.Context(), subscription.GetString("providerCustomerId"), AppURL+"/billing")
+	if err != nil {
+		log.Printf("⚠️ Failed to create billing portal session: %v", err)
+		renderBilling(w, r, BillingData{Error: "Failed to open the billing portal. Please try again later."})
+		return
+	}
+
+	redirectToProvider(w, r, url)
+}
+
+// BillingWebhookHandler receives signed subscription and invoice events from the payment provider
+func BillingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if BillingProvider == nil {
+		writeJSONError(w, http.StatusNotFound, "Billing is not configured")
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to read webhook payload")
+		return
+	}
+
+	event, err := BillingProvider.ParseWebhook(payload, r.Header)
+	if err != nil {
+		log.Printf("⚠️ Rejected billing webhook: %v", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid webhook")
+		return
+	}
+
+	// Failing here makes the provider retry the event later
+	if err := applyBillingEvent(event); err != nil {
+		log.Printf("❌ Failed to apply billing event %s (%s): %v", event.Id, event.Type, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to process webhook")
+		return
+	}
+
+	log.Printf("💳 Processed billing event %s (%s)", event.Id, event.Type)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"received": true,
+	})
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// FakeProvider is an in-process payment provider for local development and tests.
+// Checkouts succeed immediately without charging anyone, and webhooks use Stripe's
+// event format so the same fixtures can be replayed against it.
+type FakeProvider struct {
+	WebhookSecret string
+
+	// Deliver receives the events a real provider would send after a checkout.
+	// It applies them to the database unless replaced.
+	Deliver func(event *BillingEvent) error
+
+	mu        sync.Mutex
+	Checkouts []CheckoutRequest
+	Portals   []string
+}
+
+// NewFakeProvider creates a fake provider that verifies webhooks with the given secret
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		WebhookSecret: webhookSecret,
+		Deliver:       applyBillingEvent,
+	}
+}
+
+// Name identifies the provider
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// fakeId returns a random id with the given prefix, e.g. "sub_fake_..."
+func fakeId(prefix string) string {
+	return prefix + "_fake_" + security.RandomStringWithAlphabet(14, "abcdefghijklmnopqrstuvwxyz0123456789")
+}
+
+// CreateCheckoutSession records the checkout, delivers an active subscription with a paid
+// first invoice and sends the customer straight to the success URL
+func (p *FakeProvider) CreateCheckoutSession(ctx context.Context, checkout CheckoutRequest) (string, error) {
+	p.mu.Lock()
+	p.Checkouts = append(p.Checkouts, checkout)
+	p.mu.Unlock()
+
+	if p.Deliver == nil {
+		return checkout.SuccessURL, nil
+	}
+
+	customerId := checkout.CustomerId
+	if customerId == "" {
+		customerId = fakeId("cus")
+	}
+	subscriptionId := fakeId("sub")
+
+	now := time.Now().UTC()
+	periodEnd := now.AddDate(0, 1, 0)
+	if checkout.Interval == "year" {
+		periodEnd = now.AddDate(1, 0, 0)
+	}
+
+	err := p.Deliver(&BillingEvent{
+		Id:      fakeId("evt"),
+		Type:    "customer.subscription.created",
+		Created: now,
+		Subscription: &SubscriptionUpdate{
+			OrganizationId:   checkout.OrganizationId,
+			CustomerId:       customerId,
+			SubscriptionId:   subscriptionId,
+			PriceId:          checkout.PriceId,
+			PlanCode:         checkout.PlanCode,
+			Status:           "active",
+			CurrentPeriodEnd: periodEnd,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = p.Deliver(&BillingEvent{
+		Id:      fakeId("evt"),
+		Type:    "invoice.paid",
+		Created: now,
+		Invoice: &InvoiceUpdate{
+			InvoiceId:      fakeId("in"),
+			CustomerId:     customerId,
+			SubscriptionId: subscriptionId,
+			Number:         "FAKE-" + now.Format("20060102150405"),
+			Status:         "paid",
+			AmountDue:      checkout.Amount,
+			AmountPaid:     checkout.Amount,
+			Currency:       checkout.Currency,
+			PeriodStart:    now,
+			PeriodEnd:      periodEnd,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return checkout.SuccessURL, nil
+}
+
+// CreatePortalSession records the request and returns to the app, as there's no portal to show
+func (p *FakeProvider) CreatePortalSession(ctx context.Context, customerId string, returnURL string) (string, error) {
+	p.mu.Lock()
+	p.Portals = append(p.Portals, customerId)
+	p.mu.Unlock()
+
+	return returnURL, nil
+}
+
+// ParseWebhook verifies and translates a webhook in Stripe's event format
+func (p *FakeProvider) ParseWebhook(payload []byte, header http.Header) (*BillingEvent, error) {
+	return parseStripeWebhook(payload, header, p.WebhookSecret)
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How old a signed Stripe webhook may be before it's rejected as a replay
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeProvider talks to the Stripe API over plain HTTP
+type StripeProvider struct {
+	SecretKey     string
+	WebhookSecret string
+	BaseURL       string
+	Client        *http.Client
+}
+
+// NewStripeProvider creates a Stripe provider for the given API key and webhook signing secret
+func NewStripeProvider(secretKey string, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		BaseURL:       "https://api.stripe.com",
+		Client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name identifies the provider
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+// post sends a form encoded request to the Stripe API and returns the "url" of the created object
+func (p *StripeProvider) post(ctx context.Context, path string, form url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.SecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		URL   string `json:"url"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("stripe: unexpected response (%d): %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("stripe: %s (%d)", result.Error.Message, resp.StatusCode)
+	}
+
+	return result.URL, nil
+}
+
+// CreateCheckoutSession creates a Stripe Checkout session in subscription mode
+func (p *StripeProvider) CreateCheckoutSession(ctx context.Context, checkout CheckoutRequest) (string, error) {
+	if checkout.PriceId == "" {
+		return "", fmt.Errorf("stripe: plan %q has no price id, set STRIPE_PRICE_%s", checkout.PlanCode, strings.ToUpper(checkout.PlanCode))
+	}
+
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("line_items[0][price]", checkout.PriceId)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("success_url", checkout.SuccessURL)
+	form.Set("cancel_url", checkout.CancelURL)
+	form.Set("client_reference_id", checkout.OrganizationId)
+	form.Set("metadata[organization]", checkout.OrganizationId)
+	form.Set("metadata[plan]", checkout.PlanCode)
+	form.Set("subscription_data[metadata][organization]", checkout.OrganizationId)
+	form.Set("subscription_data[metadata][plan]", checkout.PlanCode)
+	if checkout.CustomerId != "" {
+		form.Set("customer", checkout.CustomerId)
+	} else {
+		form.Set("customer_email", checkout.CustomerEmail)
+	}
+
+	return p.post(ctx, "/v1/checkout/sessions", form)
+}
+
+// CreatePortalSession creates a Stripe customer portal session
+func (p *StripeProvider) CreatePortalSession(ctx context.Context, customerId string, returnURL string) (string, error) {
+	form := url.Values{}
+	form.Set("customer", customerId)
+	form.Set("return_url", returnURL)
+
+	return p.post(ctx, "/v1/billing_portal/sessions", form)
+}
+
+// ParseWebhook verifies the Stripe-Signature header and translates the event
+func (p *StripeProvider) ParseWebhook(payload []byte, header http.Header) (*BillingEvent, error) {
+	return parseStripeWebhook(payload, header, p.WebhookSecret)
+}
+
+// signStripePayload computes the v1 signature of a webhook payload sent at timestamp
+func signStripePayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyStripeSignature checks a "t=<timestamp>,v1=<signature>" header against the payload
+func verifyStripeSignature(payload []byte, header string, secret string) error {
+	if secret == "" {
+		return errors.New("webhook signing secret is not configured")
+	}
+
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.New("missing or malformed signature header")
+	}
+
+	if age := time.Since(time.Unix(timestamp, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return errors.New("signature timestamp is outside the tolerance")
+	}
+
+	expected := signStripePayload(secret, timestamp, payload)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+
+	return errors.New("signature mismatch")
+}
+
+// Parts of Stripe objects billing cares about
+type stripeEvent struct {
+	Id      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+type stripeCheckoutSession struct {
+	Mode              string            `json:"mode"`
+	ClientReferenceId string            `json:"client_reference_id"`
+	Customer          string            `json:"customer"`
+	Subscription      string            `json:"subscription"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeSubscription struct {
+	Id                string            `json:"id"`
+	Customer          string            `json:"customer"`
+	Status            string            `json:"status"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	Metadata          map[string]string `json:"metadata"`
+	Items             struct {
+		Data []struct {
+			CurrentPeriodEnd int64 `json:"current_period_end"`
+			Price            struct {
+				Id string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+type stripeInvoice struct {
+	Id               string `json:"id"`
+	Number           string `json:"number"`
+	Customer         string `json:"customer"`
+	Subscription     string `json:"subscription"`
+	Status           string `json:"status"`
+	AmountDue        int    `json:"amount_due"`
+	AmountPaid       int    `json:"amount_paid"`
+	Currency         string `json:"currency"`
+	HostedInvoiceURL string `json:"hosted_invoice_url"`
+	PeriodStart      int64  `json:"period_start"`
+	PeriodEnd        int64  `json:"period_end"`
+	Parent           struct {
+		SubscriptionDetails struct {
+			Subscription string `json:"subscription"`
+		} `json:"subscription_details"`
+	} `json:"parent"`
+}
+
+// unixTime converts a Stripe timestamp, leaving unset ones zero
+func unixTime(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0).UTC()
+}
+
+// parseStripeWebhook verifies and translates a webhook in Stripe's event format.
+// Events billing doesn't track are returned without changes.
+func parseStripeWebhook(payload []byte, header http.Header, secret string) (*BillingEvent, error) {
+	if err := verifyStripeSignature(payload, header.Get("Stripe-Signature"), secret); err != nil {
+		return nil, err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event payload: %w", err)
+	}
+
+	result := &BillingEvent{Id: event.Id, Type: event.Type, Created: unixTime(event.Created)}
+
+	switch {
+	case event.Type == "checkout.session.completed":
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, fmt.Errorf("invalid checkout session: %w", err)
+		}
+		if session.Mode != "subscription" {
+			return result, nil
+		}
+
+		organizationId := session.ClientReferenceId
+		if organizationId == "" {
+			organizationId = session.Metadata["organization"]
+		}
+
+		result.Subscription = &SubscriptionUpdate{
+			OrganizationId: organizationId,
+			CustomerId:     session.Customer,
+			SubscriptionId: session.Subscription,
+			PlanCode:       session.Metadata["plan"],
+		}
+
+	case strings.HasPrefix(event.Type, "customer.subscription."):
+		var subscription stripeSubscription
+		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return nil, fmt.Errorf("invalid subscription: %w", err)
+		}
+
+		update := &SubscriptionUpdate{
+			OrganizationId:    subscription.Metadata["organization"],
+			CustomerId:        subscription.Customer,
+			SubscriptionId:    subscription.Id,
+			PlanCode:          subscription.Metadata["plan"],
+			Status:            subscription.Status,
+			CurrentPeriodEnd:  unixTime(subscription.CurrentPeriodEnd),
+			CancelAtPeriodEnd: subscription.CancelAtPeriodEnd,
+		}
+
+		// Newer API versions report the billing period per subscription item
+		if len(subscription.Items.Data) > 0 {
+			item := subscription.Items.Data[0]
+			update.PriceId = item.Price.Id
+			if update.CurrentPeriodEnd.IsZero() {
+				update.CurrentPeriodEnd = unixTime(item.CurrentPeriodEnd)
+			}
+		}
+
+		if event.Type == "customer.subscription.deleted" {
+			update.Status = "canceled"
+		}
+
+		result.Subscription = update
+
+	case strings.HasPrefix(event.Type, "invoice."):
+		var invoice stripeInvoice
+		if err := json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return nil, fmt.Errorf("invalid invoice: %w", err)
+		}
+
+		subscriptionId := invoice.Subscription
+		if subscriptionId == "" {
+			subscriptionId = invoice.Parent.SubscriptionDetails.Subscription
+		}
+
+		result.Invoice = &InvoiceUpdate{
+			InvoiceId:      invoice.Id,
+			CustomerId:     invoice.Customer,
+			SubscriptionId: subscriptionId,
+			Number:         invoice.Number,
+			Status:         invoice.Status,
+			AmountDue:      invoice.AmountDue,
+			AmountPaid:     invoice.AmountPaid,
+			Currency:       invoice.Currency,
+			HostedURL:      invoice.HostedInvoiceURL,
+			PeriodStart:    unixTime(invoice.PeriodStart),
+			PeriodEnd:      unixTime(invoice.PeriodEnd),
+		}
+	}
+
+	return result, nil
+}
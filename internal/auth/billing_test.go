@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// fixtureReplay renders the Stripe event fixtures for one organization and posts
+// them to the billing webhook, like cmd/replay-webhook does against a running server
+type fixtureReplay struct {
+	Organization string
+	Customer     string
+	Subscription string
+	Invoice      string
+	Plan         string
+	Price        string
+	Now          int64
+	PeriodEnd    int64
+	EventId      string
+
+	events int
+}
+
+// newFixtureReplay configures the fake provider as the billing provider and returns
+// a replay for a new organization owned by a new user
+func newFixtureReplay(t *testing.T) (*fixtureReplay, *core.Record) {
+	t.Helper()
+
+	provider := NewFakeProvider(testWebhookSecret)
+	previous := BillingProvider
+	BillingProvider = provider
+	t.Cleanup(func() { BillingProvider = previous })
+
+	owner := createTestUser(t, "billing@example.com")
+	organization, err := createOrganization(PbClient, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	return &fixtureReplay{
+		Organization: organization.Id,
+		Customer:     "cus_test_" + organization.Id,
+		Subscription: "sub_test_" + organization.Id,
+		Invoice:      "in_test_" + organization.Id,
+		Plan:         "pro",
+		Now:          now.Unix(),
+		PeriodEnd:    now.AddDate(0, 1, 0).Unix(),
+	}, organization
+}
+
+// signWebhook computes a Stripe-Signature header for a payload
+func signWebhook(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// render fills in the placeholders of a fixture with a fresh event id
+func (f *fixtureReplay) render(t *testing.T, fixture string) []byte {
+	t.Helper()
+
+	tmpl, err := template.ParseFiles("../fixtures/stripe/" + fixture + ".json")
+	if err != nil {
+		t.Fatalf("failed to read fixture %s: %v", fixture, err)
+	}
+
+	f.events++
+	f.EventId = "evt_test_" + strconv.Itoa(f.events)
+
+	var payload bytes.Buffer
+	if err := tmpl.Execute(&payload, f); err != nil {
+		t.Fatalf("failed to render fixture %s: %v", fixture, err)
+	}
+	return payload.Bytes()
+}
+
+// postBillingWebhook sends a payload to the webhook with the given signature header
+func postBillingWebhook(payload []byte, signature string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/billing/webhook", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Stripe-Signature", signature)
+	w := httptest.NewRecorder()
+	BillingWebhookHandler(w, r)
+	return w
+}
+
+// replay signs and posts a fixture, failing the test unless it is accepted
+func (f *fixtureReplay) replay(t *testing.T, fixture string) {
+	t.Helper()
+
+	payload := f.render(t, fixture)
+	w := postBillingWebhook(payload, signWebhook(testWebhookSecret, time.Now().Unix(), payload))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s was rejected: %d %s", fixture, w.Code, w.Body.String())
+	}
+}
+
+func TestBillingWebhookFixtureReplay(t *testing.T) {
+	app := newTestApp(t)
+	replay, organization := newFixtureReplay(t)
+
+	if plan := organizationEntitlements(organization.Id).Plan; plan != "free" {
+		t.Fatalf("new organization is on the %s plan", plan)
+	}
+
+	steps := []struct {
+		fixture           string
+		status            string
+		plan              string
+		cancelAtPeriodEnd bool
+	}{
+		// Checkout completion only links the subscription, the status comes with its own event
+		{fixture: "checkout_session_completed", status: "incomplete", plan: "free"},
+		{fixture: "customer_subscription_created", status: "active", plan: "pro"},
+		{fixture: "invoice_paid", status: "active", plan: "pro"},
+		{fixture: "customer_subscription_updated", status: "active", plan: "pro", cancelAtPeriodEnd: true},
+		{fixture: "invoice_payment_failed", status: "active", plan: "pro", cancelAtPeriodEnd: true},
+		// Past due subscriptions keep their plan while the provider retries the payment
+		{fixture: "customer_subscription_past_due", status: "past_due", plan: "pro"},
+		{fixture: "customer_subscription_deleted", status: "canceled", plan: "free"},
+	}
+
+	for _, step := range steps {
+		replay.replay(t, step.fixture)
+
+		subscription, err := findOrganizationSubscription(organization.Id)
+		if err != nil {
+			t.Fatalf("after %s: no subscription: %v", step.fixture, err)
+		}
+		if status := subscription.GetString("status"); status != step.status {
+			t.Errorf("after %s: status %q, want %q", step.fixture, status, step.status)
+		}
+		if cancel := subscription.GetBool("cancelAtPeriodEnd"); cancel != step.cancelAtPeriodEnd {
+			t.Errorf("after %s: cancelAtPeriodEnd %v, want %v", step.fixture, cancel, step.cancelAtPeriodEnd)
+		}
+		if subscription.GetString("providerCustomerId") != replay.Customer || subscription.GetString("providerSubscriptionId") != replay.Subscription {
+			t.Errorf("after %s: subscription linked to %s/%s", step.fixture,
+				subscription.GetString("providerCustomerId"), subscription.GetString("providerSubscriptionId"))
+		}
+		if plan := organizationEntitlements(organization.Id).Plan; plan != step.plan {
+			t.Errorf("after %s: organization on the %s plan, want %s", step.fixture, plan, step.plan)
+		}
+	}
+
+	invoice, err := app.FindFirstRecordByData("invoices", "providerInvoiceId", replay.Invoice)
+	if err != nil {
+		t.Fatalf("invoice wasn't stored: %v", err)
+	}
+	if invoice.GetString("organization") != organization.Id || invoice.GetString("status") != "open" {
+		t.Errorf("unexpected invoice organization=%s status=%s", invoice.GetString("organization"), invoice.GetString("status"))
+	}
+}
+
+func TestBillingWebhookRedeliveryIsIdempotent(t *testing.T) {
+	app := newTestApp(t)
+	replay, organization := newFixtureReplay(t)
+
+	for i := 0; i < 2; i++ {
+		replay.replay(t, "customer_subscription_created")
+		replay.replay(t, "invoice_paid")
+	}
+
+	subscriptions, _ := app.CountRecords("subscriptions", dbx.HashExp{"organization": organization.Id})
+	invoices, _ := app.CountRecords("invoices", dbx.HashExp{"organization": organization.Id})
+	if subscriptions != 1 || invoices != 1 {
+		t.Fatalf("redelivered events created %d subscriptions and %d invoices", subscriptions, invoices)
+	}
+}
+
+func TestBillingWebhookSkipsProcessedEvents(t *testing.T) {
+	app := newTestApp(t)
+	replay, organization := newFixtureReplay(t)
+
+	pastDue := replay.render(t, "customer_subscription_past_due")
+	if w := postBillingWebhook(pastDue, signWebhook(testWebhookSecret, time.Now().Unix(), pastDue)); w.Code != http.StatusOK {
+		t.Fatalf("event was rejected: %d %s", w.Code, w.Body.String())
+	}
+	replay.replay(t, "customer_subscription_created")
+
+	// The provider retries an event it didn't see acknowledged
+	if w := postBillingWebhook(pastDue, signWebhook(testWebhookSecret, time.Now().Unix(), pastDue)); w.Code != http.StatusOK {
+		t.Fatalf("redelivered event was rejected: %d %s", w.Code, w.Body.String())
+	}
+
+	subscription, err := findOrganizationSubscription(organization.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := subscription.GetString("status"); status != "active" {
+		t.Fatalf("redelivered event was applied again, status %q", status)
+	}
+	if total, _ := app.CountRecords("billing_events"); total != 2 {
+		t.Fatalf("expected 2 processed events, got %d", total)
+	}
+}
+
+func TestBillingWebhookIgnoresOlderEvents(t *testing.T) {
+	newTestApp(t)
+	replay, organization := newFixtureReplay(t)
+
+	replay.replay(t, "customer_subscription_created")
+	replay.replay(t, "invoice_paid")
+
+	// Events created before the last applied one arrive late
+	replay.Now -= 60
+	replay.replay(t, "customer_subscription_past_due")
+	replay.replay(t, "invoice_payment_failed")
+
+	subscription, err := findOrganizationSubscription(organization.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := subscription.GetString("status"); status != "active" {
+		t.Errorf("older event overwrote the subscription, status %q", status)
+	}
+	invoice, err := PbClient.FindFirstRecordByData("invoices", "providerInvoiceId", replay.Invoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := invoice.GetString("status"); status != "paid" {
+		t.Errorf("older event overwrote the invoice, status %q", status)
+	}
+}
+
+func TestBillingWebhookRejectsBadSignatures(t *testing.T) {
+	app := newTestApp(t)
+	replay, _ := newFixtureReplay(t)
+	payload := replay.render(t, "customer_subscription_created")
+
+	cases := map[string]string{
+		"wrong secret": signWebhook("whsec_other", time.Now().Unix(), payload),
+		"stale":        signWebhook(testWebhookSecret, time.Now().Add(-time.Hour).Unix(), payload),
+		"missing":      "",
+	}
+	for name, signature := range cases {
+		if w := postBillingWebhook(payload, signature); w.Code != http.StatusBadRequest {
+			t.Errorf("%s signature: got %d, want 400", name, w.Code)
+		}
+	}
+
+	// A valid signature doesn't cover a tampered payload
+	tampered := bytes.Replace(payload, []byte(`"active"`), []byte(`"trialing"`), 1)
+	if w := postBillingWebhook(tampered, signWebhook(testWebhookSecret, time.Now().Unix(), payload)); w.Code != http.StatusBadRequest {
+		t.Errorf("tampered payload: got %d, want 400", w.Code)
+	}
+
+	if total, _ := app.CountRecords("subscriptions"); total != 0 {
+		t.Fatalf("rejected webhooks created %d subscriptions", total)
+	}
+}
+
+func TestFakeProviderCheckout(t *testing.T) {
+	app := newTestApp(t)
+	_, organization := newFixtureReplay(t)
+	provider := BillingProvider.(*FakeProvider)
+
+	url, err := provider.CreateCheckoutSession(context.Background(), CheckoutRequest{
+		OrganizationId: organization.Id,
+		PlanCode:       "team",
+		Amount:         4900,
+		Currency:       "usd",
+		Interval:       "month",
+		SuccessURL:     "/billing?success=true",
+	})
+	if err != nil || url != "/billing?success=true" {
+		t.Fatalf("checkout returned %q, %v", url, err)
+	}
+
+	if plan := organizationEntitlements(organization.Id).Plan; plan != "team" {
+		t.Fatalf("organization on the %s plan after checkout, want team", plan)
+	}
+	if invoices, _ := app.CountRecords("invoices", dbx.HashExp{"organization": organization.Id, "status": "paid"}); invoices != 1 {
+		t.Fatalf("expected a paid invoice after checkout, got %d", invoices)
+	}
+	if len(provider.Checkouts) != 1 {
+		t.Fatalf("expected 1 recorded checkout, got %d", len(provider.Checkouts))
+	}
+}
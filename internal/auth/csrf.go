@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Name of the cookie, form field and header carrying the CSRF token
+const (
+	csrfCookieName = "pb_csrf"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// CSRF token context key
+const csrfContextKey contextKey = "csrf"
+
+// Whether cookies should only be sent over HTTPS, set from main
+var SecureCookies = false
+
+// csrfTokenFromRequest returns the CSRF token assigned to the request by CSRFMiddleware
+func csrfTokenFromRequest(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey).(string)
+	return token
+}
+
+// csrfField renders the hidden form input carrying the CSRF token
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// isSafeMethod reports whether the request method can't change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isCrossSiteRequest reports whether a browser flagged the request as coming from another site
+func isCrossSiteRequest(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "cross-site"
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		return origin == "null"
+	}
+
+	parsed, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(parsed.Host, r.Host)
+}
+
+// hasBearerToken reports whether the request authenticates with a bearer token instead of cookies
+func hasBearerToken(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.EqualFold(scheme, "Bearer")
+}
+
+// CSRFMiddleware protects cookie-authenticated requests against cross-site submission.
+// Every visitor gets a random token in the pb_csrf cookie, pages embed it with {{csrfField}},
+// and state-changing requests must echo it back in the csrf_token field or X-CSRF-Token header.
+// Requests carrying a bearer token aren't exposed to CSRF, and API requests are
+// rejected when a browser marks them as cross-site instead.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := cookieValue(r.Cookie(csrfCookieName))
+		if token == "" {
+			token = security.RandomString(32)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   SecureCookies,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if !isSafeMethod(r.Method) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				if isCrossSiteRequest(r) {
+					writeJSONError(w, http.StatusForbidden, "Cross-site request rejected")
+					return
+				}
+			} else if !hasBearerToken(r) {
+				submitted := r.Header.Get(csrfHeaderName)
+				if submitted == "" {
+					submitted = r.PostFormValue(csrfFieldName)
+				}
+
+				if submitted == "" || !security.Equal(submitted, token) {
+					http.Error(w, "Invalid or missing CSRF token. Please go back, reload the page and try again.", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), csrfContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
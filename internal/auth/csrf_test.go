@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(csrfTokenFromRequest(r)))
+	}))
+	call := func(method string, target string, form url.Values, header http.Header, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for key, values := range header {
+			r.Header.Set(key, values[0])
+		}
+		if token != "" {
+			r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Visitors get a token with the page that renders the form
+	w := call(http.MethodGet, "/auth/login", nil, nil, "")
+	token := w.Body.String()
+	if w.Code != http.StatusOK || token == "" {
+		t.Fatalf("page load: got %d without a token", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value != token || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("unexpected CSRF cookie %v", cookies)
+	}
+
+	rejected := []struct {
+		name   string
+		method string
+		target string
+		form   url.Values
+		header http.Header
+	}{
+		// A cross-site form carries the victim's cookies but can't read the token
+		{"form without a token", http.MethodPost, "/auth/login", url.Values{"email": {"victim@example.com"}}, nil},
+		{"form with another token", http.MethodPost, "/auth/logout", url.Values{csrfFieldName: {"guessed"}}, nil},
+		{"header with another token", http.MethodDelete, "/sessions/abc", nil, http.Header{csrfHeaderName: {"guessed"}}},
+		{"cross-site API call", http.MethodPost, "/api/settings/password", nil, http.Header{"Sec-Fetch-Site": {"cross-site"}}},
+		{"API call from another origin", http.MethodPost, "/api/settings/password", nil, http.Header{"Origin": {"https://evil.example"}}},
+	}
+	for _, req := range rejected {
+		if w := call(req.method, req.target, req.form, req.header, token); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", req.name, w.Code)
+		}
+	}
+
+	accepted := []struct {
+		name   string
+		method string
+		target string
+		form   url.Values
+		header http.Header
+	}{
+		{"form with the token", http.MethodPost, "/auth/login", url.Values{csrfFieldName: {token}}, nil},
+		{"header with the token", http.MethodDelete, "/sessions/abc", nil, http.Header{csrfHeaderName: {token}}},
+		{"same-origin API call", http.MethodPost, "/api/settings/password", nil, http.Header{"Sec-Fetch-Site": {"same-origin"}}},
+		{"bearer token request", http.MethodPost, "/organizations", nil, http.Header{"Authorization": {"Bearer pat_abc"}}},
+	}
+	for _, req := range accepted {
+		if w := call(req.method, req.target, req.form, req.header, token); w.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", req.name, w.Code)
+		}
+	}
+}
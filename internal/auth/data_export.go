@@ -0,0 +1,375 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Export statuses
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// How long a finished export can be downloaded
+const dataExportLifetime = 7 * 24 * time.Hour
+
+// Largest export archive stored
+const dataExportMaxSize = 1 << 30
+
+// DataExportInfo represents a requested data export
+type DataExportInfo struct {
+	Id      string    `json:"id"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires,omitempty"`
+	// URL downloads the archive once it's ready
+	URL string `json:"url,omitempty"`
+}
+
+// listDataExports returns the user's unexpired exports, newest first
+func listDataExports(userId string) ([]DataExportInfo, error) {
+	records, err := PbClient.FindRecordsByFilter(
+		"data_exports",
+		"user = {:user} && (expires = '' || expires > {:now})",
+		"-created",
+		0,
+		0,
+		dbx.Params{"user": userId, "now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	exports := make([]DataExportInfo, 0, len(records))
+	for _, record := range records {
+		info := DataExportInfo{
+			Id:      record.Id,
+			Status:  record.GetString("status"),
+			Error:   record.GetString("error"),
+			Created: record.GetDateTime("created").Time(),
+			Expires: record.GetDateTime("expires").Time(),
+		}
+		if info.Status == exportReady {
+			info.URL = "/settings/export/" + record.Id + "/download"
+		}
+		exports = append(exports, info)
+	}
+
+	return exports, nil
+}
+
+// createArchiveFile adds a compressed file dated now to the archive
+func createArchiveFile(archive *zip.Writer, name string) (io.Writer, error) {
+	return archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+}
+
+// addJSONFile writes a value to the archive as an indented JSON file
+func addJSONFile(archive *zip.Writer, name string, value any) error {
+	file, err := createArchiveFile(archive, name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// userAuditEvents returns every audit event the user is the actor of, oldest first
+func userAuditEvents(userId string) ([]AuditEntry, error) {
+	events := []AuditEntry{}
+	for offset := 0; ; offset += auditExportBatchSize {
+		batch, err := findAuditEvents(dbx.HashExp{"actor": userId}, "ASC", auditExportBatchSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, batch...)
+		if len(batch) < auditExportBatchSize {
+			return events, nil
+		}
+	}
+}
+
+// sentInvitations returns the invitations the user sent
+func sentInvitations(userId string) ([]map[string]any, error) {
+	records, err := PbClient.FindRecordsByFilter("invitations", "invitedBy = {:user}", "-created", 0, 0, dbx.Params{"user": userId})
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := make([]map[string]any, 0, len(records))
+	for _, record := range records {
+		invitations = append(invitations, map[string]any{
+			"id":           record.Id,
+			"organization": record.GetString("organization"),
+			"email":        record.GetString("email"),
+			"role":         record.GetString("role"),
+			"expires":      record.GetDateTime("expires").Time(),
+			"created":      record.GetDateTime("created").Time(),
+		})
+	}
+
+	return invitations, nil
+}
+
+// addAvatarFile copies the user's avatar into the archive
+func addAvatarFile(archive *zip.Writer, user *core.Record) error {
+	filename := user.GetString("avatar")
+	if filename == "" {
+		return nil
+	}
+
+	fsys, err := PbClient.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	reader, err := fsys.GetFile(user.BaseFilesPath() + "/" + filename)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := createArchiveFile(archive, "files/"+filename)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, reader)
+	return err
+}
+
+// buildDataExportArchive collects everything stored about a user into a ZIP archive
+func buildDataExportArchive(user *core.Record) ([]byte, error) {
+	memberships, err := listOrganizations(user, "")
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := listSessions(user, "")
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := listAPITokens("user = {:user}", dbx.Params{"user": user.Id})
+	if err != nil {
+		return nil, err
+	}
+	events, err := userAuditEvents(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	invitations, err := sentInvitations(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := []struct {
+		name  string
+		value any
+	}{
+		{"user.json", map[string]any{
+			"id":               user.Id,
+			"email":            user.Email(),
+			"name":             user.GetString("name"),
+			"avatar":           user.GetString("avatar"),
+			"verified":         user.Verified(),
+			"twoFactorEnabled": hasTwoFactorEnabled(user),
+			"created":          user.GetDateTime("created").Time(),
+			"updated":          user.GetDateTime("updated").Time(),
+		}},
+		{"memberships.json", memberships},
+		{"sessions.json", sessions},
+		{"api_tokens.json", tokens},
+		{"invitations_sent.json", invitations},
+		{"audit_events.json", events},
+	}
+	for _, file := range files {
+		if err := addJSONFile(archive, file.name, file.value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := addAvatarFile(archive, user); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// buildDataExport builds a queued export and emails the user once it can be downloaded
+func buildDataExport(export *core.Record) {
+	user, err := PbClient.FindRecordById("users", export.GetString("user"))
+	if err != nil {
+		log.Printf("⚠️ Failed to load user of data export %s: %v", export.Id, err)
+		return
+	}
+
+	content, err := buildDataExportArchive(user)
+	if err == nil {
+		var file *filesystem.File
+		file, err = filesystem.NewFileFromBytes(content, "data-export.zip")
+		if err == nil {
+			export.Set("archive", file)
+			export.Set("status", exportReady)
+			export.Set("expires", time.Now().Add(dataExportLifetime))
+			err = PbClient.Save(export)
+		}
+	}
+	if err == nil {
+		recordUserStorage(user.Id, int64(len(content)))
+	}
+
+	if err != nil {
+		log.Printf("⚠️ Failed to build data export %s: %v", export.Id, err)
+
+		// Reload the export to drop the half-set archive
+		failed, err := PbClient.FindRecordById("data_exports", export.Id)
+		if err != nil {
+			return
+		}
+		failed.Set("status", exportFailed)
+		failed.Set("error", "The export could not be created. Please try again.")
+		failed.Set("expires", time.Now().Add(dataExportLifetime))
+		if err := PbClient.Save(failed); err != nil {
+			log.Printf("⚠️ Failed to mark data export %s as failed: %v", export.Id, err)
+		}
+		return
+	}
+
+	if err := sendEmail(user.Email(), "Your data export is ready", "data_export.html", EmailData{
+		Link: AppURL + "/settings",
+	}); err != nil {
+		log.Printf("⚠️ Failed to send data export email: %v", err)
+	}
+}
+
+// processDataExports builds queued exports and removes expired ones, whose
+// archives are deleted with them
+func processDataExports() {
+	pending, err := PbClient.FindRecordsByFilter("data_exports", "status = {:status}", "created", 0, 0, dbx.Params{"status": exportPending})
+	if err != nil {
+		log.Printf("⚠️ Failed to load data exports: %v", err)
+		return
+	}
+	for _, export := range pending {
+		buildDataExport(export)
+	}
+
+	expired, err := PbClient.FindRecordsByFilter(
+		"data_exports",
+		"expires != '' && expires <= {:now}",
+		"",
+		0,
+		0,
+		dbx.Params{"now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		log.Printf("⚠️ Failed to load expired data exports: %v", err)
+		return
+	}
+	for _, export := range expired {
+		size := storedFileSize(export, "archive")
+		if err := PbClient.Delete(export); err != nil {
+			log.Printf("⚠️ Failed to delete expired data export %s: %v", export.Id, err)
+			continue
+		}
+		recordUserStorage(export.GetString("user"), -size)
+	}
+}
+
+// RequestDataExportHandler queues an export of all the current user's data
+func RequestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "export your data") {
+		return
+	}
+
+	if _, err := PbClient.FindFirstRecordByFilter(
+		"data_exports",
+		"user = {:user} && status = {:status}",
+		dbx.Params{"user": user.Id, "status": exportPending},
+	); err == nil {
+		renderSettings(w, r, user, SettingsData{Error: "Your previous export is still being prepared"})
+		return
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("data_exports")
+	if err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Failed to request the export"})
+		return
+	}
+
+	export := core.NewRecord(collection)
+	export.Set("user", user.Id)
+	export.Set("status", exportPending)
+	if err := PbClient.Save(export); err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Failed to request the export: " + err.Error()})
+		return
+	}
+
+	wakeAccountWorker()
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditDataExport, Target: "data_export:" + export.Id})
+
+	renderSettings(w, r, user, SettingsData{
+		Success: "We're preparing your data export and will email you when it's ready to download.",
+	})
+}
+
+// DownloadDataExportHandler serves a finished export archive to the user it belongs to
+func DownloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "download your data export") {
+		return
+	}
+
+	export, err := PbClient.FindRecordById("data_exports", mux.Vars(r)["id"])
+	if err != nil || export.GetString("user") != user.Id || export.GetString("status") != exportReady ||
+		export.GetDateTime("expires").Time().Before(time.Now()) {
+		http.NotFound(w, r)
+		return
+	}
+
+	fsys, err := PbClient.NewFilesystem()
+	if err != nil {
+		http.Error(w, "Failed to open filesystem", http.StatusInternalServerError)
+		return
+	}
+	defer fsys.Close()
+
+	name := fmt.Sprintf("data-export-%s.zip", export.GetDateTime("created").Time().Format("2006-01-02"))
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := fsys.Serve(w, r, export.BaseFilesPath()+"/"+export.GetString("archive"), name); err != nil {
+		http.NotFound(w, r)
+	}
+}
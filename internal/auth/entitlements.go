@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Usage limits a plan declares, with -1 meaning unlimited
+const (
+	LimitSeats        = "seats"
+	LimitProjects     = "projects"
+	LimitAPICalls     = "apiCalls"
+	LimitStorageBytes = "storageBytes"
+)
+
+// Feature flags a plan can include
+const (
+	FeatureWebhooks        = "webhooks"
+	FeatureAuditLog        = "audit_log"
+	FeaturePrioritySupport = "priority_support"
+)
+
+// Limit value granting unlimited usage
+const Unlimited = -1
+
+// Entitlements context key
+const entitlementsContextKey contextKey = "entitlements"
+
+// PlanEntitlements are the limits and features an organization's plan grants
+type PlanEntitlements struct {
+	Plan     string          `json:"plan"`
+	PlanName string          `json:"planName"`
+	Limits   map[string]int  `json:"limits"`
+	Features map[string]bool `json:"features"`
+}
+
+// UpgradeData represents the data for the 402 upgrade page
+type UpgradeData struct {
+	Message string
+	Feature string
+	Plans   []string
+}
+
+// Has reports whether the plan includes a feature
+func (e PlanEntitlements) Has(feature string) bool {
+	return e.Features[feature]
+}
+
+// Limit returns the plan's limit for a resource. Limits the plan doesn't declare are 0.
+func (e PlanEntitlements) Limit(name string) int {
+	return e.Limits[name]
+}
+
+// Allows reports whether one more unit of a resource fits in the limit given the current usage
+func (e PlanEntitlements) Allows(name string, used int) bool {
+	limit := e.Limit(name)
+	return limit == Unlimited || used < limit
+}
+
+// planEntitlements reads the limits and feature flags of a plan record
+func planEntitlements(plan *core.Record) PlanEntitlements {
+	entitlements := PlanEntitlements{
+		Plan:     plan.GetString("code"),
+		PlanName: plan.GetString("name"),
+		Limits:   map[string]int{},
+		Features: map[string]bool{},
+	}
+
+	plan.UnmarshalJSONField("limits", &entitlements.Limits)
+
+	var flags []string
+	plan.UnmarshalJSONField("flags", &flags)
+	for _, flag := range flags {
+		entitlements.Features[flag] = true
+	}
+
+	return entitlements
+}
+
+// organizationEntitlements returns the entitlements of an organization's active plan,
+// falling back to the free plan when it has no active subscription
+func organizationEntitlements(organizationId string) PlanEntitlements {
+	if subscription, err := findOrganizationSubscription(organizationId); err == nil && isActiveSubscription(subscription) {
+		if plan, err := PbClient.FindRecordById("plans", subscription.GetString("plan")); err == nil {
+			return planEntitlements(plan)
+		}
+	}
+
+	if plan, err := PbClient.FindFirstRecordByData("plans", "code", "free"); err == nil {
+		return planEntitlements(plan)
+	}
+
+	return PlanEntitlements{Limits: map[string]int{}, Features: map[string]bool{}}
+}
+
+// Entitlements returns what the active organization's plan grants. The context must
+// come from a request that went through OrganizationMiddleware.
+func Entitlements(ctx context.Context) PlanEntitlements {
+	if entitlements, ok := ctx.Value(entitlementsContextKey).(PlanEntitlements); ok {
+		return entitlements
+	}
+
+	organization, _ := ctx.Value(organizationContextKey).(*core.Record)
+	if organization == nil {
+		return PlanEntitlements{Limits: map[string]int{}, Features: map[string]bool{}}
+	}
+
+	return organizationEntitlements(organization.Id)
+}
+
+// plansWithFeature returns the names of the active plans that include a feature
+func plansWithFeature(feature string) []string {
+	plans, err := PbClient.FindRecordsByFilter("plans", "active = true", "price", 0, 0)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, plan := range plans {
+		if planEntitlements(plan).Has(feature) {
+			names = append(names, plan.GetString("name"))
+		}
+	}
+
+	return names
+}
+
+// paymentRequired rejects the request with a JSON 402 for API clients or the upgrade page for browsers
+func paymentRequired(w http.ResponseWriter, r *http.Request, feature string, message string) {
+	plans := plansWithFeature(feature)
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPaymentRequired)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":      message,
+			"feature":    feature,
+			"plans":      plans,
+			"upgradeUrl": AppURL + "/billing",
+		})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusPaymentRequired)
+	if err := renderTemplate(w, r, "upgrade.html", UpgradeData{Message: message, Feature: feature, Plans: plans}); err != nil {
+		http.Error(w, message, http.StatusPaymentRequired)
+	}
+}
+
+// RequireFeature returns middleware that only lets requests through when the active
+// organization's plan includes the feature, e.g.
+//
+//	webhooksRouter.Use(auth.RequireFeature(auth.FeatureWebhooks))
+//
+// It must run after AuthMiddleware and OrganizationMiddleware.
+func RequireFeature(feature string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetCurrentOrganization(r) == nil {
+				unauthorized(w, r, false)
+				return
+			}
+
+			entitlements := Entitlements(r.Context())
+			if !entitlements.Has(feature) {
+				name := strings.ReplaceAll(feature, "_", " ")
+				paymentRequired(w, r, feature, "The "+entitlements.PlanName+" plan doesn't include "+name+". Upgrade your plan to use it.")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), entitlementsContextKey, entitlements)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// countSeats returns the members of an organization plus its pending invitations
+func countSeats(organizationId string) (int, error) {
+	members, err := PbClient.CountRecords("memberships", dbx.HashExp{"organization": organizationId})
+	if err != nil {
+		return 0, err
+	}
+
+	invitations, err := PbClient.CountRecords("invitations", dbx.HashExp{"organization": organizationId})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(members + invitations), nil
+}
+
+// EntitlementsHandler returns the limits and features of the active organization's plan
+func EntitlementsHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	entitlements := Entitlements(r.Context())
+
+	used := map[string]int64{}
+	for _, metric := range usageMetrics {
+		value, err := CurrentUsage(organization.Id, metric.metric)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load usage")
+			return
+		}
+		used[metric.metric] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"plan":     entitlements.Plan,
+		"planName": entitlements.PlanName,
+		"limits":   entitlements.Limits,
+		"features": entitlements.Features,
+		"usage":    used,
+	})
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireFeature(t *testing.T) {
+	app := newTestApp(t)
+	replay, organization := newFixtureReplay(t)
+	owner, err := app.FindAuthRecordByEmail("users", "billing@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := signIn(t, owner)
+
+	call := func(feature string, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		handler := protected(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, RequireFeature(feature))
+
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.AddCookie(&http.Cookie{Name: "pb_auth", Value: session})
+		r.AddCookie(&http.Cookie{Name: "pb_org", Value: organization.Id})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// The free plan has no webhooks: API clients learn which plans do
+	w := call(FeatureWebhooks, "/api/webhooks")
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("webhooks on the free plan: got %d, want 402", w.Code)
+	}
+	var body struct {
+		Feature    string `json:"feature"`
+		Plans      []any  `json:"plans"`
+		UpgradeURL string `json:"upgradeUrl"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Feature != FeatureWebhooks || len(body.Plans) == 0 || body.UpgradeURL == "" {
+		t.Fatalf("unexpected 402 body %+v: %v", body, err)
+	}
+
+	// Browsers get the upgrade page
+	w = call(FeatureWebhooks, "/webhooks")
+	if w.Code != http.StatusPaymentRequired || !strings.Contains(w.Body.String(), "Upgrade") {
+		t.Fatalf("webhooks page on the free plan: got %d", w.Code)
+	}
+
+	// Subscribing to pro unlocks webhooks but not the audit log
+	replay.replay(t, "customer_subscription_created")
+	if w := call(FeatureWebhooks, "/api/webhooks"); w.Code != http.StatusOK {
+		t.Fatalf("webhooks on the pro plan: got %d", w.Code)
+	}
+	if w := call(FeatureAuditLog, "/api/audit"); w.Code != http.StatusPaymentRequired {
+		t.Fatalf("audit log on the pro plan: got %d, want 402", w.Code)
+	}
+
+	// Canceling goes back to the free plan
+	replay.replay(t, "customer_subscription_deleted")
+	if w := call(FeatureWebhooks, "/api/webhooks"); w.Code != http.StatusPaymentRequired {
+		t.Fatalf("webhooks after canceling: got %d, want 402", w.Code)
+	}
+}
@@ -2,26 +2,86 @@ package auth
 
 import (
 	"encoding/json"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
-	"path/filepath"
-	"time"
-
-	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/pocketbase/pocketbase/core"
+	templatefs "github.com/yourusername/go-saas-template/internal/templates"
 )
 
+// Functions available to all page templates. The request-specific ones are
+// placeholders that renderTemplate replaces on every render.
+var templateFuncs = template.FuncMap{
+	"oauth2Providers": oauth2Providers,
+	"passwordRules":   func() PasswordPolicy { return PasswordRules },
+	"csrfToken":       func() string { return "" },
+	"csrfField":       func() template.HTML { return "" },
+	"can":             func(string) bool { return false },
+	"hasFeature":      func(string) bool { return false },
+	"entitlements":    func() PlanEntitlements { return PlanEntitlements{} },
+}
+
 // Templates for auth pages
-var templates = template.Must(template.ParseFiles(
-	filepath.Join("internal", "templates", "login.html"),
-	filepath.Join("internal", "templates", "register.html"),
-	filepath.Join("internal", "templates", "forgot_password.html"),
-	filepath.Join("internal", "templates", "reset_password.html"),
-	filepath.Join("internal", "templates", "home.html"),
+var templates = template.Must(template.New("").Funcs(templateFuncs).ParseFS(templatefs.FS,
+	"login.html",
+	"register.html",
+	"forgot_password.html",
+	"reset_password.html",
+	"verify_email.html",
+	"sessions.html",
+	"login_2fa.html",
+	"two_factor.html",
+	"magic_link.html",
+	"organizations.html",
+	"invitation.html",
+	"forbidden.html",
+	"billing.html",
+	"upgrade.html",
+	"audit.html",
+	"webhooks.html",
+	"api_tokens.html",
+	"profile.html",
+	"settings.html",
+	"home.html",
+	"password_requirements.html",
 ))
 
+// renderTemplate renders a page template with the request's CSRF token available
+// through {{csrfToken}} and {{csrfField}}, permission checks through {{can "members:manage"}}
+// and the active plan through {{hasFeature "webhooks"}} and {{entitlements}}
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data any) error {
+	page, err := templates.Clone()
+	if err != nil {
+		return err
+	}
+
+	// Entitlements are only looked up when the page asks for them
+	var entitlements *PlanEntitlements
+	loadEntitlements := func() PlanEntitlements {
+		if entitlements == nil {
+			loaded := Entitlements(r.Context())
+			entitlements = &loaded
+		}
+		return *entitlements
+	}
+
+	token := csrfTokenFromRequest(r)
+	page.Funcs(template.FuncMap{
+		"csrfToken":    func() string { return token },
+		"csrfField":    func() template.HTML { return csrfField(token) },
+		"can":          func(permission string) bool { return HasPermission(r, permission) },
+		"hasFeature":   func(feature string) bool { return loadEntitlements().Has(feature) },
+		"entitlements": loadEntitlements,
+	})
+
+	return page.ExecuteTemplate(w, name, data)
+}
+
 // LoginForm represents the login form data
 type LoginForm struct {
 	Email    string `json:"email"`
@@ -32,11 +92,13 @@ type LoginForm struct {
 
 // RegisterForm represents the registration form data
 type RegisterForm struct {
-	Email           string `json:"email"`
-	Password        string `json:"password"`
-	ConfirmPassword string `json:"confirmPassword"`
-	Error           string `json:"error,omitempty"`
-	Success         string `json:"success,omitempty"`
+	Email           string              `json:"email"`
+	Password        string              `json:"password"`
+	ConfirmPassword string              `json:"confirmPassword"`
+	Invite          string              `json:"invite,omitempty"`
+	Error           string              `json:"error,omitempty"`
+	PasswordErrors  []PasswordViolation `json:"passwordErrors,omitempty"`
+	Success         string              `json:"success,omitempty"`
 }
 
 // ForgotPasswordForm represents the forgot password form data
@@ -48,17 +110,22 @@ type ForgotPasswordForm struct {
 
 // ResetPasswordForm represents the reset password form data
 type ResetPasswordForm struct {
-	Token           string `json:"token"`
-	Password        string `json:"password"`
-	ConfirmPassword string `json:"confirmPassword"`
-	Error           string `json:"error,omitempty"`
-	Success         string `json:"success,omitempty"`
+	Token           string              `json:"token"`
+	Password        string              `json:"password"`
+	ConfirmPassword string              `json:"confirmPassword"`
+	Error           string              `json:"error,omitempty"`
+	PasswordErrors  []PasswordViolation `json:"passwordErrors,omitempty"`
+	Success         string              `json:"success,omitempty"`
 }
 
 // HomeData represents the data for the home page
 type HomeData struct {
-	Email string `json:"email"`
-	Name  string `json:"name,omitempty"`
+	Email         string             `json:"email"`
+	Name          string             `json:"name,omitempty"`
+	Avatar        string             `json:"avatar,omitempty"`
+	Organization  OrganizationInfo   `json:"organization"`
+	Organizations []OrganizationInfo `json:"organizations"`
+	Usage         []UsageInfo        `json:"usage"`
 }
 
 // LoginHandler shows the login form
@@ -67,12 +134,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		// Check for password reset success message
 		resetSuccess := r.URL.Query().Get("reset_success")
 		if resetSuccess == "true" {
-			templates.ExecuteTemplate(w, "login.html", LoginForm{
+			renderTemplate(w, r, "login.html", LoginForm{
 				Success: "Your password has been reset successfully. You can now log in with your new password.",
 			})
 			return
 		}
-		templates.ExecuteTemplate(w, "login.html", nil)
+
+		// Check for email verification success message
+		if r.URL.Query().Get("verified") == "true" {
+			renderTemplate(w, r, "login.html", LoginForm{
+				Success: "Your email has been verified. You can now log in.",
+			})
+			return
+		}
+		// Check for email change success message
+		if r.URL.Query().Get("email_changed") == "true" {
+			renderTemplate(w, r, "login.html", LoginForm{
+				Success: "Your email address has been changed. Please log in with your new email.",
+			})
+			return
+		}
+		renderTemplate(w, r, "login.html", nil)
 		return
 	}
 
@@ -88,7 +170,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate input
 	if email == "" || password == "" {
-		templates.ExecuteTemplate(w, "login.html", LoginForm{
+		renderTemplate(w, r, "login.html", LoginForm{
 			Error: "Email and password are required",
 		})
 		return
@@ -96,17 +178,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure PocketBase client is initialized
 	if PbClient == nil {
-		templates.ExecuteTemplate(w, "login.html", LoginForm{
+		renderTemplate(w, r, "login.html", LoginForm{
 			Email: email,
 			Error: "Authentication system not available",
 		})
 		return
 	}
 
+	// Refuse attempts while the client or account is throttled
+	if wait := loginBlockedFor(r, email); wait > 0 {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Email: email,
+			Error: lockoutMessage(wait),
+		})
+		return
+	}
+
 	// Find user by email
 	authRecord, err := PbClient.FindAuthRecordByEmail("users", email)
 	if err != nil {
-		templates.ExecuteTemplate(w, "login.html", LoginForm{
+		recordLoginFailure(r, email, "password")
+		renderTemplate(w, r, "login.html", LoginForm{
 			Email: email,
 			Error: "Invalid email or password. If you forgot your password, use the 'Forgot Password' link below.",
 		})
@@ -115,40 +207,62 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate password
 	if !authRecord.ValidatePassword(password) {
-		templates.ExecuteTemplate(w, "login.html", LoginForm{
+		recordLoginFailure(r, email, "password")
+		renderTemplate(w, r, "login.html", LoginForm{
 			Email: email,
 			Error: "Invalid email or password. If you forgot your password, use the 'Forgot Password' link below.",
 		})
 		return
 	}
 
-	// Generate auth token
-	token, err := authRecord.NewAuthToken()
-	if err != nil {
-		templates.ExecuteTemplate(w, "login.html", LoginForm{
+	// Ask for the second factor when two-factor authentication is enabled. Failures stay
+	// counted until it's passed, so the password can't be used to reset the lockout.
+	if hasTwoFactorEnabled(authRecord) {
+		challenge, err := newMFAChallenge(authRecord)
+		if err != nil {
+			renderTemplate(w, r, "login.html", LoginForm{
+				Email: email,
+				Error: "Failed to start two-factor authentication",
+			})
+			return
+		}
+
+		setMFACookie(w, challenge)
+		http.Redirect(w, r, "/auth/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Start a new session and set the auth cookie
+	if _, err := startSession(w, r, authRecord); err != nil {
+		renderTemplate(w, r, "login.html", LoginForm{
 			Email: email,
 			Error: "Failed to create authentication token",
 		})
 		return
 	}
+	clearLoginFailures(email)
 
-	// Set cookie with the auth token
-	http.SetCookie(w, &http.Cookie{
-		Name:     "pb_auth",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
-	})
+	auditLogin(r, authRecord, email, "password", AuditSuccess)
 
 	// Redirect to home page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, loginRedirectURL(r), http.StatusSeeOther)
 }
 
 // RegisterHandler shows the registration form
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		templates.ExecuteTemplate(w, "register.html", nil)
+		// Invited users register with the email the invitation was sent to
+		if invite := r.URL.Query().Get("invite"); invite != "" && PbClient != nil {
+			if invitation, err := findInvitationByToken(invite); err == nil {
+				renderTemplate(w, r, "register.html", RegisterForm{
+					Email:  invitation.GetString("email"),
+					Invite: invite,
+				})
+				return
+			}
+		}
+
+		renderTemplate(w, r, "register.html", nil)
 		return
 	}
 
@@ -162,29 +276,45 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	email := r.FormValue("email")
 	password := r.FormValue("password")
 	confirmPassword := r.FormValue("confirmPassword")
+	invite := r.FormValue("invite")
 
 	// Validate inputs
 	if email == "" || password == "" {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Error: "Email and password are required",
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "Email and password are required",
 		})
 		return
 	}
 
 	// Validate passwords match
 	if password != confirmPassword {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Email: email,
-			Error: "Passwords do not match",
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "Passwords do not match",
+		})
+		return
+	}
+
+	// Enforce the password policy
+	if violations := PasswordRules.Validate(password, email); len(violations) > 0 {
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:          email,
+			Invite:         invite,
+			Error:          "Please choose a stronger password",
+			PasswordErrors: violations,
 		})
 		return
 	}
 
 	// Make sure PocketBase client is initialized
 	if PbClient == nil {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Email: email,
-			Error: "Registration system not available",
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "Registration system not available",
 		})
 		return
 	}
@@ -192,19 +322,41 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if email already exists
 	existingRecord, _ := PbClient.FindAuthRecordByEmail("users", email)
 	if existingRecord != nil {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Email: email,
-			Error: "An account with this email already exists. Please use the login page or reset your password.",
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "An account with this email already exists. Please use the login page or reset your password.",
 		})
 		return
 	}
 
+	// Check the invitation before creating the account so the user can fix the problem
+	var invitation *core.Record
+	if invite != "" {
+		invitation, err = findInvitationByToken(invite)
+		inviteError := ""
+		if err != nil {
+			inviteError = "This invitation is invalid or has expired. Ask the organization to send you a new one."
+		} else if !strings.EqualFold(invitation.GetString("email"), email) {
+			inviteError = "This invitation was sent to " + invitation.GetString("email") + ". Please register with that email address."
+		}
+		if inviteError != "" {
+			renderTemplate(w, r, "register.html", RegisterForm{
+				Email:  email,
+				Invite: invite,
+				Error:  inviteError,
+			})
+			return
+		}
+	}
+
 	// Find the users collection
 	collection, err := PbClient.FindCollectionByNameOrId("users")
 	if err != nil {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Email: email,
-			Error: "User system not configured correctly",
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "User system not configured correctly",
 		})
 		return
 	}
@@ -214,46 +366,66 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	record.SetEmail(email)
 	record.SetPassword(password)
 
-	// Save the record
-	if err := PbClient.Save(record); err != nil {
-		templates.ExecuteTemplate(w, "register.html", RegisterForm{
-			Email: email,
-			Error: "Registration failed: " + err.Error(),
+	// Save the record along with the user's personal workspace
+	if err := saveNewUser(record); err != nil {
+		renderTemplate(w, r, "register.html", RegisterForm{
+			Email:  email,
+			Invite: invite,
+			Error:  "Registration failed: " + err.Error(),
 		})
 		return
 	}
 
-	// Generate auth token for the new user
-	token, err := record.NewAuthToken()
-	if err != nil {
-		// Registration succeeded but token generation failed - redirect to login
+	// Join the organization the user was invited to
+	if invitation != nil {
+		if err := acceptInvitation(invitation, record); err != nil {
+			log.Printf("⚠️ Failed to accept invitation: %v", err)
+		} else {
+			auditInvitationAccept(r, invitation, record)
+			setOrganizationCookie(w, invitation.GetString("organization"))
+			clearInviteCookie(w)
+		}
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditRegister, Actor: record, Target: "user:" + record.Id})
+
+	// Send the verification email unless the invitation already verified it
+	if !record.Verified() {
+		if err := sendVerificationEmail(record); err != nil {
+			log.Printf("⚠️ Failed to send verification email: %v", err)
+		}
+	}
+
+	// Start a session for the new user
+	if _, err := startSession(w, r, record); err != nil {
+		// Registration succeeded but session creation failed - redirect to login
 		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
 		return
 	}
 
-	// Set cookie with the auth token
-	http.SetCookie(w, &http.Cookie{
-		Name:     "pb_auth",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
-	})
+	if record.Verified() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	// Redirect to home page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// Ask the user to verify their email
+	http.Redirect(w, r, "/auth/verify", http.StatusSeeOther)
 }
 
 // LogoutHandler logs the user out
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Revoke the session so the token can't be reused
+	if token := tokenFromRequest(r); token != "" && PbClient != nil {
+		if user, session, err := authenticateToken(token); err == nil {
+			RecordAuditEvent(r, AuditEvent{Action: AuditLogout, Actor: user, Target: "session:" + session.Id})
+		}
+		if err := revokeToken(token); err != nil {
+			log.Printf("⚠️ Failed to revoke session: %v", err)
+		}
+	}
+
 	// Clear the auth cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "pb_auth",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	clearAuthCookie(w)
 
 	// Redirect to login page
 	http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
@@ -293,34 +465,158 @@ func PocketBaseAuthHandler(w http.ResponseWriter, r *http.Request) {
 		email, _ := formData["email"].(string)
 		password, _ := formData["password"].(string)
 
+		// Refuse attempts while the client or account is throttled
+		if wait := loginBlockedFor(r, email); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+
 		// Find the user by email
 		record, err := PbClient.FindAuthRecordByEmail("users", email)
 		if err != nil {
+			recordLoginFailure(r, email, "password")
 			http.Error(w, "Invalid email or password", http.StatusBadRequest)
 			return
 		}
 
 		// Validate password
 		if !record.ValidatePassword(password) {
+			recordLoginFailure(r, email, "password")
 			http.Error(w, "Invalid email or password", http.StatusBadRequest)
 			return
 		}
 
-		// Generate auth token
-		token, err := record.NewAuthToken()
+		// Return a challenge instead of a token when two-factor authentication is enabled.
+		// Failures stay counted until the second factor is passed.
+		if hasTwoFactorEnabled(record) {
+			challenge, err := newMFAChallenge(record)
+			if err != nil {
+				http.Error(w, "Failed to start two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+
+			result = map[string]any{
+				"mfaRequired": true,
+				"mfaToken":    challenge,
+			}
+			break
+		}
+
+		// Start a new session and set the auth cookie
+		token, err := startSession(w, r, record)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
+		clearLoginFailures(email)
+		auditLogin(r, record, email, "password", AuditSuccess)
 
-		// Set cookie with auth token
-		http.SetCookie(w, &http.Cookie{
-			Name:     "pb_auth",
-			Value:    token,
-			Path:     "/",
-			HttpOnly: true,
-			Expires:  time.Now().Add(24 * time.Hour),
-		})
+		result = map[string]any{
+			"token": token,
+			"user":  record.PublicExport(),
+		}
+
+	case "login-2fa":
+		mfaToken, _ := formData["mfaToken"].(string)
+		code, _ := formData["code"].(string)
+
+		// Resolve the user from the challenge issued by the login action
+		record, err := verifyMFAChallenge(mfaToken)
+		if err != nil {
+			http.Error(w, "Invalid or expired MFA token", http.StatusBadRequest)
+			return
+		}
+
+		// Refuse attempts while the client or account is throttled
+		if wait := loginBlockedFor(r, record.Email()); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+
+		// Validate the second factor
+		factor, err := findTOTPFactor(record.Id)
+		if err != nil || !factor.GetBool("enabled") || !verifySecondFactor(factor, code) {
+			recordLoginFailure(r, record.Email(), "2fa")
+			http.Error(w, "Invalid authentication code", http.StatusBadRequest)
+			return
+		}
+
+		clearLoginFailures(record.Email())
+
+		// Start a new session and set the auth cookie
+		token, err := startSession(w, r, record)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		auditLogin(r, record, record.Email(), "2fa", AuditSuccess)
+
+		result = map[string]any{
+			"token": token,
+			"user":  record.PublicExport(),
+		}
+
+	case "request-otp":
+		email, _ := formData["email"].(string)
+		if email == "" {
+			http.Error(w, "Email is required", http.StatusBadRequest)
+			return
+		}
+
+		// Email a one-time login code
+		otpId, err := requestLoginOTP(r, email)
+		if err != nil {
+			http.Error(w, "Failed to create login code", http.StatusInternalServerError)
+			return
+		}
+
+		result = map[string]any{
+			"otpId": otpId,
+		}
+
+	case "login-otp":
+		otpId, _ := formData["otpId"].(string)
+		code, _ := formData["code"].(string)
+
+		// Refuse attempts while the client or the account the code was sent to is throttled
+		email := loginOTPEmail(otpId)
+		if wait := loginBlockedFor(r, email); wait > 0 {
+			writeTooManyAttempts(w, wait)
+			return
+		}
+
+		// Validate the one-time login code. Failures stay counted against the account
+		// until the whole login succeeds.
+		record, err := completeLoginOTP(otpId, code)
+		if err != nil {
+			recordLoginFailure(r, email, "otp")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Return a challenge instead of a token when two-factor authentication is enabled
+		if hasTwoFactorEnabled(record) {
+			challenge, err := newMFAChallenge(record)
+			if err != nil {
+				http.Error(w, "Failed to start two-factor authentication", http.StatusInternalServerError)
+				return
+			}
+
+			result = map[string]any{
+				"mfaRequired": true,
+				"mfaToken":    challenge,
+			}
+			break
+		}
+
+		// Start a new session and set the auth cookie
+		token, err := startSession(w, r, record)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		clearLoginFailures(record.Email())
+		auditLogin(r, record, record.Email(), "otp", AuditSuccess)
 
 		result = map[string]any{
 			"token": token,
@@ -341,31 +637,35 @@ func PocketBaseAuthHandler(w http.ResponseWriter, r *http.Request) {
 		email, _ := formData["email"].(string)
 		password, _ := formData["password"].(string)
 
+		// Enforce the password policy
+		if violations := PasswordRules.Validate(password, email); len(violations) > 0 {
+			writePasswordViolations(w, violations)
+			return
+		}
+
 		record.SetEmail(email)
 		record.SetPassword(password)
 
-		// Save the record
-		if err := PbClient.Save(record); err != nil {
+		// Save the record along with the user's personal workspace
+		if err := saveNewUser(record); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Generate auth token
-		token, err := record.NewAuthToken()
+		RecordAuditEvent(r, AuditEvent{Action: AuditRegister, Actor: record, Target: "user:" + record.Id})
+
+		// Send the verification email
+		if err := sendVerificationEmail(record); err != nil {
+			log.Printf("⚠️ Failed to send verification email: %v", err)
+		}
+
+		// Start a new session and set the auth cookie
+		token, err := startSession(w, r, record)
 		if err != nil {
 			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 			return
 		}
 
-		// Set cookie with auth token
-		http.SetCookie(w, &http.Cookie{
-			Name:     "pb_auth",
-			Value:    token,
-			Path:     "/",
-			HttpOnly: true,
-			Expires:  time.Now().Add(24 * time.Hour),
-		})
-
 		result = map[string]any{
 			"token": token,
 			"user":  record.PublicExport(),
@@ -375,37 +675,29 @@ func PocketBaseAuthHandler(w http.ResponseWriter, r *http.Request) {
 		// Get token from request
 		token, ok := formData["token"].(string)
 		if !ok {
-			// Try to get from auth cookie
-			cookie, err := r.Cookie("pb_auth")
-			if err != nil || cookie.Value == "" {
+			// Try to get from the Authorization header or auth cookie
+			token = tokenFromRequest(r)
+			if token == "" {
 				http.Error(w, "Missing token", http.StatusBadRequest)
 				return
 			}
-			token = cookie.Value
 		}
 
-		// Find the auth record by token
-		record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
+		// Find the auth record and session by token
+		record, session, err := authenticateToken(token)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Generate new token
-		newToken, err := record.NewAuthToken()
+		// Rotate the session to a new token and set the cookie
+		newToken, err := rotateSession(w, session, record)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// Set the new token cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "pb_auth",
-			Value:    newToken,
-			Path:     "/",
-			HttpOnly: true,
-			Expires:  time.Now().Add(24 * time.Hour),
-		})
+		RecordAuditEvent(r, AuditEvent{Action: AuditTokenRefresh, Actor: record, Target: "session:" + session.Id})
 
 		result = map[string]any{
 			"token": newToken,
@@ -424,9 +716,9 @@ func PocketBaseAuthHandler(w http.ResponseWriter, r *http.Request) {
 
 // AuthRefresh refreshes an authentication token
 func AuthRefresh(w http.ResponseWriter, r *http.Request) {
-	// Get auth token from cookie
-	cookie, err := r.Cookie("pb_auth")
-	if err != nil {
+	// Get auth token from the Authorization header or cookie
+	token := tokenFromRequest(r)
+	if token == "" {
 		http.Error(w, "No authentication token", http.StatusBadRequest)
 		return
 	}
@@ -437,36 +729,23 @@ func AuthRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find the auth record by token
-	record, err := PbClient.FindAuthRecordByToken(cookie.Value, core.TokenTypeAuth)
+	// Find the auth record and session by token
+	record, session, err := authenticateToken(token)
 	if err != nil {
 		// If token is invalid, clear the cookie and redirect to login
-		http.SetCookie(w, &http.Cookie{
-			Name:     "pb_auth",
-			Value:    "",
-			Path:     "/",
-			HttpOnly: true,
-			MaxAge:   -1,
-		})
+		clearAuthCookie(w)
 		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
 		return
 	}
 
-	// Generate a new token
-	newToken, err := record.NewAuthToken()
+	// Rotate the session to a new token and set the cookie
+	newToken, err := rotateSession(w, session, record)
 	if err != nil {
 		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
 		return
 	}
 
-	// Set cookie with the new token
-	http.SetCookie(w, &http.Cookie{
-		Name:     "pb_auth",
-		Value:    newToken,
-		Path:     "/",
-		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
-	})
+	RecordAuditEvent(r, AuditEvent{Action: AuditTokenRefresh, Actor: record, Target: "session:" + session.Id})
 
 	// Respond with success
 	w.Header().Set("Content-Type", "application/json")
@@ -481,7 +760,7 @@ func AuthRefresh(w http.ResponseWriter, r *http.Request) {
 // ForgotPasswordHandler handles password reset requests
 func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		templates.ExecuteTemplate(w, "forgot_password.html", nil)
+		renderTemplate(w, r, "forgot_password.html", nil)
 		return
 	}
 
@@ -496,7 +775,7 @@ func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate input
 	if email == "" {
-		templates.ExecuteTemplate(w, "forgot_password.html", ForgotPasswordForm{
+		renderTemplate(w, r, "forgot_password.html", ForgotPasswordForm{
 			Error: "Email is required",
 		})
 		return
@@ -504,7 +783,7 @@ func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure PocketBase client is initialized
 	if PbClient == nil {
-		templates.ExecuteTemplate(w, "forgot_password.html", ForgotPasswordForm{
+		renderTemplate(w, r, "forgot_password.html", ForgotPasswordForm{
 			Email: email,
 			Error: "Password reset system not available",
 		})
@@ -514,44 +793,39 @@ func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	// Find user by email
 	authRecord, err := PbClient.FindAuthRecordByEmail("users", email)
 	if err != nil {
+		RecordAuditEvent(r, AuditEvent{Action: AuditPasswordResetSend, Outcome: AuditFailure, ActorEmail: email})
+
 		// Don't reveal whether the email exists or not for security reasons
-		templates.ExecuteTemplate(w, "forgot_password.html", ForgotPasswordForm{
+		renderTemplate(w, r, "forgot_password.html", ForgotPasswordForm{
 			Success: "If an account with this email exists, password reset instructions have been sent.",
 		})
 		return
 	}
 
-	// Get user ID to include in the token for additional security
-	userID := authRecord.Id
-
-	// Generate a simple token (in production, use a proper token generation method)
-	token := fmt.Sprintf("%s_%d", userID, time.Now().UnixNano())
+	// Generate a signed, single-use password reset token
+	token, err := authRecord.NewPasswordResetToken()
+	if err != nil {
+		log.Printf("⚠️ Failed to create password reset token: %v", err)
 
-	// Store the token in a cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   3600, // 1 hour expiry
-	})
+		// Answer as for any other address so the failure doesn't reveal the account
+		renderTemplate(w, r, "forgot_password.html", ForgotPasswordForm{
+			Success: "If an account with this email exists, password reset instructions have been sent.",
+		})
+		return
+	}
 
-	// Store the email in a cookie for the reset process
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset_email",
-		Value:    email,
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   3600, // 1 hour expiry
-	})
+	// Email the reset link to the account owner
+	resetLink := fmt.Sprintf("%s/auth/reset-password?token=%s", AppURL, url.QueryEscape(token))
+	if err := sendEmail(authRecord.Email(), "Reset your password", "password_reset.html", EmailData{
+		Link: resetLink,
+	}); err != nil {
+		log.Printf("⚠️ Failed to send password reset email: %v", err)
+	}
 
-	// Create a reset link
-	resetLink := fmt.Sprintf("/auth/reset-password?token=%s", token)
+	RecordAuditEvent(r, AuditEvent{Action: AuditPasswordResetSend, Actor: authRecord, Target: "user:" + authRecord.Id})
 
-	// Show success message with the reset link
-	templates.ExecuteTemplate(w, "forgot_password.html", ForgotPasswordForm{
-		Success: "Password reset instructions have been sent. For this demo, you can reset your password here: ",
-		Email:   resetLink,
+	renderTemplate(w, r, "forgot_password.html", ForgotPasswordForm{
+		Success: "If an account with this email exists, password reset instructions have been sent.",
 	})
 }
 
@@ -564,16 +838,23 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Verify the token matches the cookie
-		resetCookie, err := r.Cookie("reset_token")
-		if err != nil || resetCookie.Value != token {
-			templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		// Make sure PocketBase client is initialized
+		if PbClient == nil {
+			renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
+				Error: "Password reset system not available",
+			})
+			return
+		}
+
+		// Verify the token before showing the form
+		if _, err := PbClient.FindAuthRecordByToken(token, core.TokenTypePasswordReset); err != nil {
+			renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 				Error: "Invalid or expired reset token. Please request a new password reset.",
 			})
 			return
 		}
 
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 			Token: token,
 		})
 		return
@@ -592,7 +873,7 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate inputs
 	if token == "" || password == "" {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 			Token: token,
 			Error: "Token and password are required",
 		})
@@ -601,7 +882,7 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Validate passwords match
 	if password != confirmPassword {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 			Token: token,
 			Error: "Passwords do not match",
 		})
@@ -610,40 +891,29 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Make sure PocketBase client is initialized
 	if PbClient == nil {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 			Token: token,
 			Error: "Password reset system not available",
 		})
 		return
 	}
 
-	// Verify the token from cookie
-	resetCookie, err := r.Cookie("reset_token")
-	if err != nil || resetCookie.Value != token {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
-			Token: token,
-			Error: "Invalid or expired reset token. Please request a new password reset.",
-		})
-		return
-	}
-
-	// Get the email from cookie
-	emailCookie, err := r.Cookie("reset_email")
+	// Resolve the user from the signed reset token
+	record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypePasswordReset)
 	if err != nil {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
-			Token: token,
-			Error: "Reset session expired. Please request a new password reset.",
+		RecordAuditEvent(r, AuditEvent{Action: AuditPasswordReset, Outcome: AuditFailure})
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
+			Error: "Invalid or expired reset token. Please request a new password reset.",
 		})
 		return
 	}
 
-	// Find the user by email
-	email := emailCookie.Value
-	record, err := PbClient.FindAuthRecordByEmail("users", email)
-	if err != nil {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
-			Token: token,
-			Error: "User not found. Please request a new password reset.",
+	// Enforce the password policy
+	if violations := PasswordRules.Validate(password, record.Email()); len(violations) > 0 {
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
+			Token:          token,
+			Error:          "Please choose a stronger password",
+			PasswordErrors: violations,
 		})
 		return
 	}
@@ -653,29 +923,19 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Save the record
 	if err := PbClient.Save(record); err != nil {
-		templates.ExecuteTemplate(w, "reset_password.html", ResetPasswordForm{
+		renderTemplate(w, r, "reset_password.html", ResetPasswordForm{
 			Token: token,
 			Error: "Failed to update password: " + err.Error(),
 		})
 		return
 	}
 
-	// Clear the reset cookies
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset_token",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	// The password change invalidates all existing tokens, so drop their sessions too
+	if _, err := revokeUserSessions(record.Id, ""); err != nil {
+		log.Printf("⚠️ Failed to revoke sessions after password reset: %v", err)
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "reset_email",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		MaxAge:   -1,
-	})
+	RecordAuditEvent(r, AuditEvent{Action: AuditPasswordReset, Actor: record, Target: "user:" + record.Id})
 
 	// Redirect to login page with success message
 	http.Redirect(w, r, "/auth/login?reset_success=true", http.StatusSeeOther)
@@ -699,9 +959,27 @@ func HomeRenderer(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Expires", "0")
 
+	// Active organization and the others to switch to
+	organization := currentOrganizationInfo(r)
+	organizations, err := listOrganizations(user, organization.Id)
+	if err != nil {
+		log.Printf("⚠️ Failed to load organizations: %v", err)
+	}
+
+	// Current period usage for the stats cards
+	var usage []UsageInfo
+	if organization.Id != "" {
+		usage = organizationUsage(organization.Id, Entitlements(r.Context()))
+	}
+
 	// Render the home template with user data
-	if err := templates.ExecuteTemplate(w, "home.html", HomeData{
-		Email: email,
+	if err := renderTemplate(w, r, "home.html", HomeData{
+		Email:         email,
+		Name:          user.GetString("name"),
+		Avatar:        avatarURL(user, avatarThumbSmall),
+		Organization:  organization,
+		Organizations: organizations,
+		Usage:         usage,
 	}); err != nil {
 		http.Error(w, "Error rendering home page: "+err.Error(), http.StatusInternalServerError)
 	}
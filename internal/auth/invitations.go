@@ -0,0 +1,532 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// How long an invitation link stays valid
+const invitationDuration = 7 * 24 * time.Hour
+
+// InvitationInfo represents a pending invitation
+type InvitationInfo struct {
+	Id      string    `json:"id"`
+	Email   string    `json:"email"`
+	Role    string    `json:"role"`
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired"`
+}
+
+// InvitationData represents the data for the invitation acceptance page
+type InvitationData struct {
+	Token        string `json:"token,omitempty"`
+	Email        string `json:"email"`
+	Organization string `json:"organization"`
+	Role         string `json:"role"`
+	LoggedIn     bool   `json:"loggedIn"`
+	Error        string `json:"error,omitempty"`
+}
+
+// findInvitationByToken returns the unexpired invitation a link token belongs to
+func findInvitationByToken(token string) (*core.Record, error) {
+	if token == "" {
+		return nil, errors.New("missing invitation token")
+	}
+
+	invitation, err := PbClient.FindFirstRecordByData("invitations", "tokenHash", hashToken(token))
+	if err != nil {
+		return nil, errors.New("invalid invitation")
+	}
+
+	if invitation.GetDateTime("expires").Time().Before(time.Now()) {
+		return nil, errors.New("expired invitation")
+	}
+
+	return invitation, nil
+}
+
+// sendInvitation issues a fresh link token for an invitation, extends its expiry and emails it.
+// Issuing a new token invalidates any link sent before.
+func sendInvitation(invitation *core.Record, organization *core.Record) error {
+	token := security.RandomString(48)
+
+	invitation.Set("tokenHash", hashToken(token))
+	invitation.Set("expires", time.Now().Add(invitationDuration))
+	if err := PbClient.Save(invitation); err != nil {
+		return err
+	}
+
+	return sendEmail(invitation.GetString("email"), "You've been invited to join "+organization.GetString("name"), "invitation.html", EmailData{
+		Link:         AppURL + "/invitations/accept?token=" + url.QueryEscape(token),
+		Organization: organization.GetString("name"),
+	})
+}
+
+// Returned when inviting another member would exceed the plan's seats
+var errSeatLimitReached = errors.New("all seats of your plan are taken, upgrade your plan or revoke a pending invitation to invite more members")
+
+// createInvitation invites an email address to an organization
+func createInvitation(organization *core.Record, inviter *core.Record, email string, role string) (*core.Record, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if role != RoleAdmin && role != RoleMember {
+		return nil, errors.New("role must be admin or member")
+	}
+
+	if existing, err := PbClient.FindAuthRecordByEmail("users", email); err == nil {
+		if _, err := findMembership(organization.Id, existing.Id); err == nil {
+			return nil, errors.New(email + " is already a member")
+		}
+	}
+
+	// An expired invitation is replaced by the new one
+	if pending, err := PbClient.FindFirstRecordByFilter(
+		"invitations",
+		"organization = {:organization} && email = {:email}",
+		dbx.Params{"organization": organization.Id, "email": email},
+	); err == nil {
+		if pending.GetDateTime("expires").Time().After(time.Now()) {
+			return nil, errors.New(email + " has already been invited, resend the pending invitation instead")
+		}
+		if err := PbClient.Delete(pending); err != nil {
+			return nil, err
+		}
+	}
+
+	seats, err := countSeats(organization.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !organizationEntitlements(organization.Id).Allows(LimitSeats, seats) {
+		return nil, errSeatLimitReached
+	}
+
+	collection, err := PbClient.FindCachedCollectionByNameOrId("invitations")
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := core.NewRecord(collection)
+	invitation.Set("organization", organization.Id)
+	invitation.Set("email", email)
+	invitation.Set("role", role)
+	invitation.Set("invitedBy", inviter.Id)
+
+	if err := sendInvitation(invitation, organization); err != nil {
+		if invitation.IsNew() {
+			return nil, err
+		}
+		log.Printf("⚠️ Failed to send invitation email: %v", err)
+	}
+
+	return invitation, nil
+}
+
+// acceptInvitation adds the user to the invited organization and consumes the invitation
+func acceptInvitation(invitation *core.Record, user *core.Record) error {
+	if !strings.EqualFold(invitation.GetString("email"), user.Email()) {
+		return errors.New("this invitation was sent to " + invitation.GetString("email") + ", please sign in with that account to accept it")
+	}
+
+	return PbClient.RunInTransaction(func(txApp core.App) error {
+		organizationId := invitation.GetString("organization")
+
+		// Accepting twice just consumes the invitation
+		if _, err := txApp.FindFirstRecordByFilter(
+			"memberships",
+			"organization = {:organization} && user = {:user}",
+			dbx.Params{"organization": organizationId, "user": user.Id},
+		); err != nil {
+			memberships, err := txApp.FindCollectionByNameOrId("memberships")
+			if err != nil {
+				return err
+			}
+
+			membership := core.NewRecord(memberships)
+			membership.Set("organization", organizationId)
+			membership.Set("user", user.Id)
+			membership.Set("role", invitation.GetString("role"))
+			if err := txApp.Save(membership); err != nil {
+				return err
+			}
+		}
+
+		// Receiving the invitation proves ownership of the email it was sent to
+		if !user.Verified() {
+			user.SetVerified(true)
+			if err := txApp.Save(user); err != nil {
+				return err
+			}
+		}
+
+		return txApp.Delete(invitation)
+	})
+}
+
+// listInvitations returns the pending invitations of an organization
+func listInvitations(organizationId string) ([]InvitationInfo, error) {
+	records, err := PbClient.FindRecordsByFilter(
+		"invitations",
+		"organization = {:organization}",
+		"-created",
+		0,
+		0,
+		dbx.Params{"organization": organizationId},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	invitations := make([]InvitationInfo, 0, len(records))
+	for _, record := range records {
+		expires := record.GetDateTime("expires").Time()
+		invitations = append(invitations, InvitationInfo{
+			Id:      record.Id,
+			Email:   record.GetString("email"),
+			Role:    record.GetString("role"),
+			Expires: expires,
+			Expired: expires.Before(now),
+		})
+	}
+
+	return invitations, nil
+}
+
+// findOrganizationInvitation returns an invitation of the active organization
+func findOrganizationInvitation(r *http.Request, id string) (*core.Record, error) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		return nil, errors.New("no active organization")
+	}
+
+	invitation, err := PbClient.FindRecordById("invitations", id)
+	if err != nil || invitation.GetString("organization") != organization.Id {
+		return nil, errors.New("invitation not found")
+	}
+
+	return invitation, nil
+}
+
+// setInviteCookie remembers an invitation while the user logs in or registers
+func setInviteCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_invite",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Hour.Seconds()),
+	})
+}
+
+// clearInviteCookie removes the pending invitation cookie
+func clearInviteCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_invite",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		MaxAge:   -1,
+	})
+}
+
+// loginRedirectURL returns where to send the user after logging in, bringing them
+// back to a pending invitation if they were asked to log in to accept one
+func loginRedirectURL(r *http.Request) string {
+	if token := cookieValue(r.Cookie("pb_invite")); token != "" {
+		return "/invitations/accept?token=" + url.QueryEscape(token)
+	}
+	return "/"
+}
+
+// renderInvitation renders the invitation acceptance page
+func renderInvitation(w http.ResponseWriter, r *http.Request, data InvitationData) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "invitation.html", data); err != nil {
+		http.Error(w, "Error rendering invitation page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// invitationData describes an invitation for the acceptance page
+func invitationData(invitation *core.Record, token string) InvitationData {
+	data := InvitationData{
+		Token: token,
+		Email: invitation.GetString("email"),
+		Role:  invitation.GetString("role"),
+	}
+
+	if organization, err := PbClient.FindRecordById("organizations", invitation.GetString("organization")); err == nil {
+		data.Organization = organization.GetString("name")
+	}
+
+	return data
+}
+
+// auditInvitationAccept records a user joining an organization through an invitation
+func auditInvitationAccept(r *http.Request, invitation *core.Record, user *core.Record) {
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationAccept,
+		Actor:        user,
+		Organization: invitation.GetString("organization"),
+		Target:       "user:" + user.Id,
+		Details:      map[string]any{"invitation": invitation.Id, "role": invitation.GetString("role")},
+	})
+}
+
+// AcceptInvitationHandler shows an invitation and adds the logged in user to the organization
+func AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if PbClient == nil {
+		http.Error(w, "Invitation system not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if r.Method != "GET" {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			return
+		}
+		token = r.FormValue("token")
+	}
+
+	invitation, err := findInvitationByToken(token)
+	if err != nil {
+		clearInviteCookie(w)
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusBadRequest, "Invalid or expired invitation")
+			return
+		}
+		renderInvitation(w, r, InvitationData{
+			Error: "This invitation is invalid or has expired. Ask the organization to send you a new one.",
+		})
+		return
+	}
+
+	data := invitationData(invitation, token)
+
+	user := GetCurrentUser(r)
+	if user == nil {
+		if isAPIRequest(r) {
+			unauthorized(w, r, tokenFromRequest(r) != "")
+			return
+		}
+
+		// Come back here after logging in or registering
+		setInviteCookie(w, token)
+		renderInvitation(w, r, data)
+		return
+	}
+
+	data.LoggedIn = true
+
+	if r.Method == "GET" {
+		renderInvitation(w, r, data)
+		return
+	}
+
+	if err := acceptInvitation(invitation, user); err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		data.Error = err.Error()
+		renderInvitation(w, r, data)
+		return
+	}
+
+	auditInvitationAccept(r, invitation, user)
+
+	organizationId := invitation.GetString("organization")
+	clearInviteCookie(w)
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"organization": organizationId,
+		})
+		return
+	}
+
+	setOrganizationCookie(w, organizationId)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// InvitationsHandler lists the pending invitations of the active organization
+func InvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermissionMembersManage) {
+		forbidden(w, r, "Only owners and admins can manage invitations")
+		return
+	}
+
+	invitations, err := listInvitations(GetCurrentOrganization(r).Id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load invitations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"invitations": invitations,
+	})
+}
+
+// CreateInvitationHandler invites an email address to the active organization
+func CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	membership := GetCurrentMembership(r)
+	if user == nil || membership == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	if !HasPermission(r, PermissionMembersManage) {
+		forbidden(w, r, "Only owners and admins can invite members")
+		return
+	}
+
+	email := r.FormValue("email")
+	role := r.FormValue("role")
+	if role == "" {
+		role = RoleMember
+	}
+
+	if email == "" {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusBadRequest, "Email is required")
+			return
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Email is required"})
+		return
+	}
+
+	invitation, err := createInvitation(GetCurrentOrganization(r), user, email, role)
+	if err != nil {
+		if isAPIRequest(r) {
+			status := http.StatusBadRequest
+			if errors.Is(err, errSeatLimitReached) {
+				status = http.StatusPaymentRequired
+			}
+			writeJSONError(w, status, err.Error())
+			return
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Failed to invite " + email + ": " + err.Error()})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationCreate,
+		Organization: invitation.GetString("organization"),
+		Target:       "invitation:" + invitation.Id,
+		Details:      map[string]any{"email": invitation.GetString("email"), "role": invitation.GetString("role")},
+	})
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(InvitationInfo{
+			Id:      invitation.Id,
+			Email:   invitation.GetString("email"),
+			Role:    invitation.GetString("role"),
+			Expires: invitation.GetDateTime("expires").Time(),
+		})
+		return
+	}
+
+	renderOrganizations(w, r, OrganizationsData{Success: "An invitation has been sent to " + invitation.GetString("email") + "."})
+}
+
+// ResendInvitationHandler emails a pending invitation again with a new link and expiry
+func ResendInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermissionMembersManage) {
+		forbidden(w, r, "Only owners and admins can manage invitations")
+		return
+	}
+
+	invitation, err := findOrganizationInvitation(r, mux.Vars(r)["id"])
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusNotFound, "Invitation not found")
+			return
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Invitation not found"})
+		return
+	}
+
+	if err := sendInvitation(invitation, GetCurrentOrganization(r)); err != nil {
+		log.Printf("⚠️ Failed to resend invitation: %v", err)
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to resend invitation")
+			return
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Failed to resend invitation"})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationResend,
+		Organization: invitation.GetString("organization"),
+		Target:       "invitation:" + invitation.Id,
+		Details:      map[string]any{"email": invitation.GetString("email")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	renderOrganizations(w, r, OrganizationsData{Success: "The invitation to " + invitation.GetString("email") + " has been resent."})
+}
+
+// RevokeInvitationHandler cancels a pending invitation
+func RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	if !HasPermission(r, PermissionMembersManage) {
+		forbidden(w, r, "Only owners and admins can manage invitations")
+		return
+	}
+
+	invitation, err := findOrganizationInvitation(r, mux.Vars(r)["id"])
+	if err == nil {
+		err = PbClient.Delete(invitation)
+	}
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusNotFound, "Invitation not found")
+			return
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Invitation not found"})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditInvitationRevoke,
+		Organization: invitation.GetString("organization"),
+		Target:       "invitation:" + invitation.Id,
+		Details:      map[string]any{"email": invitation.GetString("email")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	renderOrganizations(w, r, OrganizationsData{Success: "The invitation to " + invitation.GetString("email") + " has been revoked."})
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newTestInvitation stores an invitation to an organization and returns its link token
+func newTestInvitation(t *testing.T, organization *core.Record, inviter *core.Record, email string, expires time.Time) string {
+	t.Helper()
+
+	collection, err := PbClient.FindCollectionByNameOrId("invitations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := "invite-" + email
+	invitation := core.NewRecord(collection)
+	invitation.Set("organization", organization.Id)
+	invitation.Set("email", email)
+	invitation.Set("role", RoleMember)
+	invitation.Set("invitedBy", inviter.Id)
+	invitation.Set("tokenHash", hashToken(token))
+	invitation.Set("expires", expires)
+	if err := PbClient.Save(invitation); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestExpiredInvitationReplaced(t *testing.T) {
+	app := newTestApp(t)
+	newSMTPCapture(t, app)
+	owner := createTestUser(t, "owner@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTestInvitation(t, organization, owner, "late@example.com", time.Now().Add(-time.Hour))
+	newTestInvitation(t, organization, owner, "pending@example.com", time.Now().Add(time.Hour))
+
+	invitation, err := createInvitation(organization, owner, "late@example.com", RoleMember)
+	if err != nil {
+		t.Fatalf("expired invitation blocked a new one: %v", err)
+	}
+	if !invitation.GetDateTime("expires").Time().After(time.Now()) {
+		t.Fatal("new invitation is already expired")
+	}
+	if total, _ := app.CountRecords("invitations", dbx.HashExp{"email": "late@example.com"}); total != 1 {
+		t.Fatalf("expected the expired invitation to be replaced, got %d invitations", total)
+	}
+
+	if _, err := createInvitation(organization, owner, "pending@example.com", RoleMember); err == nil {
+		t.Fatal("pending invitation was duplicated")
+	}
+}
+
+func TestRegisterWithInvitationForAnotherEmail(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, "owner@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := newTestInvitation(t, organization, owner, "invited@example.com", time.Now().Add(time.Hour))
+
+	w := postForm(RegisterHandler, "/auth/register", url.Values{
+		"email":           {"someone-else@example.com"},
+		"password":        {testPassword},
+		"confirmPassword": {testPassword},
+		"invite":          {token},
+	})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "This invitation was sent to invited@example.com") {
+		t.Fatalf("invitation error wasn't shown, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := app.FindAuthRecordByEmail("users", "someone-else@example.com"); err == nil {
+		t.Fatal("account was created for an invitation sent to someone else")
+	}
+
+	// The invited address registers, joins the organization and keeps a workspace of its own
+	w = postForm(RegisterHandler, "/auth/register", url.Values{
+		"email":           {"invited@example.com"},
+		"password":        {testPassword},
+		"confirmPassword": {testPassword},
+		"invite":          {token},
+	})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unexpected registration response %d: %s", w.Code, w.Body.String())
+	}
+	user, err := app.FindAuthRecordByEmail("users", "invited@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findMembership(organization.Id, user.Id); err != nil {
+		t.Fatal("invited user didn't join the organization")
+	}
+	if memberships, _ := listMemberships(user.Id); len(memberships) != 2 {
+		t.Fatalf("expected a personal workspace and the invited organization, got %d memberships", len(memberships))
+	}
+}
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Login throttling parameters
+const (
+	// Failed attempts on a single account before it is temporarily locked
+	accountLockoutThreshold = 5
+
+	// Failed attempts from a single IP before it is temporarily locked
+	// (higher because offices and mobile carriers share addresses)
+	ipLockoutThreshold = 20
+
+	// How long a lockout lasts
+	lockoutDuration = 15 * time.Minute
+
+	// Failures older than this no longer count towards a lockout
+	failureWindow = time.Hour
+
+	// Upper bound of the delay enforced between consecutive failed attempts
+	maxLoginBackoff = 30 * time.Second
+)
+
+// Email send throttling parameters. Unauthenticated forms that email an address
+// share the login_attempts store so they can't be used to flood an inbox.
+const (
+	// Emails of one kind sent to a single address before it is paused for lockoutDuration
+	emailSendLimit = 3
+
+	// Emails of one kind requested from a single IP before it is paused for lockoutDuration
+	emailSendIPLimit = 10
+)
+
+// Kinds of email throttled per address and IP
+const (
+	emailVerification = "verification"
+	emailLoginCode    = "login-code"
+)
+
+// throttledEmails lists every throttled kind, to clean up an address's counters
+var throttledEmails = []string{emailVerification, emailLoginCode}
+
+// emailSendKey returns the login_attempts key counting emails of a kind sent to an address
+func emailSendKey(kind string, email string) string {
+	return "send:" + kind + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// emailSendIPKey returns the login_attempts key counting emails of a kind requested from an IP
+func emailSendIPKey(kind string, ip string) string {
+	return "send-ip:" + kind + ":" + ip
+}
+
+// accountAttemptKey returns the login_attempts key for an account
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipAttemptKey returns the login_attempts key for a client IP
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// otpAttemptKey returns the login_attempts key counting wrong guesses of a login code
+func otpAttemptKey(otpId string) string {
+	return "otp:" + otpId
+}
+
+// attemptWait returns how long a client has to wait before the next attempt is allowed.
+// With backoff, each failure doubles the delay (1s, 2s, 4s, ...) until the lockout threshold is reached.
+func attemptWait(attempt *core.Record, now time.Time, backoff bool) time.Duration {
+	if lockedUntil := attempt.GetDateTime("lockedUntil").Time(); lockedUntil.After(now) {
+		return lockedUntil.Sub(now)
+	}
+
+	if !backoff {
+		return 0
+	}
+
+	failures := attempt.GetInt("failures")
+	lastFailure := attempt.GetDateTime("lastFailure").Time()
+	if failures == 0 || now.Sub(lastFailure) > failureWindow {
+		return 0
+	}
+
+	delay := maxLoginBackoff
+	if failures <= 6 {
+		delay = min(time.Second<<(failures-1), maxLoginBackoff)
+	}
+
+	if allowedAt := lastFailure.Add(delay); allowedAt.After(now) {
+		return allowedAt.Sub(now)
+	}
+
+	return 0
+}
+
+// loginBlockedFor returns how long the client has to wait before it may try to log in
+// again, considering both the client IP and the account (when the email is known).
+// Only accounts are slowed down between failures so a shared IP isn't throttled for everyone.
+func loginBlockedFor(r *http.Request, email string) time.Duration {
+	now := time.Now()
+
+	var wait time.Duration
+	if attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", ipAttemptKey(clientIP(r))); err == nil {
+		wait = attemptWait(attempt, now, false)
+	}
+
+	if email != "" {
+		if attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", accountAttemptKey(email)); err == nil {
+			wait = max(wait, attemptWait(attempt, now, true))
+		}
+	}
+
+	return wait
+}
+
+// registerFailure increments the failure counter of a key and reports whether it just got locked
+func registerFailure(key string, threshold int, now time.Time) bool {
+	attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", key)
+	if err != nil {
+		collection, err := PbClient.FindCachedCollectionByNameOrId("login_attempts")
+		if err != nil {
+			log.Printf("⚠️ Failed to record login failure: %v", err)
+			return false
+		}
+		attempt = core.NewRecord(collection)
+		attempt.Set("key", key)
+	}
+
+	failures := attempt.GetInt("failures")
+	if now.Sub(attempt.GetDateTime("lastFailure").Time()) > failureWindow {
+		failures = 0
+	}
+	failures++
+
+	locked := failures >= threshold
+	if locked {
+		attempt.Set("lockedUntil", now.Add(lockoutDuration))
+		failures = 0
+	}
+
+	attempt.Set("failures", failures)
+	attempt.Set("lastFailure", now)
+
+	if err := PbClient.Save(attempt); err != nil {
+		log.Printf("⚠️ Failed to record login failure: %v", err)
+	}
+
+	return locked
+}
+
+// allowEmailSend reports whether an email of a kind may be sent to an address for
+// this request, counting the send against both the address and the client IP.
+// Callers answer the same way whether or not the email went out.
+func allowEmailSend(r *http.Request, kind string, email string) bool {
+	now := time.Now()
+	ip := clientIP(r)
+	keys := []string{emailSendKey(kind, email), emailSendIPKey(kind, ip)}
+
+	for _, key := range keys {
+		if attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", key); err == nil && attemptWait(attempt, now, false) > 0 {
+			log.Printf("🔒 Not sending %s email to %s requested from %s: too many requests", kind, email, ip)
+			return false
+		}
+	}
+
+	registerFailure(keys[0], emailSendLimit, now)
+	registerFailure(keys[1], emailSendIPLimit, now)
+	return true
+}
+
+// recordLoginFailure counts a failed attempt against the client IP and the account,
+// notifying the account owner when their account gets locked. The attempt is added to
+// the audit log with the login method that failed.
+func recordLoginFailure(r *http.Request, email string, method string) {
+	now := time.Now()
+
+	var user *core.Record
+	if email != "" {
+		user, _ = PbClient.FindAuthRecordByEmail("users", email)
+	}
+	auditLogin(r, user, email, method, AuditFailure)
+
+	ip := clientIP(r)
+	if registerFailure(ipAttemptKey(ip), ipLockoutThreshold, now) {
+		log.Printf("🔒 Locked out IP %s after repeated failed logins", ip)
+		RecordAuditEvent(r, AuditEvent{Action: AuditLockout, Outcome: AuditDenied, Actor: user, ActorEmail: email, Target: "ip:" + ip})
+	}
+
+	if email == "" {
+		return
+	}
+
+	if registerFailure(accountAttemptKey(email), accountLockoutThreshold, now) {
+		log.Printf("🔒 Locked out account %s after repeated failed logins", email)
+		RecordAuditEvent(r, AuditEvent{Action: AuditLockout, Outcome: AuditDenied, Actor: user, ActorEmail: email, Target: "account:" + email})
+		notifyAccountLocked(email)
+	}
+}
+
+// clearLoginFailures resets the account's failure counter after a successful login.
+// The IP counter is kept so one valid account can't be used to reset it.
+func clearLoginFailures(email string) {
+	attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", accountAttemptKey(email))
+	if err != nil {
+		return
+	}
+
+	if err := PbClient.Delete(attempt); err != nil {
+		log.Printf("⚠️ Failed to clear login failures: %v", err)
+	}
+}
+
+// notifyAccountLocked emails the account owner about the lockout
+func notifyAccountLocked(email string) {
+	record, err := PbClient.FindAuthRecordByEmail("users", email)
+	if err != nil {
+		return
+	}
+
+	if err := sendEmail(record.Email(), "Your account has been temporarily locked", "account_locked.html", EmailData{
+		Link: AppURL + "/auth/forgot-password",
+	}); err != nil {
+		log.Printf("⚠️ Failed to send lockout email: %v", err)
+	}
+}
+
+// lockoutMessage returns the error shown to a throttled client
+func lockoutMessage(wait time.Duration) string {
+	if wait < time.Minute {
+		seconds := int(wait.Round(time.Second).Seconds())
+		return fmt.Sprintf("Too many failed attempts. Please try again in %d second(s).", max(seconds, 1))
+	}
+
+	minutes := int((wait + time.Minute - 1) / time.Minute)
+	return fmt.Sprintf("Too many failed attempts. Please try again in %d minute(s).", minutes)
+}
+
+// writeTooManyAttempts responds to a throttled API client
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+	http.Error(w, lockoutMessage(wait), http.StatusTooManyRequests)
+}
+
+// UnlockLogin removes the lockout of an account and/or IP address
+func UnlockLogin(app core.App, email string, ip string) (int, error) {
+	keys := []string{}
+	if email != "" {
+		keys = append(keys, accountAttemptKey(email))
+	}
+	if ip != "" {
+		keys = append(keys, ipAttemptKey(ip))
+	}
+
+	unlocked := 0
+	for _, key := range keys {
+		attempt, err := app.FindFirstRecordByData("login_attempts", "key", key)
+		if err != nil {
+			continue
+		}
+		if err := app.Delete(attempt); err != nil {
+			return unlocked, err
+		}
+		unlocked++
+	}
+
+	return unlocked, nil
+}
+
+// UnlockLoginHandler lets superusers lift a lockout early
+func UnlockLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Failed to parse form data")
+		return
+	}
+
+	email := r.FormValue("email")
+	ip := r.FormValue("ip")
+	if email == "" && ip == "" {
+		writeJSONError(w, http.StatusBadRequest, "An email or ip is required")
+		return
+	}
+
+	unlocked, err := UnlockLogin(PbClient, email, ip)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to unlock: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"unlocked": unlocked,
+	})
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// apiLogin posts credentials to the API login from an IP address
+func apiLogin(email string, password string, ip string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}, "password": {password}}
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = ip + ":4000"
+	r = mux.SetURLVars(r, map[string]string{"action": "login"})
+	w := httptest.NewRecorder()
+	PocketBaseAuthHandler(w, r)
+	return w
+}
+
+func TestAccountLockoutAndUnlock(t *testing.T) {
+	app := newTestApp(t)
+	capture := newSMTPCapture(t, app)
+	user := createTestUser(t, "locked@example.com")
+
+	if w := apiLogin(user.Email(), "wrong", "198.51.100.1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrong password: got %d", w.Code)
+	}
+
+	// Failures slow the account down, whichever address the next attempt comes from
+	w := apiLogin(user.Email(), testPassword, "198.51.100.2")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("attempt right after a failure: got %d", w.Code)
+	}
+
+	// Reaching the threshold locks the account and tells its owner
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	for i := 1; i < accountLockoutThreshold; i++ {
+		recordLoginFailure(r, user.Email(), "password")
+	}
+	capture.waitForEmail(t, user.Email())
+
+	w = apiLogin(user.Email(), testPassword, "198.51.100.3")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "minute") {
+		t.Fatalf("locked account: got %d %s", w.Code, w.Body.String())
+	}
+
+	// A superuser lifts the lockout early
+	form := url.Values{"email": {user.Email()}}
+	unlock := httptest.NewRequest(http.MethodPost, "/api/admin/unlock", strings.NewReader(form.Encode()))
+	unlock.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	UnlockLoginHandler(w, unlock)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"unlocked":1`) {
+		t.Fatalf("unlock: got %d %s", w.Code, w.Body.String())
+	}
+
+	if w := apiLogin(user.Email(), testPassword, "198.51.100.3"); w.Code != http.StatusOK {
+		t.Fatalf("login after unlock: got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "shared@example.com")
+
+	// Guessing across many accounts from one address locks the address
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+	r.RemoteAddr = "198.51.100.9:4000"
+	for i := 0; i < ipLockoutThreshold; i++ {
+		recordLoginFailure(r, "", "password")
+	}
+
+	if w := apiLogin(user.Email(), testPassword, "198.51.100.9"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login from a locked IP: got %d", w.Code)
+	}
+	if w := apiLogin(user.Email(), testPassword, "198.51.100.10"); w.Code != http.StatusOK {
+		t.Fatalf("login from another IP: got %d %s", w.Code, w.Body.String())
+	}
+}
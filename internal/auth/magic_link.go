@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Wrong guesses of a login code before the code is deleted
+const otpMaxAttempts = 5
+
+var errInvalidLoginCode = errors.New("invalid or expired login code")
+
+// MagicLinkForm represents the passwordless login form data
+type MagicLinkForm struct {
+	Email   string `json:"email"`
+	OTPId   string `json:"otpId,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Success string `json:"success,omitempty"`
+}
+
+// requestLoginOTP creates a PocketBase OTP for the user with the given email and emails
+// the code and a magic link. While a code sent to the address is still valid its id is
+// returned instead, so repeated requests neither flood the inbox nor multiply the codes
+// that can be guessed. Unknown emails and throttled requests get a dummy id so the
+// response doesn't reveal whether an account exists.
+func requestLoginOTP(r *http.Request, email string) (string, error) {
+	users, err := PbClient.FindCachedCollectionByNameOrId("users")
+	if err != nil {
+		return "", err
+	}
+
+	record, err := PbClient.FindAuthRecordByEmail(users, email)
+	if err != nil {
+		// Unknown addresses count too so the IP limit covers probing for accounts
+		allowEmailSend(r, emailLoginCode, email)
+		return core.GenerateDefaultRandomId(), nil
+	}
+
+	if outstanding, err := PbClient.FindAllOTPsByRecord(record); err == nil {
+		for _, otp := range outstanding {
+			if otp.SentTo() == record.Email() && !otp.HasExpired(users.OTP.DurationTime()) {
+				return otp.Id, nil
+			}
+		}
+	}
+
+	if !allowEmailSend(r, emailLoginCode, email) {
+		return core.GenerateDefaultRandomId(), nil
+	}
+
+	code := security.RandomStringWithAlphabet(users.OTP.Length, "1234567890")
+
+	otp := core.NewOTP(PbClient)
+	otp.SetCollectionRef(users.Id)
+	otp.SetRecordRef(record.Id)
+	otp.SetPassword(code)
+	otp.SetSentTo(record.Email())
+	if err := PbClient.Save(otp); err != nil {
+		return "", err
+	}
+
+	magicLink := fmt.Sprintf("%s/auth/magic-link/verify?otp=%s&code=%s", AppURL, url.QueryEscape(otp.Id), url.QueryEscape(code))
+	if err := sendEmail(record.Email(), "Your login code", "login_code.html", EmailData{
+		Link: magicLink,
+		Code: code,
+	}); err != nil {
+		log.Printf("⚠️ Failed to send login code email: %v", err)
+	}
+
+	return otp.Id, nil
+}
+
+// loginOTPEmail returns the email of the account a login code was issued for, so
+// wrong guesses count against that account. It returns "" for unknown codes.
+func loginOTPEmail(otpId string) string {
+	otp, err := PbClient.FindOTPById(otpId)
+	if err != nil {
+		return ""
+	}
+
+	record, err := PbClient.FindRecordById(otp.CollectionRef(), otp.RecordRef())
+	if err != nil {
+		return ""
+	}
+
+	return record.Email()
+}
+
+// rejectLoginOTPGuess counts a wrong guess of a login code and deletes the code once
+// it has been guessed wrong otpMaxAttempts times, so it can't be brute-forced from
+// many addresses before it expires
+func rejectLoginOTPGuess(otp *core.OTP) {
+	if !registerFailure(otpAttemptKey(otp.Id), otpMaxAttempts, time.Now()) {
+		return
+	}
+
+	log.Printf("🔒 Deleted login code %s after %d wrong guesses", otp.Id, otpMaxAttempts)
+	if err := PbClient.Delete(otp); err != nil {
+		log.Printf("⚠️ Failed to delete login code: %v", err)
+	}
+	clearOTPFailures(otp.Id)
+}
+
+// clearOTPFailures removes the wrong guess counter of a login code that no longer exists
+func clearOTPFailures(otpId string) {
+	attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", otpAttemptKey(otpId))
+	if err != nil {
+		return
+	}
+
+	if err := PbClient.Delete(attempt); err != nil {
+		log.Printf("⚠️ Failed to clear login code failures: %v", err)
+	}
+}
+
+// completeLoginOTP validates an OTP code and returns the user it was issued for.
+// The user's outstanding OTPs are deleted so each code can only be used once, and
+// a code is deleted after otpMaxAttempts wrong guesses.
+func completeLoginOTP(otpId string, code string) (*core.Record, error) {
+	users, err := PbClient.FindCachedCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	otp, err := PbClient.FindOTPById(otpId)
+	if err != nil || otp.CollectionRef() != users.Id {
+		return nil, errInvalidLoginCode
+	}
+
+	if otp.HasExpired(users.OTP.DurationTime()) {
+		return nil, errInvalidLoginCode
+	}
+
+	if !otp.ValidatePassword(code) {
+		rejectLoginOTPGuess(otp)
+		return nil, errInvalidLoginCode
+	}
+
+	record, err := PbClient.FindRecordById(users, otp.RecordRef())
+	if err != nil {
+		return nil, errInvalidLoginCode
+	}
+
+	// Receiving the code proves ownership of the email it was sent to
+	if !record.Verified() && otp.SentTo() == record.Email() {
+		record.SetVerified(true)
+		if err := PbClient.Save(record); err != nil {
+			return nil, err
+		}
+	}
+
+	if outstanding, err := PbClient.FindAllOTPsByRecord(record); err == nil {
+		for _, used := range outstanding {
+			clearOTPFailures(used.Id)
+		}
+	}
+	if err := PbClient.DeleteAllOTPsByRecord(record); err != nil {
+		log.Printf("⚠️ Failed to delete used login codes: %v", err)
+	}
+
+	return record, nil
+}
+
+// MagicLinkHandler shows the passwordless login form and sends login codes
+func MagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		renderTemplate(w, r, "magic_link.html", nil)
+		return
+	}
+
+	// Process form submission
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	email := r.FormValue("email")
+
+	// Validate input
+	if email == "" {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			Error: "Email is required",
+		})
+		return
+	}
+
+	// Make sure PocketBase client is initialized
+	if PbClient == nil {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			Email: email,
+			Error: "Login system not available",
+		})
+		return
+	}
+
+	otpId, err := requestLoginOTP(r, email)
+	if err != nil {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			Email: email,
+			Error: "Failed to create login code",
+		})
+		return
+	}
+
+	renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+		Email:   email,
+		OTPId:   otpId,
+		Success: "If an account with this email exists, we've sent it a login link and code.",
+	})
+}
+
+// MagicLinkVerifyHandler completes a passwordless login with a code or magic link
+func MagicLinkVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		// Email scanners prefetch links, so the magic link only pre-fills a confirmation form
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			OTPId: r.URL.Query().Get("otp"),
+			Code:  r.URL.Query().Get("code"),
+		})
+		return
+	}
+
+	// Process form submission
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	otpId := r.FormValue("otpId")
+	code := r.FormValue("code")
+
+	// Validate input
+	if otpId == "" || code == "" {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			OTPId: otpId,
+			Error: "Login code is required",
+		})
+		return
+	}
+
+	// Make sure PocketBase client is initialized
+	if PbClient == nil {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			OTPId: otpId,
+			Error: "Login system not available",
+		})
+		return
+	}
+
+	// Refuse attempts while the client or the account the code was sent to is throttled
+	email := loginOTPEmail(otpId)
+	if wait := loginBlockedFor(r, email); wait > 0 {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			OTPId: otpId,
+			Error: lockoutMessage(wait),
+		})
+		return
+	}
+
+	// Failures stay counted against the account until the whole login succeeds
+	record, err := completeLoginOTP(otpId, code)
+	if err != nil {
+		recordLoginFailure(r, email, "otp")
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			OTPId: otpId,
+			Error: "Invalid or expired login code. Please request a new one.",
+		})
+		return
+	}
+
+	// Ask for the second factor when two-factor authentication is enabled
+	if hasTwoFactorEnabled(record) {
+		challenge, err := newMFAChallenge(record)
+		if err != nil {
+			renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+				Error: "Failed to start two-factor authentication",
+			})
+			return
+		}
+
+		setMFACookie(w, challenge)
+		http.Redirect(w, r, "/auth/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Start a new session and set the auth cookie
+	if _, err := startSession(w, r, record); err != nil {
+		renderTemplate(w, r, "magic_link.html", MagicLinkForm{
+			Error: "Failed to create authentication token",
+		})
+		return
+	}
+
+	clearLoginFailures(record.Email())
+	auditLogin(r, record, record.Email(), "otp", AuditSuccess)
+
+	// Redirect to home page
+	http.Redirect(w, r, loginRedirectURL(r), http.StatusSeeOther)
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// newTestOTP issues a login code for a user without emailing it
+func newTestOTP(t *testing.T, user *core.Record, code string) *core.OTP {
+	t.Helper()
+
+	otp := core.NewOTP(PbClient)
+	otp.SetCollectionRef(user.Collection().Id)
+	otp.SetRecordRef(user.Id)
+	otp.SetPassword(code)
+	otp.SetSentTo(user.Email())
+	if err := PbClient.Save(otp); err != nil {
+		t.Fatalf("failed to create login code: %v", err)
+	}
+	return otp
+}
+
+func TestLoginOTPDeletedAfterWrongGuesses(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "otp@example.com")
+	otp := newTestOTP(t, user, "12345678")
+
+	for i := 0; i < otpMaxAttempts; i++ {
+		if _, err := completeLoginOTP(otp.Id, "00000000"); err == nil {
+			t.Fatal("wrong code was accepted")
+		}
+	}
+
+	if _, err := app.FindOTPById(otp.Id); err == nil {
+		t.Fatal("login code still exists after repeated wrong guesses")
+	}
+	if _, err := completeLoginOTP(otp.Id, "12345678"); err == nil {
+		t.Fatal("deleted login code was accepted")
+	}
+	if _, err := app.FindFirstRecordByData("login_attempts", "key", otpAttemptKey(otp.Id)); err == nil {
+		t.Fatal("wrong guess counter of the deleted code was kept")
+	}
+}
+
+func TestLoginOTPSingleUse(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "otp@example.com")
+	otp := newTestOTP(t, user, "12345678")
+
+	// A wrong guess below the limit doesn't invalidate the code
+	if _, err := completeLoginOTP(otp.Id, "00000000"); err == nil {
+		t.Fatal("wrong code was accepted")
+	}
+
+	record, err := completeLoginOTP(otp.Id, "12345678")
+	if err != nil || record.Id != user.Id {
+		t.Fatalf("valid code was rejected: %v", err)
+	}
+	if _, err := completeLoginOTP(otp.Id, "12345678"); err == nil {
+		t.Fatal("used code was accepted again")
+	}
+}
+
+func TestLoginOTPFailuresCountAgainstAccount(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "otp@example.com")
+	otp := newTestOTP(t, user, "12345678")
+
+	guess := func(ip string, code string) string {
+		form := url.Values{"otpId": {otp.Id}, "code": {code}}
+		r := httptest.NewRequest(http.MethodPost, "/auth/magic-link/verify", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		MagicLinkVerifyHandler(w, r)
+		return w.Body.String()
+	}
+
+	if body := guess("198.51.100.1", "00000000"); !strings.Contains(body, "Invalid or expired login code") {
+		t.Fatalf("unexpected response to a wrong code: %s", body)
+	}
+
+	// The account is slowed down whichever address the next guess comes from
+	if body := guess("198.51.100.2", "12345678"); !strings.Contains(body, "Too many failed attempts") {
+		t.Fatalf("guess from another address wasn't throttled: %s", body)
+	}
+
+	attempt, err := PbClient.FindFirstRecordByData("login_attempts", "key", accountAttemptKey(user.Email()))
+	if err != nil || attempt.GetInt("failures") != 1 {
+		t.Fatalf("wrong code wasn't counted against the account: %v", err)
+	}
+}
+
+func TestLoginOTPRequestsThrottled(t *testing.T) {
+	app := newTestApp(t)
+	capture := newSMTPCapture(t, app)
+	user := createTestUser(t, "otp@example.com")
+
+	request := func() {
+		t.Helper()
+		w := postForm(MagicLinkHandler, "/auth/magic-link", url.Values{"email": {user.Email()}})
+		if !strings.Contains(w.Body.String(), "sent it a login link and code") {
+			t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// Asking again while the code is valid keeps the code that was sent
+	request()
+	capture.waitForEmail(t, user.Email())
+	request()
+	if capture.count() != 0 {
+		t.Fatal("a new code was sent while the previous one was still valid")
+	}
+	if otps, _ := app.FindAllOTPsByRecord(user); len(otps) != 1 {
+		t.Fatalf("expected 1 outstanding login code, got %d", len(otps))
+	}
+
+	// Once codes are gone, only emailSendLimit of them are sent per window
+	for i := 1; i < emailSendLimit+2; i++ {
+		if err := app.DeleteAllOTPsByRecord(user); err != nil {
+			t.Fatal(err)
+		}
+		request()
+	}
+	for i := 1; i < emailSendLimit; i++ {
+		capture.waitForEmail(t, user.Email())
+	}
+	if capture.count() != 0 {
+		t.Fatalf("%d login codes sent past the limit", capture.count())
+	}
+}
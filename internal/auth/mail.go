@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"bytes"
+	"html/template"
+	"net/mail"
+
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	templatefs "github.com/yourusername/go-saas-template/internal/templates"
+)
+
+// Public base URL used when building links sent by email, set from main
+var AppURL = "http://localhost:8080"
+
+// Templates for outgoing emails
+var emailTemplates = template.Must(template.ParseFS(templatefs.FS,
+	"emails/password_reset.html",
+	"emails/verification.html",
+	"emails/login_code.html",
+	"emails/account_locked.html",
+	"emails/invitation.html",
+	"emails/email_change.html",
+	"emails/data_export.html",
+	"emails/account_deletion.html",
+	"emails/account_deleted.html",
+))
+
+// EmailData represents the data available to every email template
+type EmailData struct {
+	AppName      string
+	Email        string
+	Link         string
+	Code         string
+	Organization string
+}
+
+// sendEmail renders an email template and delivers it through the PocketBase mailer
+func sendEmail(to string, subject string, templateName string, data EmailData) error {
+	settings := PbClient.Settings()
+	data.AppName = settings.Meta.AppName
+	data.Email = to
+
+	var body bytes.Buffer
+	if err := emailTemplates.ExecuteTemplate(&body, templateName, data); err != nil {
+		return err
+	}
+
+	message := &mailer.Message{
+		From: mail.Address{
+			Name:    settings.Meta.SenderName,
+			Address: settings.Meta.SenderAddress,
+		},
+		To:      []mail.Address{{Address: to}},
+		Subject: subject,
+		HTML:    body.String(),
+	}
+
+	return PbClient.NewMailClient().Send(message)
+}
@@ -2,7 +2,9 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
@@ -16,37 +18,172 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
+// tokenFromRequest returns the auth token from the Authorization header or the pb_auth cookie
+func tokenFromRequest(r *http.Request) string {
+	// API clients send "Authorization: Bearer <token>"
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	// Browsers send the auth cookie
+	cookie, err := r.Cookie("pb_auth")
+	if err != nil || cookie == nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// isAPIRequest reports whether the client expects a JSON response instead of HTML
+func isAPIRequest(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeJSONError responds with a JSON error body
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": message,
+	})
+}
+
+// unauthorized rejects the request with a JSON 401 for API clients or a redirect to login for browsers
+func unauthorized(w http.ResponseWriter, r *http.Request, tokenProvided bool) {
+	if !isAPIRequest(r) {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	challenge := `Bearer realm="api"`
+	message := "Authentication required"
+	if tokenProvided {
+		challenge += `, error="invalid_token"`
+		message = "Invalid or expired token"
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	writeJSONError(w, http.StatusUnauthorized, message)
+}
+
 // AuthMiddleware checks if user is authenticated
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get auth token from cookie
-		cookie, err := r.Cookie("pb_auth")
+		// Get auth token from the Authorization header or cookie
+		token := tokenFromRequest(r)
 
-		// If no cookie or error, redirect to login
-		if err != nil || cookie == nil || cookie.Value == "" {
-			http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		// If no token, reject the request
+		if token == "" {
+			unauthorized(w, r, false)
 			return
 		}
 
-		// Verify token using PocketBase
-		token := cookie.Value
+		// Verify the token and make sure its session hasn't been revoked. Personal access
+		// tokens and organization API keys are looked up in the token registry instead.
+		var authRecord, session, apiToken *core.Record
+		var err error
+		if isAPIToken(token) {
+			authRecord, apiToken, err = authenticateAPIToken(token)
+		} else {
+			authRecord, session, err = authenticateToken(token)
+		}
+		if err != nil {
+			// Invalid token, reject the request
+			unauthorized(w, r, true)
+			return
+		}
 
-		// According to go-records documentation, use FindAuthRecordByToken
-		authRecord, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
-		if err != nil || authRecord == nil {
-			// Invalid token, redirect to login
-			http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		// Block unverified accounts when verification is required
+		if RequireVerifiedEmail && !authRecord.Verified() {
+			if isAPIRequest(r) {
+				writeJSONError(w, http.StatusForbidden, "Email address is not verified")
+				return
+			}
+			http.Redirect(w, r, "/auth/verify", http.StatusSeeOther)
 			return
 		}
 
-		// Store user in request context
+		// Store user and session or API token in request context
 		ctx := context.WithValue(r.Context(), userContextKey, authRecord)
+		if apiToken != nil {
+			if !tokenAllowsMethod(apiToken, r.Method) {
+				writeJSONError(w, http.StatusForbidden, "Token is missing the read or write scope for this request")
+				return
+			}
+			touchAPIToken(apiToken, r)
+			ctx = context.WithValue(ctx, apiTokenContextKey, apiToken)
+		} else {
+			touchSession(session, r)
+			ctx = context.WithValue(ctx, sessionContextKey, session)
+		}
 
 		// Continue to next handler with the updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// SuperuserMiddleware only lets PocketBase superusers through, authenticated either with
+// a superuser token or with their email and password over HTTP basic auth
+func SuperuserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if PbClient == nil {
+			writeJSONError(w, http.StatusServiceUnavailable, "Authentication system not available")
+			return
+		}
+
+		if email, password, ok := r.BasicAuth(); ok {
+			// Superuser passwords get the same brute-force protection as user logins
+			if wait := loginBlockedFor(r, email); wait > 0 {
+				writeTooManyAttempts(w, wait)
+				return
+			}
+
+			record, err := PbClient.FindAuthRecordByEmail(core.CollectionNameSuperusers, email)
+			if err != nil || !record.ValidatePassword(password) {
+				recordLoginFailure(r, email, "superuser")
+				w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+				writeJSONError(w, http.StatusUnauthorized, "Invalid superuser credentials")
+				return
+			}
+
+			clearLoginFailures(email)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := tokenFromRequest(r)
+		if token == "" {
+			unauthorized(w, r, false)
+			return
+		}
+
+		record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
+		if err != nil {
+			unauthorized(w, r, true)
+			return
+		}
+
+		if !record.IsSuperuser() {
+			writeJSONError(w, http.StatusForbidden, "Superuser access required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // GetCurrentUser returns the current authenticated user or nil
 func GetCurrentUser(r *http.Request) *core.Record {
 	// Get user from request context
@@ -55,18 +192,19 @@ func GetCurrentUser(r *http.Request) *core.Record {
 		return user
 	}
 
-	// No user in context, try to authenticate with cookie
-	cookie, err := r.Cookie("pb_auth")
-	if err != nil || cookie == nil || cookie.Value == "" {
+	// No user in context, try to authenticate with the request token
+	token := tokenFromRequest(r)
+	if token == "" {
 		return nil
 	}
 
-	// Verify token using PocketBase
-	token := cookie.Value
-
-	// According to go-records documentation, use FindAuthRecordByToken
-	authRecord, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
-	if err != nil || authRecord == nil {
+	// Verify the token and make sure its session hasn't been revoked
+	authenticate := authenticateToken
+	if isAPIToken(token) {
+		authenticate = authenticateAPIToken
+	}
+	authRecord, _, err := authenticate(token)
+	if err != nil {
 		return nil
 	}
 
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "bearer@example.com")
+	token := signIn(t, user)
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(GetCurrentUser(r).Email()))
+	}))
+	call := func(target string, header http.Header, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for key, values := range header {
+			r.Header[key] = values
+		}
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// API clients authenticate with a bearer token, browsers with the cookie
+	w := call("/api/profile", http.Header{"Authorization": {"Bearer " + token}}, nil)
+	if w.Code != http.StatusOK || w.Body.String() != user.Email() {
+		t.Fatalf("bearer token: got %d %s", w.Code, w.Body.String())
+	}
+	w = call("/dashboard", nil, &http.Cookie{Name: "pb_auth", Value: token})
+	if w.Code != http.StatusOK || w.Body.String() != user.Email() {
+		t.Fatalf("auth cookie: got %d %s", w.Code, w.Body.String())
+	}
+
+	rejected := []struct {
+		name      string
+		target    string
+		header    http.Header
+		challenge string
+	}{
+		{"API call without a token", "/api/profile", nil, `Bearer realm="api"`},
+		{"JSON request without a token", "/dashboard", http.Header{"Accept": {"application/json"}}, `Bearer realm="api"`},
+		{"invalid bearer token", "/api/profile", http.Header{"Authorization": {"Bearer not-a-token"}}, `error="invalid_token"`},
+	}
+	for _, req := range rejected {
+		w := call(req.target, req.header, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", req.name, w.Code)
+		}
+		if !strings.Contains(w.Header().Get("WWW-Authenticate"), req.challenge) {
+			t.Errorf("%s: WWW-Authenticate %q, want %s", req.name, w.Header().Get("WWW-Authenticate"), req.challenge)
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("%s: got a %s response", req.name, w.Header().Get("Content-Type"))
+		}
+	}
+
+	// Browsers are still sent to the login page
+	w = call("/dashboard", nil, nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/auth/login" {
+		t.Fatalf("browser without a session: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbauth "github.com/pocketbase/pocketbase/tools/auth"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"golang.org/x/oauth2"
+)
+
+// How long the user has to complete the provider's consent screen
+const oauth2StateDuration = 10 * time.Minute
+
+// OAuth2Provider represents a social login button on the login page
+type OAuth2Provider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// oauth2EnvProviders maps the supported providers to their environment variable prefixes
+var oauth2EnvProviders = []struct {
+	name   string
+	prefix string
+}{
+	{name: pbauth.NameGoogle, prefix: "GOOGLE"},
+	{name: pbauth.NameGithub, prefix: "GITHUB"},
+	{name: pbauth.NameOIDC, prefix: "OIDC"},
+}
+
+// ConfigureOAuth2Providers registers the OAuth2 providers configured through environment
+// variables on the users collection. Providers added through the admin UI are kept.
+func ConfigureOAuth2Providers(app core.App) error {
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, envProvider := range oauth2EnvProviders {
+		clientId := os.Getenv(envProvider.prefix + "_CLIENT_ID")
+		if clientId == "" {
+			continue
+		}
+
+		config := core.OAuth2ProviderConfig{
+			Name:         envProvider.name,
+			ClientId:     clientId,
+			ClientSecret: os.Getenv(envProvider.prefix + "_CLIENT_SECRET"),
+			AuthURL:      os.Getenv(envProvider.prefix + "_AUTH_URL"),
+			TokenURL:     os.Getenv(envProvider.prefix + "_TOKEN_URL"),
+			UserInfoURL:  os.Getenv(envProvider.prefix + "_USERINFO_URL"),
+			DisplayName:  os.Getenv(envProvider.prefix + "_DISPLAY_NAME"),
+		}
+
+		// Replace any existing config for the same provider
+		providers := make([]core.OAuth2ProviderConfig, 0, len(users.OAuth2.Providers)+1)
+		for _, existing := range users.OAuth2.Providers {
+			if existing.Name != config.Name {
+				providers = append(providers, existing)
+			}
+		}
+		users.OAuth2.Providers = append(providers, config)
+		changed = true
+
+		log.Printf("🔑 OAuth2 login enabled for %s", envProvider.name)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	users.OAuth2.Enabled = true
+
+	return app.Save(users)
+}
+
+// oauth2Providers returns the social login providers enabled on the users collection
+func oauth2Providers() []OAuth2Provider {
+	if PbClient == nil {
+		return nil
+	}
+
+	users, err := PbClient.FindCachedCollectionByNameOrId("users")
+	if err != nil || !users.OAuth2.Enabled {
+		return nil
+	}
+
+	providers := make([]OAuth2Provider, 0, len(users.OAuth2.Providers))
+	for _, config := range users.OAuth2.Providers {
+		provider, err := config.InitProvider()
+		if err != nil {
+			continue
+		}
+		providers = append(providers, OAuth2Provider{
+			Name:        config.Name,
+			DisplayName: provider.DisplayName(),
+		})
+	}
+
+	return providers
+}
+
+// initOAuth2Provider initializes a provider from the users collection config
+func initOAuth2Provider(name string) (pbauth.Provider, error) {
+	users, err := PbClient.FindCachedCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	if !users.OAuth2.Enabled {
+		return nil, errors.New("OAuth2 login is disabled")
+	}
+
+	config, ok := users.OAuth2.GetProviderConfig(name)
+	if !ok {
+		return nil, errors.New("unknown OAuth2 provider " + name)
+	}
+
+	provider, err := config.InitProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	provider.SetRedirectURL(AppURL + "/auth/oauth2/" + name + "/callback")
+
+	return provider, nil
+}
+
+// findOrCreateOAuth2User resolves the local user for a provider account.
+// Existing links win, then accounts are linked by the provider's verified email,
+// and finally a new verified user is created.
+func findOrCreateOAuth2User(providerName string, authUser *pbauth.AuthUser) (*core.Record, error) {
+	users, err := PbClient.FindCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	// Already linked account
+	externalAuth, err := PbClient.FindFirstExternalAuthByExpr(dbx.HashExp{
+		"collectionRef": users.Id,
+		"provider":      providerName,
+		"providerId":    authUser.Id,
+	})
+	if err == nil {
+		return PbClient.FindRecordById(users, externalAuth.RecordRef())
+	}
+
+	// Providers only return an email once they have verified it
+	if authUser.Email == "" {
+		return nil, errors.New("the provider did not return a verified email address")
+	}
+
+	var record *core.Record
+	err = PbClient.RunInTransaction(func(txApp core.App) error {
+		existing, findErr := txApp.FindAuthRecordByEmail(users, authUser.Email)
+		record = existing
+		if findErr != nil {
+			// No account yet, create one with an unusable random password
+			record = core.NewRecord(users)
+			record.SetEmail(authUser.Email)
+			record.SetRandomPassword()
+			record.Set("name", authUser.Name)
+		} else if !record.Verified() {
+			// Whoever registered the unverified account may not own the email,
+			// so their password must not keep working on the linked account
+			record.SetRandomPassword()
+		}
+
+		// The provider verified the email, so the account is verified too
+		record.SetVerified(true)
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+		if findErr != nil {
+			if err := ensurePersonalOrganization(txApp, record); err != nil {
+				return err
+			}
+		}
+
+		link := core.NewExternalAuth(txApp)
+		link.SetCollectionRef(users.Id)
+		link.SetRecordRef(record.Id)
+		link.SetProvider(providerName)
+		link.SetProviderId(authUser.Id)
+
+		return txApp.Save(link)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// OAuth2LoginHandler redirects the user to the provider's consent screen
+func OAuth2LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if PbClient == nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Authentication system not available",
+		})
+		return
+	}
+
+	name := mux.Vars(r)["provider"]
+
+	provider, err := initOAuth2Provider(name)
+	if err != nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "This login provider is not available",
+		})
+		return
+	}
+
+	state := security.RandomString(30)
+	codeVerifier := ""
+
+	options := []oauth2.AuthCodeOption{}
+	if provider.PKCE() {
+		codeVerifier = security.RandomString(43)
+		options = append(options,
+			oauth2.SetAuthURLParam("code_challenge", security.S256Challenge(codeVerifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
+	// Remember the state and PKCE verifier until the provider redirects back
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_oauth2",
+		Value:    state + "." + codeVerifier,
+		Path:     "/auth/oauth2",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oauth2StateDuration.Seconds()),
+	})
+
+	http.Redirect(w, r, provider.BuildAuthURL(state, options...), http.StatusSeeOther)
+}
+
+// OAuth2CallbackHandler completes the provider login and sets the auth cookie
+func OAuth2CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if PbClient == nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Authentication system not available",
+		})
+		return
+	}
+
+	name := mux.Vars(r)["provider"]
+	query := r.URL.Query()
+
+	// The state cookie is single-use
+	cookie, err := r.Cookie("pb_oauth2")
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_oauth2",
+		Value:    "",
+		Path:     "/auth/oauth2",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		MaxAge:   -1,
+	})
+
+	if query.Get("error") != "" {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Login was cancelled or denied by the provider",
+		})
+		return
+	}
+
+	state, codeVerifier, _ := strings.Cut(cookieValue(cookie, err), ".")
+	if state == "" || !security.Equal(state, query.Get("state")) {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Your login attempt has expired. Please try again.",
+		})
+		return
+	}
+
+	provider, err := initOAuth2Provider(name)
+	if err != nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "This login provider is not available",
+		})
+		return
+	}
+
+	options := []oauth2.AuthCodeOption{}
+	if codeVerifier != "" {
+		options = append(options, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	}
+
+	token, err := provider.FetchToken(query.Get("code"), options...)
+	if err != nil {
+		log.Printf("⚠️ OAuth2 token exchange with %s failed: %v", name, err)
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Failed to log in with the provider",
+		})
+		return
+	}
+
+	authUser, err := provider.FetchAuthUser(token)
+	if err != nil {
+		log.Printf("⚠️ Failed to fetch OAuth2 user from %s: %v", name, err)
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Failed to log in with the provider",
+		})
+		return
+	}
+
+	record, err := findOrCreateOAuth2User(name, authUser)
+	if err != nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Failed to log in with the provider: " + err.Error(),
+		})
+		return
+	}
+
+	// Ask for the second factor when two-factor authentication is enabled
+	if hasTwoFactorEnabled(record) {
+		challenge, err := newMFAChallenge(record)
+		if err != nil {
+			renderTemplate(w, r, "login.html", LoginForm{
+				Error: "Failed to start two-factor authentication",
+			})
+			return
+		}
+
+		setMFACookie(w, challenge)
+		http.Redirect(w, r, "/auth/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Start a new session and set the auth cookie
+	if _, err := startSession(w, r, record); err != nil {
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Failed to create authentication token",
+		})
+		return
+	}
+
+	auditLogin(r, record, record.Email(), "oauth2:"+name, AuditSuccess)
+
+	http.Redirect(w, r, loginRedirectURL(r), http.StatusSeeOther)
+}
+
+// cookieValue returns the value of a cookie lookup, or an empty string if it failed
+func cookieValue(cookie *http.Cookie, err error) string {
+	if err != nil || cookie == nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// mockOIDCServer is an OpenID Connect provider that approves every login as the
+// configured account
+type mockOIDCServer struct {
+	*httptest.Server
+
+	Subject       string
+	Email         string
+	EmailVerified bool
+
+	mu         sync.Mutex
+	challenges map[string]string // code -> PKCE challenge
+}
+
+// newMockOIDC starts a mock provider and configures it as the "oidc" login
+// provider of the app
+func newMockOIDC(t *testing.T, app core.App) *mockOIDCServer {
+	t.Helper()
+
+	provider := &mockOIDCServer{
+		Subject:       "oidc-user-1",
+		Email:         "oidc@example.com",
+		EmailVerified: true,
+		challenges:    map[string]string{},
+	}
+
+	routes := http.NewServeMux()
+	routes.HandleFunc("/authorize", provider.authorize)
+	routes.HandleFunc("/token", provider.token)
+	routes.HandleFunc("/userinfo", provider.userinfo)
+	provider.Server = httptest.NewServer(routes)
+	t.Cleanup(provider.Close)
+
+	t.Setenv("OIDC_CLIENT_ID", "test-client")
+	t.Setenv("OIDC_CLIENT_SECRET", "test-secret")
+	t.Setenv("OIDC_AUTH_URL", provider.URL+"/authorize")
+	t.Setenv("OIDC_TOKEN_URL", provider.URL+"/token")
+	t.Setenv("OIDC_USERINFO_URL", provider.URL+"/userinfo")
+	t.Setenv("OIDC_DISPLAY_NAME", "Test SSO")
+	if err := ConfigureOAuth2Providers(app); err != nil {
+		t.Fatalf("failed to configure OIDC provider: %v", err)
+	}
+
+	return provider
+}
+
+// authorize plays the consent screen, sending the user straight back with a code
+func (p *mockOIDCServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != "test-client" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := security.RandomString(20)
+	p.mu.Lock()
+	p.challenges[code] = query.Get("code_challenge")
+	p.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	callback.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+// token exchanges a code for an access token, checking the PKCE verifier
+func (p *mockOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	p.mu.Lock()
+	challenge, ok := p.challenges[r.PostForm.Get("code")]
+	delete(p.challenges, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	clientId, clientSecret, _ := r.BasicAuth()
+	if clientId == "" {
+		clientId, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok || clientId != "test-client" || clientSecret != "test-secret" ||
+		security.S256Challenge(r.PostForm.Get("code_verifier")) != challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-" + p.Subject,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// userinfo returns the claims of the approved account
+func (p *mockOIDCServer) userinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer access-"+p.Subject {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"sub":            p.Subject,
+		"name":           "OIDC User",
+		"email":          p.Email,
+		"email_verified": p.EmailVerified,
+	})
+}
+
+// oidcLogin goes through the login redirect, the provider and the callback,
+// returning the callback response
+func oidcLogin(t *testing.T, provider *mockOIDCServer) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oauth2/oidc", nil), map[string]string{"provider": "oidc"})
+	w := httptest.NewRecorder()
+	OAuth2LoginHandler(w, r)
+	if w.Code != http.StatusSeeOther || !strings.HasPrefix(w.Header().Get("Location"), provider.URL+"/authorize") {
+		t.Fatalf("login didn't redirect to the provider: %d %s", w.Code, w.Body.String())
+	}
+	stateCookies := w.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("provider rejected the authorization request: %d", res.StatusCode)
+	}
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || callback.Path != "/auth/oauth2/oidc/callback" {
+		t.Fatalf("provider redirected to %q", res.Header.Get("Location"))
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil), map[string]string{"provider": "oidc"})
+	for _, cookie := range stateCookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	OAuth2CallbackHandler(w, r)
+	return w
+}
+
+// authCookie returns the pb_auth cookie set by a response
+func authCookie(w *httptest.ResponseRecorder) string {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "pb_auth" && cookie.MaxAge >= 0 {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestOAuth2CreatesVerifiedUser(t *testing.T) {
+	app := newTestApp(t)
+	provider := newMockOIDC(t, app)
+
+	providers := oauth2Providers()
+	if len(providers) != 1 || providers[0].Name != "oidc" || providers[0].DisplayName != "Test SSO" {
+		t.Fatalf("unexpected login providers %+v", providers)
+	}
+
+	w := oidcLogin(t, provider)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Fatalf("unexpected callback response %d: %s", w.Code, w.Body.String())
+	}
+
+	token := authCookie(w)
+	if token == "" {
+		t.Fatal("no auth cookie set")
+	}
+	user, err := app.FindAuthRecordByToken(token, core.TokenTypeAuth)
+	if err != nil {
+		t.Fatalf("auth cookie isn't a valid token: %v", err)
+	}
+	if user.Email() != "oidc@example.com" || !user.Verified() || user.GetString("name") != "OIDC User" {
+		t.Fatalf("unexpected user %s verified=%v name=%q", user.Email(), user.Verified(), user.GetString("name"))
+	}
+
+	if _, err := app.FindFirstExternalAuthByExpr(dbx.HashExp{"provider": "oidc", "providerId": "oidc-user-1", "recordRef": user.Id}); err != nil {
+		t.Fatalf("provider account wasn't linked: %v", err)
+	}
+
+	// Logging in again uses the link instead of creating another user
+	oidcLogin(t, provider)
+	if total, _ := app.CountRecords("users"); total != 1 {
+		t.Fatalf("expected 1 user after the second login, got %d", total)
+	}
+}
+
+func TestOAuth2LinksUnverifiedAccount(t *testing.T) {
+	app := newTestApp(t)
+	provider := newMockOIDC(t, app)
+
+	existing, err := CreateUser("oidc@example.com", testPassword, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := oidcLogin(t, provider)
+	if w.Code != http.StatusSeeOther || authCookie(w) == "" {
+		t.Fatalf("unexpected callback response %d: %s", w.Code, w.Body.String())
+	}
+
+	linked, err := app.FindRecordById("users", existing.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linked.Verified() {
+		t.Fatal("linked account wasn't verified")
+	}
+	// Whoever registered the unverified account may not own the email
+	if linked.ValidatePassword(testPassword) {
+		t.Fatal("password of the unverified account still works after linking")
+	}
+}
+
+func TestOAuth2RejectsUnverifiedProviderEmail(t *testing.T) {
+	app := newTestApp(t)
+	provider := newMockOIDC(t, app)
+	provider.EmailVerified = false
+
+	w := oidcLogin(t, provider)
+	if authCookie(w) != "" || !strings.Contains(w.Body.String(), "did not return a verified email address") {
+		t.Fatalf("login with an unverified provider email succeeded: %d %s", w.Code, w.Body.String())
+	}
+	if total, _ := app.CountRecords("users"); total != 0 {
+		t.Fatalf("a user was created for an unverified email")
+	}
+}
+
+func TestOAuth2RejectsMismatchedState(t *testing.T) {
+	newTestApp(t)
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/auth/oauth2/oidc/callback?code=abc&state=forged", nil), map[string]string{"provider": "oidc"})
+	r.AddCookie(&http.Cookie{Name: "pb_oauth2", Value: "expected.verifier"})
+	w := httptest.NewRecorder()
+	OAuth2CallbackHandler(w, r)
+
+	if authCookie(w) != "" || !strings.Contains(w.Body.String(), "Your login attempt has expired") {
+		t.Fatalf("callback accepted a forged state: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,763 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Membership roles, from most to least privileged
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Organization context keys
+const (
+	organizationContextKey contextKey = "organization"
+	membershipContextKey   contextKey = "membership"
+)
+
+// Header API clients use to pick the active organization
+const organizationHeaderName = "X-Organization"
+
+// OrganizationInfo represents an organization the current user belongs to
+type OrganizationInfo struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Slug    string `json:"slug"`
+	Role    string `json:"role"`
+	Current bool   `json:"current"`
+}
+
+// MemberInfo represents a member of an organization
+type MemberInfo struct {
+	Id     string    `json:"id"`
+	Email  string    `json:"email"`
+	Name   string    `json:"name"`
+	Role   string    `json:"role"`
+	Joined time.Time `json:"joined"`
+}
+
+// OrganizationsData represents the data for the organizations page
+type OrganizationsData struct {
+	Email         string             `json:"email"`
+	Organizations []OrganizationInfo `json:"organizations"`
+	Current       OrganizationInfo   `json:"current"`
+	Members       []MemberInfo       `json:"members"`
+	Invitations   []InvitationInfo   `json:"invitations,omitempty"`
+	CanManage     bool               `json:"canManage"`
+	Name          string             `json:"name,omitempty"`
+	Error         string             `json:"error,omitempty"`
+	Success       string             `json:"success,omitempty"`
+}
+
+// slugify turns an organization name into a URL and subdomain friendly slug
+func slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r == '\'' || r == '’' {
+			continue
+		}
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteRune('-')
+			dash = true
+		}
+	}
+
+	result := strings.Trim(slug.String(), "-")
+	if len(result) > 40 {
+		result = strings.Trim(result[:40], "-")
+	}
+	if result == "" {
+		result = "org"
+	}
+
+	return result
+}
+
+// createOrganization creates an organization with the user as its owner
+func createOrganization(app core.App, user *core.Record, name string) (*core.Record, error) {
+	var organization *core.Record
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		organizations, err := txApp.FindCollectionByNameOrId("organizations")
+		if err != nil {
+			return err
+		}
+
+		// Add a random suffix when the slug is already taken
+		slug := slugify(name)
+		if _, err := txApp.FindFirstRecordByData(organizations, "slug", slug); err == nil {
+			slug += "-" + security.RandomStringWithAlphabet(6, "abcdefghijklmnopqrstuvwxyz0123456789")
+		}
+
+		organization = core.NewRecord(organizations)
+		organization.Set("name", name)
+		organization.Set("slug", slug)
+		if err := txApp.Save(organization); err != nil {
+			return err
+		}
+
+		memberships, err := txApp.FindCollectionByNameOrId("memberships")
+		if err != nil {
+			return err
+		}
+
+		membership := core.NewRecord(memberships)
+		membership.Set("organization", organization.Id)
+		membership.Set("user", user.Id)
+		membership.Set("role", RoleOwner)
+
+		return txApp.Save(membership)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// personalOrganizationName returns the name of the workspace created for new users
+func personalOrganizationName(user *core.Record) string {
+	name := user.GetString("name")
+	if name == "" {
+		name, _, _ = strings.Cut(user.Email(), "@")
+	}
+	return name + "'s Workspace"
+}
+
+// ensurePersonalOrganization creates the user's personal workspace unless they already
+// belong to an organization. New users get theirs in the transaction that creates them,
+// others the first time they need one. The check and the creation share a transaction
+// and SQLite runs those one at a time, so concurrent requests can't both create one.
+func ensurePersonalOrganization(app core.App, user *core.Record) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		memberships, err := txApp.CountRecords("memberships", dbx.HashExp{"user": user.Id})
+		if err != nil || memberships > 0 {
+			return err
+		}
+
+		_, err = createOrganization(txApp, user, personalOrganizationName(user))
+		return err
+	})
+}
+
+// saveNewUser saves a new user together with their personal workspace
+func saveNewUser(user *core.Record) error {
+	return PbClient.RunInTransaction(func(txApp core.App) error {
+		if err := txApp.Save(user); err != nil {
+			return err
+		}
+		return ensurePersonalOrganization(txApp, user)
+	})
+}
+
+// listMemberships returns the user's memberships, oldest first, with their organization expanded
+func listMemberships(userId string) ([]*core.Record, error) {
+	memberships, err := PbClient.FindRecordsByFilter(
+		"memberships",
+		"user = {:user}",
+		"created",
+		0,
+		0,
+		dbx.Params{"user": userId},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, err := range PbClient.ExpandRecords(memberships, []string{"organization"}, nil) {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// findMembership returns the user's membership in an organization
+func findMembership(organizationId string, userId string) (*core.Record, error) {
+	return PbClient.FindFirstRecordByFilter(
+		"memberships",
+		"organization = {:organization} && user = {:user}",
+		dbx.Params{"organization": organizationId, "user": userId},
+	)
+}
+
+// subdomainOrganization returns the organization slug of a request to <slug>.<app host>
+func subdomainOrganization(r *http.Request) string {
+	appURL, err := url.Parse(AppURL)
+	if err != nil || appURL.Hostname() == "" {
+		return ""
+	}
+
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	label, found := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(appURL.Hostname()))
+	if !found || label == "" || label == "www" || strings.Contains(label, ".") {
+		return ""
+	}
+
+	return label
+}
+
+// requestedOrganization returns the organization id or slug explicitly selected by the
+// request through the X-Organization header, an /org/{org} path prefix or a subdomain
+func requestedOrganization(r *http.Request) string {
+	if ref := r.Header.Get(organizationHeaderName); ref != "" {
+		return ref
+	}
+
+	if ref := mux.Vars(r)["org"]; ref != "" {
+		return ref
+	}
+
+	return subdomainOrganization(r)
+}
+
+// resolveOrganization picks the membership of the active organization. An explicitly
+// requested organization must be one the user belongs to; otherwise the organization
+// last switched to is used, falling back to the oldest membership.
+func resolveOrganization(r *http.Request, user *core.Record) (*core.Record, error) {
+	memberships, err := listMemberships(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users created before personal workspaces were, or who left every organization
+	if len(memberships) == 0 {
+		if err := ensurePersonalOrganization(PbClient, user); err != nil {
+			return nil, err
+		}
+		if memberships, err = listMemberships(user.Id); err != nil {
+			return nil, err
+		}
+		if len(memberships) == 0 {
+			return nil, errors.New("failed to create a personal organization")
+		}
+	}
+
+	// Organization API keys only work in the organization they belong to
+	if token := GetCurrentAPIToken(r); token != nil && token.GetString("kind") == TokenOrganization {
+		ref := requestedOrganization(r)
+		for _, membership := range memberships {
+			organization := membership.ExpandedOne("organization")
+			if organization == nil || organization.Id != token.GetString("organization") {
+				continue
+			}
+			if ref == "" || ref == organization.Id || ref == organization.GetString("slug") {
+				return membership, nil
+			}
+		}
+		return nil, errors.New("organization not found")
+	}
+
+	if ref := requestedOrganization(r); ref != "" {
+		for _, membership := range memberships {
+			organization := membership.ExpandedOne("organization")
+			if organization != nil && (organization.Id == ref || organization.GetString("slug") == ref) {
+				return membership, nil
+			}
+		}
+		return nil, errors.New("organization not found")
+	}
+
+	if selected := cookieValue(r.Cookie("pb_org")); selected != "" {
+		for _, membership := range memberships {
+			if membership.GetString("organization") == selected {
+				return membership, nil
+			}
+		}
+	}
+
+	return memberships[0], nil
+}
+
+// OrganizationMiddleware resolves the active organization of the authenticated user.
+// It must run after AuthMiddleware.
+func OrganizationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := GetCurrentUser(r)
+		if user == nil {
+			unauthorized(w, r, false)
+			return
+		}
+
+		membership, err := resolveOrganization(r, user)
+		if err != nil || membership.ExpandedOne("organization") == nil {
+			if isAPIRequest(r) {
+				writeJSONError(w, http.StatusNotFound, "Organization not found")
+				return
+			}
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), organizationContextKey, membership.ExpandedOne("organization"))
+		ctx = context.WithValue(ctx, membershipContextKey, membership)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetCurrentOrganization returns the active organization or nil
+func GetCurrentOrganization(r *http.Request) *core.Record {
+	organization, _ := r.Context().Value(organizationContextKey).(*core.Record)
+	return organization
+}
+
+// GetCurrentMembership returns the current user's membership in the active organization or nil
+func GetCurrentMembership(r *http.Request) *core.Record {
+	membership, _ := r.Context().Value(membershipContextKey).(*core.Record)
+	return membership
+}
+
+// listOrganizations returns the organizations of a user for display
+func listOrganizations(user *core.Record, currentId string) ([]OrganizationInfo, error) {
+	memberships, err := listMemberships(user.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	organizations := make([]OrganizationInfo, 0, len(memberships))
+	for _, membership := range memberships {
+		organization := membership.ExpandedOne("organization")
+		if organization == nil {
+			continue
+		}
+		organizations = append(organizations, OrganizationInfo{
+			Id:      organization.Id,
+			Name:    organization.GetString("name"),
+			Slug:    organization.GetString("slug"),
+			Role:    membership.GetString("role"),
+			Current: organization.Id == currentId,
+		})
+	}
+
+	return organizations, nil
+}
+
+// listMembers returns the members of an organization
+func listMembers(organizationId string) ([]MemberInfo, error) {
+	memberships, err := PbClient.FindRecordsByFilter(
+		"memberships",
+		"organization = {:organization}",
+		"created",
+		0,
+		0,
+		dbx.Params{"organization": organizationId},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, err := range PbClient.ExpandRecords(memberships, []string{"user"}, nil) {
+		return nil, err
+	}
+
+	members := make([]MemberInfo, 0, len(memberships))
+	for _, membership := range memberships {
+		user := membership.ExpandedOne("user")
+		if user == nil {
+			continue
+		}
+		members = append(members, MemberInfo{
+			Id:     membership.Id,
+			Email:  user.Email(),
+			Name:   user.GetString("name"),
+			Role:   membership.GetString("role"),
+			Joined: membership.GetDateTime("created").Time(),
+		})
+	}
+
+	return members, nil
+}
+
+// currentOrganizationInfo describes the active organization of the request
+func currentOrganizationInfo(r *http.Request) OrganizationInfo {
+	organization := GetCurrentOrganization(r)
+	membership := GetCurrentMembership(r)
+	if organization == nil || membership == nil {
+		return OrganizationInfo{}
+	}
+
+	return OrganizationInfo{
+		Id:      organization.Id,
+		Name:    organization.GetString("name"),
+		Slug:    organization.GetString("slug"),
+		Role:    membership.GetString("role"),
+		Current: true,
+	}
+}
+
+// renderOrganizations renders the organizations page, or the same data as JSON for API clients
+func renderOrganizations(w http.ResponseWriter, r *http.Request, data OrganizationsData) {
+	user := GetCurrentUser(r)
+
+	data.Email = user.Email()
+	data.Current = currentOrganizationInfo(r)
+
+	organizations, err := listOrganizations(user, data.Current.Id)
+	if err == nil {
+		data.Organizations = organizations
+	}
+
+	members, err := listMembers(data.Current.Id)
+	if err == nil {
+		data.Members = members
+	}
+
+	data.CanManage = HasPermission(r, PermissionMembersManage)
+	if data.CanManage {
+		if invitations, err := listInvitations(data.Current.Id); err == nil {
+			data.Invitations = invitations
+		}
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "organizations.html", data); err != nil {
+		http.Error(w, "Error rendering organizations page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// OrganizationsHandler lists the current user's organizations and the members of the active one
+func OrganizationsHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentUser(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	data := OrganizationsData{}
+	if r.URL.Query().Get("created") == "true" {
+		data.Success = "Your organization has been created."
+	}
+	if r.URL.Query().Get("transferred") == "true" {
+		data.Success = "Ownership has been transferred. You are now an admin of this organization."
+	}
+	if r.URL.Query().Get("removed") == "true" {
+		data.Success = "The member has been removed."
+	}
+	if r.URL.Query().Get("role_changed") == "true" {
+		data.Success = "The member's role has been changed."
+	}
+
+	renderOrganizations(w, r, data)
+}
+
+// CreateOrganizationHandler creates a new organization owned by the current user and switches to it
+func CreateOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 100 {
+		renderOrganizations(w, r, OrganizationsData{
+			Name:  name,
+			Error: "Organization name must be between 1 and 100 characters",
+		})
+		return
+	}
+
+	organization, err := createOrganization(PbClient, user, name)
+	if err != nil {
+		renderOrganizations(w, r, OrganizationsData{
+			Name:  name,
+			Error: "Failed to create organization: " + err.Error(),
+		})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditOrganizationCreate,
+		Organization: organization.Id,
+		Target:       "organization:" + organization.Id,
+		Details:      map[string]any{"name": organization.GetString("name")},
+	})
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(OrganizationInfo{
+			Id:   organization.Id,
+			Name: organization.GetString("name"),
+			Slug: organization.GetString("slug"),
+			Role: RoleOwner,
+		})
+		return
+	}
+
+	setOrganizationCookie(w, organization.Id)
+	http.Redirect(w, r, "/organizations?created=true", http.StatusSeeOther)
+}
+
+// setOrganizationCookie remembers the organization the user switched to
+func setOrganizationCookie(w http.ResponseWriter, organizationId string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_org",
+		Value:    organizationId,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+	})
+}
+
+// SwitchOrganizationHandler makes another of the user's organizations the active one
+func SwitchOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	organizationId := mux.Vars(r)["id"]
+	if _, err := findMembership(organizationId, user.Id); err != nil {
+		http.Error(w, "Organization not found", http.StatusNotFound)
+		return
+	}
+
+	setOrganizationCookie(w, organizationId)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// memberFromRequest returns the membership of the active organization named in the URL,
+// responding with a 404 when there is none
+func memberFromRequest(w http.ResponseWriter, r *http.Request, organization *core.Record) *core.Record {
+	target, err := PbClient.FindRecordById("memberships", mux.Vars(r)["id"])
+	if err != nil || target.GetString("organization") != organization.Id {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusNotFound, "Member not found")
+			return nil
+		}
+		renderOrganizations(w, r, OrganizationsData{Error: "Member not found"})
+		return nil
+	}
+	return target
+}
+
+// memberChangeDenied returns why a member can't remove or change the role of another
+// membership, or "" when they can. The owner only changes through a transfer, and only
+// the owner manages admins.
+func memberChangeDenied(actor *core.Record, target *core.Record) string {
+	switch {
+	case target.GetString("role") == RoleOwner:
+		return "The owner can't be removed or changed, transfer ownership first"
+	case target.GetString("role") == RoleAdmin && actor.GetString("role") != RoleOwner:
+		return "Only the owner can remove or change admins"
+	}
+	return ""
+}
+
+// removeMember deletes a membership along with the organization API keys its user created
+func removeMember(app core.App, membership *core.Record) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		keys, err := txApp.FindAllRecords("api_tokens", dbx.HashExp{
+			"kind":         TokenOrganization,
+			"user":         membership.GetString("user"),
+			"organization": membership.GetString("organization"),
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := txApp.Delete(key); err != nil {
+				return err
+			}
+		}
+		return txApp.Delete(membership)
+	})
+}
+
+// RemoveMemberHandler removes a member from the active organization
+func RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	membership := GetCurrentMembership(r)
+	if organization == nil || membership == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	target := memberFromRequest(w, r, organization)
+	if target == nil {
+		return
+	}
+	if target.Id == membership.Id {
+		renderOrganizations(w, r, OrganizationsData{Error: "You can't remove yourself from the organization"})
+		return
+	}
+	if reason := memberChangeDenied(membership, target); reason != "" {
+		forbidden(w, r, reason)
+		return
+	}
+
+	if err := removeMember(PbClient, target); err != nil {
+		renderOrganizations(w, r, OrganizationsData{Error: "Failed to remove member: " + err.Error()})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditMemberRemove,
+		Organization: organization.Id,
+		Target:       "membership:" + target.Id,
+		Details:      map[string]any{"user": target.GetString("user"), "role": target.GetString("role")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.Redirect(w, r, "/organizations?removed=true", http.StatusSeeOther)
+}
+
+// ChangeMemberRoleHandler makes a member of the active organization an admin or a member
+func ChangeMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	membership := GetCurrentMembership(r)
+	if organization == nil || membership == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	target := memberFromRequest(w, r, organization)
+	if target == nil {
+		return
+	}
+	if target.Id == membership.Id {
+		renderOrganizations(w, r, OrganizationsData{Error: "You can't change your own role"})
+		return
+	}
+
+	role := r.FormValue("role")
+	if role != RoleAdmin && role != RoleMember {
+		renderOrganizations(w, r, OrganizationsData{Error: "Role must be admin or member"})
+		return
+	}
+	if reason := memberChangeDenied(membership, target); reason != "" {
+		forbidden(w, r, reason)
+		return
+	}
+
+	previous := target.GetString("role")
+	if previous != role {
+		target.Set("role", role)
+		if err := PbClient.Save(target); err != nil {
+			renderOrganizations(w, r, OrganizationsData{Error: "Failed to change role: " + err.Error()})
+			return
+		}
+
+		RecordAuditEvent(r, AuditEvent{
+			Action:       AuditMemberRoleChange,
+			Organization: organization.Id,
+			Target:       "membership:" + target.Id,
+			Details:      map[string]any{"user": target.GetString("user"), "from": previous, "to": role},
+		})
+	}
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	http.Redirect(w, r, "/organizations?role_changed=true", http.StatusSeeOther)
+}
+
+// transferOwnership makes another member the owner of an organization and the previous
+// owner an admin
+func transferOwnership(app core.App, from *core.Record, to *core.Record) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		to.Set("role", RoleOwner)
+		if err := txApp.Save(to); err != nil {
+			return err
+		}
+		if from == nil {
+			return nil
+		}
+		from.Set("role", RoleAdmin)
+		return txApp.Save(from)
+	})
+}
+
+// TransferOwnershipHandler hands the active organization over to another of its members
+func TransferOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	membership := GetCurrentMembership(r)
+	if organization == nil || membership == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if membership.GetString("role") != RoleOwner {
+		forbidden(w, r, "Only the owner can transfer ownership")
+		return
+	}
+
+	target := memberFromRequest(w, r, organization)
+	if target == nil {
+		return
+	}
+	if target.Id == membership.Id {
+		renderOrganizations(w, r, OrganizationsData{Error: "You already own this organization"})
+		return
+	}
+
+	if err := transferOwnership(PbClient, membership.Fresh(), target); err != nil {
+		renderOrganizations(w, r, OrganizationsData{Error: "Failed to transfer ownership: " + err.Error()})
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditOwnershipTransfer,
+		Organization: organization.Id,
+		Target:       "membership:" + target.Id,
+		Details:      map[string]any{"user": target.GetString("user")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Redirect so the page reflects the new roles
+	http.Redirect(w, r, "/organizations?transferred=true", http.StatusSeeOther)
+}
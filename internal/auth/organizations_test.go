@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestNewUserGetsPersonalWorkspace(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "ada@example.com")
+
+	memberships, err := listMemberships(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 1 || memberships[0].GetString("role") != RoleOwner {
+		t.Fatalf("expected the user to own one organization, got %d memberships", len(memberships))
+	}
+	if name := memberships[0].ExpandedOne("organization").GetString("name"); name != "ada's Workspace" {
+		t.Fatalf("unexpected personal workspace name %q", name)
+	}
+}
+
+func TestPersonalWorkspaceCreatedOnce(t *testing.T) {
+	app := newTestApp(t)
+
+	// Users created from the dashboard get their workspace on their first request
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := core.NewRecord(users)
+	user.SetEmail("dashboard@example.com")
+	user.SetPassword(testPassword)
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parallel requests right after the first login
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := resolveOrganization(httptest.NewRequest(http.MethodGet, "/dashboard", nil), user); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if total, _ := app.CountRecords("memberships", dbx.HashExp{"user": user.Id}); total != 1 {
+		t.Fatalf("expected 1 personal workspace, got %d", total)
+	}
+}
+
+// addTestMember adds a user to an organization with a role
+func addTestMember(t *testing.T, organization *core.Record, user *core.Record, role string) *core.Record {
+	t.Helper()
+
+	collection, err := PbClient.FindCollectionByNameOrId("memberships")
+	if err != nil {
+		t.Fatal(err)
+	}
+	membership := core.NewRecord(collection)
+	membership.Set("organization", organization.Id)
+	membership.Set("user", user.Id)
+	membership.Set("role", role)
+	if err := PbClient.Save(membership); err != nil {
+		t.Fatal(err)
+	}
+	return membership
+}
+
+func TestMemberManagement(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, "owner@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := createTestUser(t, "admin@example.com")
+	otherAdmin := createTestUser(t, "other-admin@example.com")
+	member := createTestUser(t, "member@example.com")
+	adminMembership := addTestMember(t, organization, admin, RoleAdmin)
+	otherAdminMembership := addTestMember(t, organization, otherAdmin, RoleAdmin)
+	memberMembership := addTestMember(t, organization, member, RoleMember)
+	ownerMembership, err := findMembership(organization.Id, owner.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memberKey := newTestAPIToken(t, member, TokenOrganization, organization.Id, ScopeRead)
+
+	router := mux.NewRouter()
+	manage := RequirePermission(PermissionMembersManage)
+	router.Handle("/api/organizations/members/{id}", protected(RemoveMemberHandler, manage)).Methods("DELETE")
+	router.Handle("/api/organizations/members/{id}/role", protected(ChangeMemberRoleHandler, manage)).Methods("POST")
+
+	call := func(user *core.Record, method string, target string, body string) int {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+signIn(t, user))
+		r.Header.Set(organizationHeaderName, organization.Id)
+		if body != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	role := func(membership *core.Record) string {
+		t.Helper()
+		fresh, err := app.FindRecordById("memberships", membership.Id)
+		if err != nil {
+			return ""
+		}
+		return fresh.GetString("role")
+	}
+
+	// Members can't manage anyone, admins can't touch the owner or other admins
+	denied := []struct {
+		name   string
+		actor  *core.Record
+		method string
+		target string
+		body   string
+	}{
+		{"member demoting an admin", member, http.MethodPost, "/api/organizations/members/" + adminMembership.Id + "/role", "role=member"},
+		{"member removing an admin", member, http.MethodDelete, "/api/organizations/members/" + adminMembership.Id, ""},
+		{"admin demoting the owner", admin, http.MethodPost, "/api/organizations/members/" + ownerMembership.Id + "/role", "role=member"},
+		{"admin removing the owner", admin, http.MethodDelete, "/api/organizations/members/" + ownerMembership.Id, ""},
+		{"admin demoting another admin", admin, http.MethodPost, "/api/organizations/members/" + otherAdminMembership.Id + "/role", "role=member"},
+		{"admin removing another admin", admin, http.MethodDelete, "/api/organizations/members/" + otherAdminMembership.Id, ""},
+	}
+	for _, req := range denied {
+		if code := call(req.actor, req.method, req.target, req.body); code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", req.name, code)
+		}
+	}
+	if role(adminMembership) != RoleAdmin || role(otherAdminMembership) != RoleAdmin || role(ownerMembership) != RoleOwner {
+		t.Fatal("a refused request changed a membership")
+	}
+
+	// Admins manage members, the owner manages admins
+	if code := call(admin, http.MethodPost, "/api/organizations/members/"+memberMembership.Id+"/role", "role=admin"); code != http.StatusNoContent {
+		t.Fatalf("admin promoting a member: got %d", code)
+	}
+	if role(memberMembership) != RoleAdmin {
+		t.Fatal("member wasn't promoted")
+	}
+	if code := call(owner, http.MethodPost, "/api/organizations/members/"+memberMembership.Id+"/role", "role=member"); code != http.StatusNoContent {
+		t.Fatalf("owner demoting an admin: got %d", code)
+	}
+	if code := call(admin, http.MethodDelete, "/api/organizations/members/"+memberMembership.Id, ""); code != http.StatusNoContent {
+		t.Fatalf("admin removing a member: got %d", code)
+	}
+	if role(memberMembership) != "" {
+		t.Fatal("member wasn't removed")
+	}
+	if _, _, err := authenticateAPIToken(memberKey); err == nil {
+		t.Fatal("the removed member's organization API key still works")
+	}
+
+	for action, want := range map[string]int64{AuditMemberRoleChange: 2, AuditMemberRemove: 1} {
+		if total, _ := app.CountRecords("audit_events", dbx.HashExp{"action": action, "organization": organization.Id}); total != want {
+			t.Errorf("expected %d %s events, got %d", want, action, total)
+		}
+	}
+}
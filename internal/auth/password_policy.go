@@ -0,0 +1,176 @@
+package auth
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy describes the rules new passwords have to satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	DisallowEmail bool
+	RejectCommon  bool
+}
+
+// PasswordViolation is a single password rule that wasn't satisfied
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordRequirement describes a password rule for display on forms
+type PasswordRequirement struct {
+	Rule        string `json:"rule"`
+	Description string `json:"description"`
+}
+
+// Password policy applied to registrations, resets and password changes, set from main.
+// The minimum length counts characters, while the maximum length counts bytes since
+// it has to stay below bcrypt's 72 byte limit whatever characters are used.
+var PasswordRules = PasswordPolicy{
+	MinLength:     8,
+	MaxLength:     71,
+	RequireUpper:  true,
+	RequireLower:  true,
+	RequireDigit:  true,
+	DisallowEmail: true,
+	RejectCommon:  true,
+}
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// Lowercased set of the bundled common passwords
+var commonPasswords = func() map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, line := range strings.Split(commonPasswordList, "\n") {
+		if line = strings.ToLower(strings.TrimSpace(line)); line != "" {
+			set[line] = struct{}{}
+		}
+	}
+	return set
+}()
+
+// isCommonPassword reports whether a password is on the common password list, also
+// catching the usual trick of appending digits or symbols to a common word
+func isCommonPassword(password string) bool {
+	lower := strings.ToLower(password)
+	if _, ok := commonPasswords[lower]; ok {
+		return true
+	}
+
+	base := strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	if utf8.RuneCountInString(base) < 4 {
+		return false
+	}
+
+	_, ok := commonPasswords[base]
+	return ok
+}
+
+// Requirements lists the enabled rules in a human readable form
+func (p PasswordPolicy) Requirements() []PasswordRequirement {
+	requirements := []PasswordRequirement{}
+
+	if p.MaxLength > 0 {
+		requirements = append(requirements, PasswordRequirement{"length", fmt.Sprintf("At least %d characters and at most %d bytes", p.MinLength, p.MaxLength)})
+	} else if p.MinLength > 0 {
+		requirements = append(requirements, PasswordRequirement{"length", fmt.Sprintf("At least %d characters", p.MinLength)})
+	}
+	if p.RequireUpper {
+		requirements = append(requirements, PasswordRequirement{"uppercase", "An uppercase letter"})
+	}
+	if p.RequireLower {
+		requirements = append(requirements, PasswordRequirement{"lowercase", "A lowercase letter"})
+	}
+	if p.RequireDigit {
+		requirements = append(requirements, PasswordRequirement{"digit", "A number"})
+	}
+	if p.RequireSymbol {
+		requirements = append(requirements, PasswordRequirement{"symbol", "A symbol such as ! or #"})
+	}
+	if p.DisallowEmail {
+		requirements = append(requirements, PasswordRequirement{"email", "Not based on your email address"})
+	}
+	if p.RejectCommon {
+		requirements = append(requirements, PasswordRequirement{"common", "Not a commonly used password"})
+	}
+
+	return requirements
+}
+
+// Validate checks a password against every rule and returns the ones it breaks
+func (p PasswordPolicy) Validate(password string, email string) []PasswordViolation {
+	violations := []PasswordViolation{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{"min_length", fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PasswordViolation{"max_length", fmt.Sprintf("Password must be at most %d bytes long; accented letters and emoji take 2 to 4 bytes each", p.MaxLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{"uppercase", "Password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{"lowercase", "Password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{"digit", "Password must contain a number"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{"symbol", "Password must contain a symbol"})
+	}
+
+	if p.DisallowEmail && email != "" {
+		lower := strings.ToLower(password)
+		email = strings.ToLower(strings.TrimSpace(email))
+		localPart, _, _ := strings.Cut(email, "@")
+		if lower == email || (utf8.RuneCountInString(localPart) >= 3 && strings.Contains(lower, localPart)) {
+			violations = append(violations, PasswordViolation{"email", "Password must not contain your email address"})
+		}
+	}
+
+	if p.RejectCommon && isCommonPassword(password) {
+		violations = append(violations, PasswordViolation{"common", "This password is too common. Please choose a less predictable one"})
+	}
+
+	return violations
+}
+
+// writePasswordViolations responds to an API client with the broken password rules
+func writePasswordViolations(w http.ResponseWriter, violations []PasswordViolation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error":      "Password does not meet the requirements",
+		"violations": violations,
+	})
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyMaxLengthCountsBytes(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 71}
+
+	cases := []struct {
+		password string
+		tooLong  bool
+	}{
+		{strings.Repeat("a", 71), false},
+		{strings.Repeat("a", 72), true},
+		// 36 two-byte characters are 72 bytes, which bcrypt would truncate
+		{strings.Repeat("é", 35), false},
+		{strings.Repeat("é", 36), true},
+		{strings.Repeat("🔑", 18), true},
+	}
+
+	for _, c := range cases {
+		tooLong := false
+		for _, violation := range policy.Validate(c.password, "") {
+			if violation.Rule == "max_length" {
+				tooLong = true
+			}
+		}
+		if tooLong != c.tooLong {
+			t.Errorf("%d characters, %d bytes: max_length violated = %v, want %v",
+				len([]rune(c.password)), len(c.password), tooLong, c.tooLong)
+		}
+	}
+}
+
+func TestPasswordPolicyMinLengthCountsCharacters(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 71}
+
+	// Four characters are eight bytes, but still too short
+	for _, violation := range policy.Validate("éééé", "") {
+		if violation.Rule == "min_length" {
+			return
+		}
+	}
+	t.Fatal("a 4 character password passed the 8 character minimum")
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var resetLinkPattern = regexp.MustCompile(`href="([^"]*/auth/reset-password\?token=[^"]+)"`)
+
+// resetTokenFromEmail extracts the token of the reset link in a password reset email
+func resetTokenFromEmail(t *testing.T, email capturedEmail) string {
+	t.Helper()
+
+	match := resetLinkPattern.FindStringSubmatch(email.htmlBody(t))
+	if match == nil {
+		t.Fatalf("password reset email has no reset link")
+	}
+
+	link, err := url.Parse(html.UnescapeString(match[1]))
+	if err != nil {
+		t.Fatalf("invalid reset link %q: %v", match[1], err)
+	}
+	if !strings.HasPrefix(link.String(), AppURL+"/auth/reset-password") {
+		t.Fatalf("reset link %q doesn't point at %s", link, AppURL)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	app := newTestApp(t)
+	capture := newSMTPCapture(t, app)
+	user := createTestUser(t, "reset@example.com")
+
+	w := postForm(ForgotPasswordHandler, "/auth/forgot-password", url.Values{"email": {"reset@example.com"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "password reset instructions have been sent") {
+		t.Fatalf("unexpected forgot password response %d: %s", w.Code, w.Body.String())
+	}
+
+	email := capture.waitForEmail(t, "reset@example.com")
+	if email.From != "noreply@example.com" {
+		t.Errorf("email sent from %q, want the configured sender", email.From)
+	}
+	token := resetTokenFromEmail(t, email)
+
+	// The link works without any cookie from the browser that asked for it
+	r := httptest.NewRequest(http.MethodGet, "/auth/reset-password?token="+url.QueryEscape(token), nil)
+	w = httptest.NewRecorder()
+	ResetPasswordHandler(w, r)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Invalid or expired") {
+		t.Fatalf("reset form rejected a fresh token: %s", w.Body.String())
+	}
+
+	newPassword := "N3w-Battery-Staple!"
+	w = postForm(ResetPasswordHandler, "/auth/reset-password", url.Values{
+		"token":           {token},
+		"password":        {newPassword},
+		"confirmPassword": {newPassword},
+	})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/auth/login?reset_success=true" {
+		t.Fatalf("unexpected reset response %d: %s", w.Code, w.Body.String())
+	}
+
+	updated, err := app.FindRecordById("users", user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.ValidatePassword(newPassword) {
+		t.Fatal("password wasn't changed")
+	}
+
+	// The token is single-use
+	w = postForm(ResetPasswordHandler, "/auth/reset-password", url.Values{
+		"token":           {token},
+		"password":        {"An0ther-Battery-Staple!"},
+		"confirmPassword": {"An0ther-Battery-Staple!"},
+	})
+	if !strings.Contains(w.Body.String(), "Invalid or expired reset token") {
+		t.Fatalf("used token was accepted again: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPasswordResetRejectsForgedToken(t *testing.T) {
+	newTestApp(t)
+	user := createTestUser(t, "forged@example.com")
+
+	// The old cookie-based scheme used "<user id>_<unix nano>" tokens
+	w := postForm(ResetPasswordHandler, "/auth/reset-password", url.Values{
+		"token":           {user.Id + "_1700000000000000000"},
+		"password":        {"N3w-Battery-Staple!"},
+		"confirmPassword": {"N3w-Battery-Staple!"},
+	})
+	if !strings.Contains(w.Body.String(), "Invalid or expired reset token") {
+		t.Fatalf("forged token was accepted: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPasswordResetUnknownEmail(t *testing.T) {
+	app := newTestApp(t)
+	capture := newSMTPCapture(t, app)
+
+	w := postForm(ForgotPasswordHandler, "/auth/forgot-password", url.Values{"email": {"nobody@example.com"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "password reset instructions have been sent") {
+		t.Fatalf("unknown emails must get the same response, got %d: %s", w.Code, w.Body.String())
+	}
+	if capture.count() != 0 {
+		t.Fatal("an email was sent for an unknown address")
+	}
+}
+
+func TestResetPasswordFormWithoutPocketBase(t *testing.T) {
+	client := PbClient
+	PbClient = nil
+	t.Cleanup(func() { PbClient = client })
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/reset-password?token=abc", nil)
+	w := httptest.NewRecorder()
+	ResetPasswordHandler(w, r)
+	if !strings.Contains(w.Body.String(), "Password reset system not available") {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Permissions checked by routes, handlers and templates
+const (
+	PermissionMembersManage  = "members:manage"
+	PermissionBillingView    = "billing:view"
+	PermissionBillingManage  = "billing:manage"
+	PermissionAuditView      = "audit:view"
+	PermissionWebhooksManage = "webhooks:manage"
+	PermissionAPIKeysManage  = "api_keys:manage"
+)
+
+// Permissions granted to each membership role. An entry may end in ":*" to grant
+// every action on a resource, and "*" grants everything.
+var RolePermissions = map[string][]string{
+	RoleOwner:  {"*"},
+	RoleAdmin:  {PermissionMembersManage, PermissionBillingView, PermissionAuditView, PermissionWebhooksManage, PermissionAPIKeysManage},
+	RoleMember: {},
+}
+
+// ForbiddenData represents the data for the 403 page
+type ForbiddenData struct {
+	Message string
+}
+
+// roleHasPermission reports whether a role is granted a permission
+func roleHasPermission(role string, permission string) bool {
+	resource, _, _ := strings.Cut(permission, ":")
+
+	for _, granted := range RolePermissions[role] {
+		if granted == "*" || granted == permission || granted == resource+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasPermission reports whether the current user's role in the active organization
+// grants a permission. Requests authenticated with an API token also need the permission
+// among the token's scopes. It needs OrganizationMiddleware to have run.
+func HasPermission(r *http.Request, permission string) bool {
+	membership := GetCurrentMembership(r)
+	if membership == nil {
+		return false
+	}
+	if token := GetCurrentAPIToken(r); token != nil && !tokenScopeGranted(token, permission) {
+		return false
+	}
+	return roleHasPermission(membership.GetString("role"), permission)
+}
+
+// forbidden rejects the request with a JSON 403 for API clients or the 403 page for browsers
+func forbidden(w http.ResponseWriter, r *http.Request, message string) {
+	event := AuditEvent{
+		Action:  AuditPermissionDenied,
+		Outcome: AuditDenied,
+		Details: map[string]any{"method": r.Method, "path": r.URL.Path},
+	}
+	if organization := GetCurrentOrganization(r); organization != nil {
+		event.Organization = organization.Id
+	}
+	if membership := GetCurrentMembership(r); membership != nil {
+		event.Details["role"] = membership.GetString("role")
+	}
+	RecordAuditEvent(r, event)
+
+	if isAPIRequest(r) {
+		writeJSONError(w, http.StatusForbidden, message)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	if err := renderTemplate(w, r, "forbidden.html", ForbiddenData{Message: message}); err != nil {
+		http.Error(w, message, http.StatusForbidden)
+	}
+}
+
+// RequirePermission returns middleware that only lets requests through when the
+// current user's role in the active organization grants the permission, e.g.
+//
+//	billingRouter.Use(auth.RequirePermission(auth.PermissionBillingManage))
+//
+// It must run after AuthMiddleware and OrganizationMiddleware.
+func RequirePermission(permission string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetCurrentUser(r) == nil {
+				unauthorized(w, r, false)
+				return
+			}
+
+			if !HasPermission(r, permission) {
+				forbidden(w, r, "You don't have permission to do this in this organization")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
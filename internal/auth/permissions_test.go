@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestRequirePermissionByRole(t *testing.T) {
+	app := newTestApp(t)
+	owner := createTestUser(t, "owner@example.com")
+	organization, err := createOrganization(app, owner, "Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]*core.Record{RoleOwner: owner}
+	for _, role := range []string{RoleAdmin, RoleMember} {
+		users[role] = createTestUser(t, role+"@example.com")
+		addTestMember(t, organization, users[role], role)
+	}
+
+	allowed := map[string][]string{
+		RoleOwner:  {PermissionMembersManage, PermissionBillingView, PermissionBillingManage, PermissionAuditView, PermissionWebhooksManage, PermissionAPIKeysManage},
+		RoleAdmin:  {PermissionMembersManage, PermissionBillingView, PermissionAuditView, PermissionWebhooksManage, PermissionAPIKeysManage},
+		RoleMember: {},
+	}
+	permissions := allowed[RoleOwner]
+
+	call := func(user *core.Record, permission string, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		handler := protected(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, RequirePermission(permission))
+
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.AddCookie(&http.Cookie{Name: "pb_auth", Value: signIn(t, user)})
+		r.AddCookie(&http.Cookie{Name: "pb_org", Value: organization.Id})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	denials := 0
+	for role, user := range users {
+		for _, permission := range permissions {
+			want := http.StatusForbidden
+			for _, granted := range allowed[role] {
+				if granted == permission {
+					want = http.StatusOK
+				}
+			}
+			if want == http.StatusForbidden {
+				denials++
+			}
+
+			if w := call(user, permission, "/api/protected"); w.Code != want {
+				t.Errorf("%s with %s over the API: got %d, want %d", role, permission, w.Code, want)
+			} else if want == http.StatusForbidden && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				t.Errorf("%s with %s over the API: got a %s error", role, permission, w.Header().Get("Content-Type"))
+			}
+
+			// Browsers get the 403 page
+			if w := call(user, permission, "/protected"); w.Code != want {
+				t.Errorf("%s with %s in the browser: got %d, want %d", role, permission, w.Code, want)
+			} else if want == http.StatusForbidden && !strings.Contains(w.Body.String(), "permission") {
+				t.Errorf("%s with %s in the browser: unexpected 403 page %s", role, permission, w.Body.String())
+			}
+		}
+	}
+
+	if total, _ := app.CountRecords("audit_events", dbx.HashExp{"action": AuditPermissionDenied, "organization": organization.Id}); int(total) != 2*denials {
+		t.Fatalf("expected %d denied requests in the audit log, got %d", 2*denials, total)
+	}
+}
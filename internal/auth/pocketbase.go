@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// Paths always served by PocketBase: the dashboard and its own REST and realtime API
+var pocketBasePrefixes = []string{
+	"/_/",
+	"/api/collections/",
+	"/api/realtime",
+	"/api/files/",
+	"/api/batch",
+	"/api/health",
+	"/api/logs",
+	"/api/backups",
+	"/api/crons",
+}
+
+// Path both the app and PocketBase serve. The dashboard reaches PocketBase's
+// with a superuser token, everyone else gets the app's account settings.
+const sharedSettingsPrefix = "/api/settings"
+
+// isSuperuserRequest reports whether a request carries a PocketBase superuser token,
+// sent by the dashboard as a bare Authorization header
+func isSuperuserRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return false
+	}
+
+	record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
+	return err == nil && record.IsSuperuser()
+}
+
+// servedByPocketBase reports whether a request has to go to PocketBase even when an
+// app route matches it
+func servedByPocketBase(r *http.Request) bool {
+	for _, prefix := range pocketBasePrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(r.URL.Path, sharedSettingsPrefix) && isSuperuserRequest(r)
+}
+
+// MountPocketBase serves the app's routes and PocketBase's router from a single handler.
+// PocketBase's dashboard and API paths go to PocketBase and any other request matching
+// an app route goes to the app. Remaining API requests fall through to PocketBase, the
+// rest get the app's 404.
+func MountPocketBase(app *mux.Router, pocketBase http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if servedByPocketBase(r) {
+			pocketBase.ServeHTTP(w, r)
+			return
+		}
+
+		var match mux.RouteMatch
+		if !app.Match(r, &match) && strings.HasPrefix(r.URL.Path, "/api/") {
+			pocketBase.ServeHTTP(w, r)
+			return
+		}
+
+		app.ServeHTTP(w, r)
+	})
+}
+
+// recordAPIRequest is a PocketBase record API request event
+type recordAPIRequest interface {
+	HasSuperuserAuth() bool
+	Next() error
+}
+
+// superusersOnly returns a record API hook refusing the request with message unless
+// it is made by a superuser
+func superusersOnly[T recordAPIRequest](message string) func(T) error {
+	return func(e T) error {
+		if !e.HasSuperuserAuth() {
+			return router.NewForbiddenError(message, nil)
+		}
+		return e.Next()
+	}
+}
+
+// RegisterRecordAPIGuards keeps PocketBase's record API from bypassing the app's own
+// account flows. Its sign-in endpoints are refused, so logins go through the app's
+// lockout, two-factor authentication and session tracking. Its sign-up, update, delete,
+// password reset, verification, email change and OTP endpoints are superuser-only, so
+// for users the password policy, session revocation, audit events, confirmation emails
+// and deletion grace period can't be skipped. Superusers keep managing users from the
+// dashboard, and the guards hold even when the collection's API rules are loosened there.
+func RegisterRecordAPIGuards(app core.App) {
+	app.OnRecordAuthRequest("users").BindFunc(func(e *core.RecordAuthRequestEvent) error {
+		return router.NewForbiddenError("Sign in through /api/auth/login instead", nil)
+	})
+
+	app.OnRecordCreateRequest("users").BindFunc(superusersOnly[*core.RecordRequestEvent](
+		"Sign up through /api/auth/register instead"))
+	app.OnRecordUpdateRequest("users").BindFunc(superusersOnly[*core.RecordRequestEvent](
+		"Update your account through /api/profile and /api/settings instead"))
+	app.OnRecordDeleteRequest("users").BindFunc(superusersOnly[*core.RecordRequestEvent](
+		"Delete your account through /api/settings/delete instead"))
+
+	app.OnRecordRequestPasswordResetRequest("users").BindFunc(superusersOnly[*core.RecordRequestPasswordResetRequestEvent](
+		"Request a password reset through /auth/forgot-password instead"))
+	app.OnRecordConfirmPasswordResetRequest("users").BindFunc(superusersOnly[*core.RecordConfirmPasswordResetRequestEvent](
+		"Reset your password through /auth/reset-password instead"))
+	app.OnRecordRequestVerificationRequest("users").BindFunc(superusersOnly[*core.RecordRequestVerificationRequestEvent](
+		"Request a verification email through /auth/verify/resend instead"))
+	app.OnRecordConfirmVerificationRequest("users").BindFunc(superusersOnly[*core.RecordConfirmVerificationRequestEvent](
+		"Verify your email through /auth/verify instead"))
+	app.OnRecordRequestEmailChangeRequest("users").BindFunc(superusersOnly[*core.RecordRequestEmailChangeRequestEvent](
+		"Change your email through /api/settings/email instead"))
+	app.OnRecordConfirmEmailChangeRequest("users").BindFunc(superusersOnly[*core.RecordConfirmEmailChangeRequestEvent](
+		"Confirm your email change through /settings/email/confirm instead"))
+	app.OnRecordRequestOTPRequest("users").BindFunc(superusersOnly[*core.RecordCreateOTPRequestEvent](
+		"Request a login code through /api/auth/request-otp instead"))
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// recordAPI serves PocketBase's router for an app with the record API guards registered
+func recordAPI(t *testing.T, app core.App) http.Handler {
+	t.Helper()
+
+	RegisterRecordAPIGuards(app)
+	pbRouter, err := apis.NewRouter(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := pbRouter.BuildMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func callRecordAPI(handler http.Handler, method, target, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestRecordAPIGuardsUsers(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "guarded@example.com")
+
+	// Rules loosened from the dashboard don't open the record API up to users
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.CreateRule = types.Pointer("")
+	users.UpdateRule = types.Pointer("id = @request.auth.id")
+	users.DeleteRule = types.Pointer("id = @request.auth.id")
+	if err := app.Save(users); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := recordAPI(t, app)
+	token, err := user.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetToken, err := user.NewPasswordResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailChangeToken, err := user.NewEmailChangeToken("changed@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		name, method, target, token, body string
+	}{
+		{"sign-up", http.MethodPost, "/api/collections/users/records", "",
+			`{"email":"bypass@example.com","password":"short","passwordConfirm":"short"}`},
+		{"password change", http.MethodPatch, "/api/collections/users/records/" + user.Id, token,
+			`{"oldPassword":"` + testPassword + `","password":"short","passwordConfirm":"short"}`},
+		{"deletion", http.MethodDelete, "/api/collections/users/records/" + user.Id, token, ""},
+		{"login", http.MethodPost, "/api/collections/users/auth-with-password", "",
+			`{"identity":"guarded@example.com","password":"` + testPassword + `"}`},
+		// A reset link sent by the app must not set a password past the policy
+		{"password reset", http.MethodPost, "/api/collections/users/confirm-password-reset", "",
+			`{"token":"` + resetToken + `","password":"weakpass","passwordConfirm":"weakpass"}`},
+		{"password reset request", http.MethodPost, "/api/collections/users/request-password-reset", "",
+			`{"email":"guarded@example.com"}`},
+		{"verification request", http.MethodPost, "/api/collections/users/request-verification", "",
+			`{"email":"guarded@example.com"}`},
+		{"email change request", http.MethodPost, "/api/collections/users/request-email-change", token,
+			`{"newEmail":"changed@example.com"}`},
+		{"email change", http.MethodPost, "/api/collections/users/confirm-email-change", "",
+			`{"token":"` + emailChangeToken + `","password":"` + testPassword + `"}`},
+		{"login code request", http.MethodPost, "/api/collections/users/request-otp", "",
+			`{"email":"guarded@example.com"}`},
+	}
+	for _, req := range requests {
+		if w := callRecordAPI(handler, req.method, req.target, req.token, req.body); w.Code != http.StatusForbidden {
+			t.Errorf("%s through the record API: got %d, want 403: %s", req.name, w.Code, w.Body.String())
+		}
+	}
+
+	if total, _ := app.CountRecords("users"); total != 1 {
+		t.Fatalf("expected 1 user, got %d", total)
+	}
+	if stored, err := app.FindRecordById("users", user.Id); err != nil || !stored.ValidatePassword(testPassword) || stored.Email() != "guarded@example.com" {
+		t.Fatal("user was changed through the record API")
+	}
+}
+
+func TestRecordAPIGuardsAllowSuperusers(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "managed@example.com")
+
+	superusers, err := app.FindCollectionByNameOrId(core.CollectionNameSuperusers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	superuser := core.NewRecord(superusers)
+	superuser.SetEmail("admin@example.com")
+	superuser.SetPassword(testPassword)
+	if err := app.Save(superuser); err != nil {
+		t.Fatal(err)
+	}
+	token, err := superuser.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := recordAPI(t, app)
+
+	w := callRecordAPI(handler, http.MethodPatch, "/api/collections/users/records/"+user.Id, token, `{"name":"Managed"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("superuser update: got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = callRecordAPI(handler, http.MethodDelete, "/api/collections/users/records/"+user.Id, token, "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("superuser deletion: got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Avatar thumbnail sizes, for the navbar and the profile page
+const (
+	avatarThumbSmall = "40x40"
+	avatarThumbLarge = "100x100"
+)
+
+// Thumbnails generated for every uploaded avatar
+var avatarThumbs = []string{avatarThumbSmall, avatarThumbLarge}
+
+// Largest avatar accepted
+const avatarMaxSize = 5 << 20
+
+// Longest display name, matching the users collection
+const nameMaxLength = 255
+
+// ProfileData represents the data for the profile page
+type ProfileData struct {
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Avatar  string `json:"avatar,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Success string `json:"success,omitempty"`
+}
+
+// avatarURL returns the URL of a user's avatar thumbnail, or "" when they have none
+func avatarURL(user *core.Record, thumb string) string {
+	filename := user.GetString("avatar")
+	if filename == "" {
+		return ""
+	}
+	return "/avatars/" + user.Id + "/" + filename + "?thumb=" + thumb
+}
+
+// avatarThumbKey returns where a thumbnail of an avatar is stored, using the same
+// layout as PocketBase's file API so both serve the same thumbnails
+func avatarThumbKey(user *core.Record, filename string, thumb string) string {
+	return user.BaseFilesPath() + "/thumbs_" + filename + "/" + thumb + "_" + filename
+}
+
+// createAvatarThumbs generates the thumbnails of a user's avatar
+func createAvatarThumbs(user *core.Record) {
+	filename := user.GetString("avatar")
+	if filename == "" {
+		return
+	}
+
+	fsys, err := PbClient.NewFilesystem()
+	if err != nil {
+		log.Printf("⚠️ Failed to open filesystem: %v", err)
+		return
+	}
+	defer fsys.Close()
+
+	for _, thumb := range avatarThumbs {
+		if err := fsys.CreateThumb(user.BaseFilesPath()+"/"+filename, avatarThumbKey(user, filename, thumb), thumb); err != nil {
+			log.Printf("⚠️ Failed to create %s avatar thumbnail for %s: %v", thumb, user.Id, err)
+		}
+	}
+}
+
+// renderProfile responds with the profile page or its JSON for API clients
+func renderProfile(w http.ResponseWriter, r *http.Request, user *core.Record, data ProfileData) {
+	data.Email = user.Email()
+	data.Name = user.GetString("name")
+	data.Avatar = avatarURL(user, avatarThumbLarge)
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "profile.html", data); err != nil {
+		http.Error(w, "Error rendering profile page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ProfileHandler shows the current user's profile and saves changes to their name and avatar
+func ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderProfile(w, r, user, ProfileData{})
+		return
+	}
+
+	// Oversized avatars are rejected by the file field when saving
+	if err := r.ParseMultipartForm(avatarMaxSize); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		renderProfile(w, r, user, ProfileData{Error: "Failed to parse form"})
+		return
+	}
+
+	// Work on a copy so a failed save doesn't leak into the rest of the request
+	updated := user.Fresh()
+	previousAvatarSize := storedFileSize(user, "avatar")
+
+	if _, ok := r.Form["name"]; ok {
+		name := strings.TrimSpace(r.FormValue("name"))
+		if len([]rune(name)) > nameMaxLength {
+			renderProfile(w, r, user, ProfileData{Error: "Your name is too long"})
+			return
+		}
+		updated.Set("name", name)
+	}
+
+	avatarChanged := false
+	if r.FormValue("removeAvatar") == "true" {
+		updated.Set("avatar", "")
+		avatarChanged = true
+	}
+	if r.MultipartForm != nil && len(r.MultipartForm.File["avatar"]) > 0 {
+		file, err := filesystem.NewFileFromMultipart(r.MultipartForm.File["avatar"][0])
+		if err != nil {
+			renderProfile(w, r, user, ProfileData{Error: "Failed to read the avatar"})
+			return
+		}
+		updated.Set("avatar", file)
+		avatarChanged = true
+	}
+
+	if err := PbClient.Save(updated); err != nil {
+		message := "Failed to save your profile"
+		if strings.Contains(err.Error(), "avatar") {
+			message = "The avatar must be a JPEG, PNG, GIF, WebP or SVG image under 5 MB"
+		}
+		renderProfile(w, r, user, ProfileData{Error: message})
+		return
+	}
+
+	if avatarChanged {
+		createAvatarThumbs(updated)
+		recordUserStorage(updated.Id, storedFileSize(updated, "avatar")-previousAvatarSize)
+	}
+
+	renderProfile(w, r, updated, ProfileData{Success: "Your profile has been updated."})
+}
+
+// AvatarHandler serves a user's avatar or one of its thumbnails, generating
+// missing thumbnails on demand
+func AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	user, err := PbClient.FindRecordById("users", vars["user"])
+	if err != nil || user.GetString("avatar") != vars["filename"] {
+		http.NotFound(w, r)
+		return
+	}
+
+	fsys, err := PbClient.NewFilesystem()
+	if err != nil {
+		http.Error(w, "Failed to open filesystem", http.StatusInternalServerError)
+		return
+	}
+	defer fsys.Close()
+
+	filename := vars["filename"]
+	key := user.BaseFilesPath() + "/" + filename
+	servedName := filename
+
+	if thumb := r.URL.Query().Get("thumb"); slices.Contains(avatarThumbs, thumb) {
+		thumbKey := avatarThumbKey(user, filename, thumb)
+		exists, _ := fsys.Exists(thumbKey)
+		if !exists {
+			exists = fsys.CreateThumb(key, thumbKey, thumb) == nil
+		}
+		// Images that can't be resized, such as SVGs, are served as they are
+		if exists {
+			key = thumbKey
+			servedName = thumb + "_" + filename
+		}
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if err := fsys.Serve(w, r, key, servedName); err != nil {
+		http.NotFound(w, r)
+	}
+}
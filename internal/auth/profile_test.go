@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// postProfile sends a multipart profile update, with an avatar when content isn't nil
+func postProfile(t *testing.T, session string, fields map[string]string, filename string, content []byte) (*httptest.ResponseRecorder, ProfileData) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	if content != nil {
+		part, err := writer.CreateFormFile("avatar", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/profile", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+session)
+	w := httptest.NewRecorder()
+	protected(ProfileHandler).ServeHTTP(w, r)
+
+	var data ProfileData
+	json.Unmarshal(w.Body.Bytes(), &data)
+	return w, data
+}
+
+func TestProfileUpdate(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "profile@example.com")
+	session := signIn(t, user)
+
+	w, data := postProfile(t, session, map[string]string{"name": "  Ada Lovelace "}, "", nil)
+	if w.Code != http.StatusOK || data.Name != "Ada Lovelace" || data.Success == "" {
+		t.Fatalf("name update: got %d %+v", w.Code, data)
+	}
+
+	w, data = postProfile(t, session, map[string]string{"name": strings.Repeat("a", nameMaxLength+1)}, "", nil)
+	if w.Code != http.StatusBadRequest || data.Name != "Ada Lovelace" {
+		t.Fatalf("long name: got %d %+v", w.Code, data)
+	}
+
+	// Files that aren't images are refused without touching the profile
+	w, data = postProfile(t, session, map[string]string{"name": "Changed"}, "avatar.txt", []byte("not an image"))
+	if w.Code != http.StatusBadRequest || !strings.Contains(data.Error, "avatar") {
+		t.Fatalf("text avatar: got %d %+v", w.Code, data)
+	}
+	if stored, _ := app.FindRecordById("users", user.Id); stored.GetString("name") != "Ada Lovelace" || stored.GetString("avatar") != "" {
+		t.Fatal("a rejected update changed the profile")
+	}
+
+	avatar := &bytes.Buffer{}
+	if err := png.Encode(avatar, image.NewRGBA(image.Rect(0, 0, 200, 200))); err != nil {
+		t.Fatal(err)
+	}
+	w, data = postProfile(t, session, nil, "me.png", avatar.Bytes())
+	if w.Code != http.StatusOK || !strings.Contains(data.Avatar, "thumb="+avatarThumbLarge) {
+		t.Fatalf("avatar upload: got %d %+v", w.Code, data)
+	}
+
+	// Thumbnails are generated on upload and served in place of the original
+	stored, err := app.FindRecordById("users", user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := stored.GetString("avatar")
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsys.Close()
+	for _, thumb := range avatarThumbs {
+		if exists, _ := fsys.Exists(avatarThumbKey(stored, filename, thumb)); !exists {
+			t.Fatalf("%s thumbnail wasn't generated on upload", thumb)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/avatars/"+user.Id+"/"+filename+"?thumb="+thumb, nil)
+		r = mux.SetURLVars(r, map[string]string{"user": user.Id, "filename": filename})
+		w := httptest.NewRecorder()
+		AvatarHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s thumbnail: got %d", thumb, w.Code)
+		}
+		config, err := png.DecodeConfig(w.Body)
+		if err != nil || fmt.Sprintf("%dx%d", config.Width, config.Height) != thumb {
+			t.Fatalf("%s thumbnail is %dx%d: %v", thumb, config.Width, config.Height, err)
+		}
+	}
+
+	w, data = postProfile(t, session, map[string]string{"removeAvatar": "true"}, "", nil)
+	if w.Code != http.StatusOK || data.Avatar != "" {
+		t.Fatalf("avatar removal: got %d %+v", w.Code, data)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Plans seeded on first start and editable afterwards, with -1 meaning unlimited.
+// Price ids come from STRIPE_PRICE_<CODE>.
+var defaultPlans = []map[string]any{
+	{
+		"code":        "free",
+		"name":        "Free",
+		"description": "For individuals getting started",
+		"price":       0,
+		"features":    []string{"Up to 3 members", "3 projects", "1,000 API calls / month", "100 MB storage"},
+		"limits":      map[string]int{LimitSeats: 3, LimitProjects: 3, LimitAPICalls: 1000, LimitStorageBytes: 100 << 20},
+		"flags":       []string{},
+	},
+	{
+		"code":        "pro",
+		"name":        "Pro",
+		"description": "For growing teams",
+		"price":       1900,
+		"features":    []string{"Up to 10 members", "20 projects", "50,000 API calls / month", "10 GB storage", "Webhooks"},
+		"limits":      map[string]int{LimitSeats: 10, LimitProjects: 20, LimitAPICalls: 50000, LimitStorageBytes: 10 << 30},
+		"flags":       []string{FeatureWebhooks},
+	},
+	{
+		"code":        "team",
+		"name":        "Team",
+		"description": "For larger organizations",
+		"price":       4900,
+		"features":    []string{"Unlimited members", "Unlimited projects", "500,000 API calls / month", "100 GB storage", "Webhooks", "Audit log", "Priority support"},
+		"limits":      map[string]int{LimitSeats: Unlimited, LimitProjects: Unlimited, LimitAPICalls: 500000, LimitStorageBytes: 100 << 30},
+		"flags":       []string{FeatureWebhooks, FeatureAuditLog, FeaturePrioritySupport},
+	},
+}
+
+// SeedPlans creates the default plans that don't exist yet and fills in limits and
+// feature flags added to them since they were created, keeping edited values. It returns
+// how many plans it created. Deactivated plans are left alone.
+func SeedPlans(app core.App) (int, error) {
+	plans, err := app.FindCollectionByNameOrId("plans")
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, data := range defaultPlans {
+		plan, err := app.FindFirstRecordByData(plans, "code", data["code"])
+		if errors.Is(err, sql.ErrNoRows) {
+			plan = core.NewRecord(plans)
+			plan.Load(data)
+			plan.Set("currency", "usd")
+			plan.Set("interval", "month")
+			plan.Set("active", true)
+			if err := app.Save(plan); err != nil {
+				return created, err
+			}
+			created++
+			continue
+		}
+		if err != nil {
+			return created, err
+		}
+
+		changed := false
+
+		// Plans created before entitlements existed have no feature flags
+		if flags, _ := plan.Get("flags").(types.JSONRaw); len(flags) == 0 || string(flags) == "null" {
+			plan.Set("features", data["features"])
+			plan.Set("flags", data["flags"])
+			changed = true
+		}
+
+		limits := map[string]int{}
+		plan.UnmarshalJSONField("limits", &limits)
+		for name, value := range data["limits"].(map[string]int) {
+			if _, ok := limits[name]; !ok {
+				limits[name] = value
+				changed = true
+			}
+		}
+		plan.Set("limits", limits)
+
+		if changed {
+			if err := app.Save(plan); err != nil {
+				return created, err
+			}
+		}
+	}
+
+	return created, nil
+}
+
+// EnsureSuperuser creates a PocketBase superuser unless one with the email exists and
+// reports whether it did. An existing superuser keeps their password.
+func EnsureSuperuser(app core.App, email string, password string) (bool, error) {
+	if _, err := app.FindAuthRecordByEmail(core.CollectionNameSuperusers, email); err == nil {
+		return false, nil
+	}
+
+	superusers, err := app.FindCollectionByNameOrId(core.CollectionNameSuperusers)
+	if err != nil {
+		return false, err
+	}
+
+	superuser := core.NewRecord(superusers)
+	superuser.SetEmail(email)
+	superuser.SetPassword(password)
+	if err := app.Save(superuser); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,440 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Minimum interval between lastSeen updates of a session
+const sessionTouchInterval = time.Minute
+
+const sessionContextKey contextKey = "session"
+
+// SessionInfo represents an active session of the current user
+type SessionInfo struct {
+	Id        string    `json:"id"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Current   bool      `json:"current"`
+}
+
+// SessionsData represents the data for the sessions page
+type SessionsData struct {
+	Email    string        `json:"email"`
+	Sessions []SessionInfo `json:"sessions"`
+	Error    string        `json:"error,omitempty"`
+	Success  string        `json:"success,omitempty"`
+}
+
+// hashToken returns the hash under which a token is stored in the session registry
+func hashToken(token string) string {
+	return security.SHA256(token)
+}
+
+// clientIP returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// describeDevice returns a short human readable device description from a user agent
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown client"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	case ua != "":
+		browser = strings.SplitN(userAgent, "/", 2)[0]
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		platform = "iOS"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	if platform == "" {
+		return browser
+	}
+	return browser + " on " + platform
+}
+
+// setAuthCookie stores the auth token in the pb_auth cookie
+func setAuthCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_auth",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(24 * time.Hour),
+	})
+}
+
+// clearAuthCookie removes the pb_auth cookie
+func clearAuthCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_auth",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// Claim making every session token unique
+const tokenClaimNonce = "nonce"
+
+// newSessionToken issues an auth token like record.NewAuthToken, with a random nonce so
+// tokens issued to the same user within the same second still differ and each login
+// gets its own session
+func newSessionToken(record *core.Record) (string, error) {
+	return security.NewJWT(
+		jwt.MapClaims{
+			core.TokenClaimType:         core.TokenTypeAuth,
+			core.TokenClaimId:           record.Id,
+			core.TokenClaimCollectionId: record.Collection().Id,
+			core.TokenClaimRefreshable:  true,
+			tokenClaimNonce:             security.RandomString(16),
+		},
+		record.TokenKey()+record.Collection().AuthToken.Secret,
+		record.Collection().AuthToken.DurationTime(),
+	)
+}
+
+// startSession issues a new auth token, records it in the session registry and sets the auth cookie
+func startSession(w http.ResponseWriter, r *http.Request, record *core.Record) (string, error) {
+	token, err := newSessionToken(record)
+	if err != nil {
+		return "", err
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("sessions")
+	if err != nil {
+		return "", err
+	}
+
+	// Drop the user's expired sessions while we're here
+	expired, err := PbClient.FindRecordsByFilter(
+		"sessions",
+		"user = {:user} && expires < {:now}",
+		"",
+		0,
+		0,
+		dbx.Params{"user": record.Id, "now": types.NowDateTime().String()},
+	)
+	if err == nil {
+		for _, session := range expired {
+			PbClient.Delete(session)
+		}
+	}
+
+	session := core.NewRecord(collection)
+	userAgent := r.UserAgent()
+
+	session.Set("user", record.Id)
+	session.Set("tokenHash", hashToken(token))
+	session.Set("device", describeDevice(userAgent))
+	session.Set("ip", clientIP(r))
+	session.Set("userAgent", userAgent)
+	session.Set("lastSeen", time.Now())
+	session.Set("expires", time.Now().Add(record.Collection().AuthToken.DurationTime()))
+
+	if err := PbClient.Save(session); err != nil {
+		return "", err
+	}
+
+	setAuthCookie(w, token)
+
+	return token, nil
+}
+
+// rotateSession replaces the token of an existing session with a freshly issued one
+func rotateSession(w http.ResponseWriter, session *core.Record, record *core.Record) (string, error) {
+	token, err := newSessionToken(record)
+	if err != nil {
+		return "", err
+	}
+
+	session.Set("tokenHash", hashToken(token))
+	session.Set("lastSeen", time.Now())
+	session.Set("expires", time.Now().Add(record.Collection().AuthToken.DurationTime()))
+
+	if err := PbClient.Save(session); err != nil {
+		return "", err
+	}
+
+	setAuthCookie(w, token)
+
+	return token, nil
+}
+
+// authenticateToken resolves the user and session of an auth token.
+// Tokens that are valid but missing from the session registry are treated as revoked.
+func authenticateToken(token string) (*core.Record, *core.Record, error) {
+	record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := PbClient.FindFirstRecordByData("sessions", "tokenHash", hashToken(token))
+	if err != nil {
+		return nil, nil, errors.New("session has been revoked")
+	}
+
+	if session.GetString("user") != record.Id {
+		return nil, nil, errors.New("session does not belong to the token owner")
+	}
+
+	return record, session, nil
+}
+
+// touchSession records activity on a session, throttled to avoid a write per request
+func touchSession(session *core.Record, r *http.Request) {
+	if time.Since(session.GetDateTime("lastSeen").Time()) < sessionTouchInterval {
+		return
+	}
+
+	session.Set("lastSeen", time.Now())
+	session.Set("ip", clientIP(r))
+
+	if err := PbClient.Save(session); err != nil {
+		log.Printf("⚠️ Failed to update session activity: %v", err)
+	}
+}
+
+// revokeToken removes the session of an auth token from the registry
+func revokeToken(token string) error {
+	session, err := PbClient.FindFirstRecordByData("sessions", "tokenHash", hashToken(token))
+	if err != nil {
+		return nil
+	}
+
+	return PbClient.Delete(session)
+}
+
+// revokeUserSessions removes all sessions of a user except the one with exceptSessionId
+func revokeUserSessions(userId string, exceptSessionId string) (int, error) {
+	sessions, err := PbClient.FindAllRecords("sessions", dbx.HashExp{"user": userId})
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.Id == exceptSessionId {
+			continue
+		}
+		if err := PbClient.Delete(session); err != nil {
+			log.Printf("⚠️ Failed to revoke session %s: %v", session.Id, err)
+			continue
+		}
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// getCurrentSession returns the session stored in the request context by AuthMiddleware
+func getCurrentSession(r *http.Request) *core.Record {
+	session, _ := r.Context().Value(sessionContextKey).(*core.Record)
+	return session
+}
+
+// listSessions returns the active sessions of a user, most recently used first
+func listSessions(user *core.Record, currentSessionId string) ([]SessionInfo, error) {
+	records, err := PbClient.FindRecordsByFilter(
+		"sessions",
+		"user = {:user} && expires > {:now}",
+		"-lastSeen",
+		0,
+		0,
+		dbx.Params{"user": user.Id, "now": types.NowDateTime().String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionInfo, 0, len(records))
+	for _, record := range records {
+		sessions = append(sessions, SessionInfo{
+			Id:        record.Id,
+			Device:    record.GetString("device"),
+			IP:        record.GetString("ip"),
+			UserAgent: record.GetString("userAgent"),
+			Created:   record.GetDateTime("created").Time(),
+			LastSeen:  record.GetDateTime("lastSeen").Time(),
+			Current:   record.Id == currentSessionId,
+		})
+	}
+
+	return sessions, nil
+}
+
+// SessionsHandler lists the current user's active sessions
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	currentSessionId := ""
+	if session := getCurrentSession(r); session != nil {
+		currentSessionId = session.Id
+	}
+
+	sessions, err := listSessions(user, currentSessionId)
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load sessions")
+			return
+		}
+		http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+		return
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"sessions": sessions,
+		})
+		return
+	}
+
+	data := SessionsData{
+		Email:    user.Email(),
+		Sessions: sessions,
+	}
+	if r.URL.Query().Get("revoked") == "true" {
+		data.Success = "The session has been revoked."
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "sessions.html", data); err != nil {
+		http.Error(w, "Error rendering sessions page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// RevokeSessionHandler revokes one of the current user's sessions
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+
+	session, err := PbClient.FindRecordById("sessions", id)
+	if err != nil || session.GetString("user") != user.Id {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if err := PbClient.Delete(session); err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditSessionRevoke, Target: "session:" + session.Id})
+
+	// Revoking the current session logs the user out
+	current := getCurrentSession(r)
+	isCurrent := current != nil && current.Id == session.Id
+	if isCurrent {
+		clearAuthCookie(w)
+	}
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if isCurrent {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/sessions?revoked=true", http.StatusSeeOther)
+}
+
+// RevokeAllSessionsHandler revokes every session of the current user ("log out everywhere")
+func RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	revoked, err := revokeUserSessions(user.Id, "")
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to revoke sessions")
+			return
+		}
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditSessionRevokeAll, Target: "user:" + user.Id, Details: map[string]any{"revoked": revoked}})
+
+	clearAuthCookie(w)
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"revoked": revoked,
+		})
+		return
+	}
+
+	http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+}
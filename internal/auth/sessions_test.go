@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestLoginsGetTheirOwnSessions(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "sessions@example.com")
+
+	// Two devices logging in within the same second
+	tokens := make([]string, 2)
+	for i := range tokens {
+		token, err := startSession(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/auth/login", nil), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[i] = token
+	}
+
+	if tokens[0] == tokens[1] {
+		t.Fatal("both logins got the same token")
+	}
+	if total, _ := app.CountRecords("sessions", dbx.HashExp{"user": user.Id}); total != 2 {
+		t.Fatalf("expected 2 sessions, got %d", total)
+	}
+
+	// Signing out on one device keeps the other signed in
+	if err := revokeToken(tokens[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := authenticateToken(tokens[0]); err == nil {
+		t.Fatal("revoked token still authenticates")
+	}
+	if _, session, err := authenticateToken(tokens[1]); err != nil || session.GetString("user") != user.Id {
+		t.Fatalf("other session was revoked too: %v", err)
+	}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// SettingsData represents the data for the account settings page
+type SettingsData struct {
+	Email          string              `json:"email"`
+	NewEmail       string              `json:"newEmail,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	PasswordErrors []PasswordViolation `json:"passwordErrors,omitempty"`
+	Success        string              `json:"success,omitempty"`
+	// Token replaces the caller's auth token after changes that invalidate it
+	Token    string               `json:"token,omitempty"`
+	Exports  []DataExportInfo     `json:"exports"`
+	Deletion *AccountDeletionInfo `json:"deletion,omitempty"`
+	// DeletionBlockers are the organizations to deal with before deleting the account
+	DeletionBlockers []DeletionBlocker `json:"deletionBlockers,omitempty"`
+}
+
+// renderSettings responds with the settings page or its JSON for API clients
+func renderSettings(w http.ResponseWriter, r *http.Request, user *core.Record, data SettingsData) {
+	data.Email = user.Email()
+	data.Deletion = accountDeletionInfo(user.Id)
+
+	if exports, err := listDataExports(user.Id); err == nil {
+		data.Exports = exports
+	}
+	if blockers, err := deletionBlockers(user); err == nil {
+		data.DeletionBlockers = blockers
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "settings.html", data); err != nil {
+		http.Error(w, "Error rendering settings page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkCurrentPassword verifies the password a signed in user re-entered to confirm a
+// change on the settings page. It reports whether the request may continue, having
+// responded otherwise.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *core.Record, password string, data SettingsData) bool {
+	return confirmPassword(w, r, user, password, func(message string) {
+		data.Error = message
+		renderSettings(w, r, user, data)
+	})
+}
+
+// confirmPassword verifies the password a signed in user re-entered to confirm a change,
+// showing failures with render. Failures count towards the account lockout like failed
+// logins. It reports whether the request may continue, having responded otherwise.
+func confirmPassword(w http.ResponseWriter, r *http.Request, user *core.Record, password string, render func(message string)) bool {
+	if wait := loginBlockedFor(r, user.Email()); wait > 0 {
+		if isAPIRequest(r) {
+			writeTooManyAttempts(w, wait)
+			return false
+		}
+		render(lockoutMessage(wait))
+		return false
+	}
+
+	if password == "" || !user.ValidatePassword(password) {
+		recordLoginFailure(r, user.Email(), "settings")
+		render("Your current password is incorrect")
+		return false
+	}
+
+	return true
+}
+
+// SettingsHandler shows the account settings page
+func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	data := SettingsData{}
+	switch r.URL.Query().Get("changed") {
+	case "password":
+		data.Success = "Your password has been changed and your other sessions have been signed out."
+	case "email":
+		data.Success = "Your email address has been changed."
+	}
+	if r.URL.Query().Get("deletion") == "scheduled" {
+		data.Success = "Your account is scheduled for deletion. You can cancel it until then."
+	}
+
+	renderSettings(w, r, user, data)
+}
+
+// ChangePasswordHandler changes the current user's password after checking the current one,
+// then signs out every other session
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "change your password") {
+		return
+	}
+
+	// Process form submission
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	currentPassword := r.FormValue("currentPassword")
+	password := r.FormValue("password")
+	confirmPassword := r.FormValue("confirmPassword")
+
+	// Validate inputs
+	if currentPassword == "" || password == "" {
+		renderSettings(w, r, user, SettingsData{Error: "Current and new password are required"})
+		return
+	}
+
+	// Validate passwords match
+	if password != confirmPassword {
+		renderSettings(w, r, user, SettingsData{Error: "Passwords do not match"})
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, currentPassword, SettingsData{}) {
+		return
+	}
+	clearLoginFailures(user.Email())
+
+	// Enforce the password policy
+	if violations := PasswordRules.Validate(password, user.Email()); len(violations) > 0 {
+		renderSettings(w, r, user, SettingsData{
+			Error:          "Please choose a stronger password",
+			PasswordErrors: violations,
+		})
+		return
+	}
+
+	// Update the password, which invalidates every token issued so far
+	updated := user.Fresh()
+	updated.SetPassword(password)
+	if err := PbClient.Save(updated); err != nil {
+		renderSettings(w, r, user, SettingsData{Error: "Failed to update password: " + err.Error()})
+		return
+	}
+
+	// Drop all sessions and sign this one back in with a fresh token
+	if _, err := revokeUserSessions(updated.Id, ""); err != nil {
+		log.Printf("⚠️ Failed to revoke sessions after password change: %v", err)
+	}
+	token, err := startSession(w, r, updated)
+	if err != nil {
+		log.Printf("⚠️ Failed to start session after password change: %v", err)
+		clearAuthCookie(w)
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditPasswordChange, Actor: updated, Target: "user:" + updated.Id})
+
+	if isAPIRequest(r) {
+		renderSettings(w, r, updated, SettingsData{
+			Success: "Your password has been changed and your other sessions have been signed out.",
+			Token:   token,
+		})
+		return
+	}
+
+	if token == "" {
+		http.Redirect(w, r, "/auth/login?reset_success=true", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/settings?changed=password", http.StatusSeeOther)
+}
+
+// sendEmailChangeEmail emails a confirmation link to the address the user wants to switch to
+func sendEmailChangeEmail(user *core.Record, newEmail string) error {
+	token, err := user.NewEmailChangeToken(newEmail)
+	if err != nil {
+		return err
+	}
+
+	confirmLink := fmt.Sprintf("%s/settings/email/confirm?token=%s", AppURL, url.QueryEscape(token))
+
+	return sendEmail(newEmail, "Confirm your new email address", "email_change.html", EmailData{
+		Link: confirmLink,
+	})
+}
+
+// RequestEmailChangeHandler sends a confirmation link to the new email address.
+// The address only changes once the link is opened.
+func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		unauthorized(w, r, false)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "change your email address") {
+		return
+	}
+
+	// Process form submission
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	newEmail := strings.TrimSpace(r.FormValue("newEmail"))
+	data := SettingsData{NewEmail: newEmail}
+
+	// Validate inputs
+	if address, err := mail.ParseAddress(newEmail); err != nil || address.Address != newEmail {
+		data.Error = "Enter a valid email address"
+		renderSettings(w, r, user, data)
+		return
+	}
+	if strings.EqualFold(newEmail, user.Email()) {
+		data.Error = "That is already your email address"
+		renderSettings(w, r, user, data)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, r.FormValue("currentPassword"), data) {
+		return
+	}
+	clearLoginFailures(user.Email())
+
+	// Check if email already exists
+	if existing, _ := PbClient.FindAuthRecordByEmail("users", newEmail); existing != nil {
+		data.Error = "An account with this email already exists"
+		renderSettings(w, r, user, data)
+		return
+	}
+
+	if err := sendEmailChangeEmail(user, newEmail); err != nil {
+		log.Printf("⚠️ Failed to send email change confirmation: %v", err)
+		data.Error = "Failed to send the confirmation email. Please try again later."
+		renderSettings(w, r, user, data)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:  AuditEmailChangeSend,
+		Target:  "user:" + user.Id,
+		Details: map[string]any{"newEmail": newEmail},
+	})
+
+	renderSettings(w, r, user, SettingsData{
+		Success: "We sent a confirmation link to " + newEmail + ". Your email address changes once you open it.",
+	})
+}
+
+// ConfirmEmailChangeHandler switches the user to the email address confirmed by a signed
+// email change token. The change signs out every session.
+func ConfirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	// Resolve the user from the signed email change token
+	token := r.URL.Query().Get("token")
+	record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeEmailChange)
+	if err != nil {
+		http.Error(w, "Invalid or expired email change link. Please request a new one from your settings.", http.StatusBadRequest)
+		return
+	}
+
+	claims, _ := security.ParseUnverifiedJWT(token)
+	newEmail, _ := claims[core.TokenClaimNewEmail].(string)
+	if newEmail == "" {
+		http.Error(w, "Invalid email change link", http.StatusBadRequest)
+		return
+	}
+
+	// The address may have been taken since the link was sent
+	if existing, _ := PbClient.FindAuthRecordByEmail("users", newEmail); existing != nil {
+		http.Error(w, "An account with this email already exists", http.StatusConflict)
+		return
+	}
+
+	oldEmail := record.Email()
+	current := GetCurrentUser(r)
+
+	// Opening the link proves the new address belongs to the user
+	record.SetEmail(newEmail)
+	record.SetVerified(true)
+	if err := PbClient.Save(record); err != nil {
+		http.Error(w, "Failed to change email: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The email change invalidates all existing tokens, so drop their sessions too
+	if _, err := revokeUserSessions(record.Id, ""); err != nil {
+		log.Printf("⚠️ Failed to revoke sessions after email change: %v", err)
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:  AuditEmailChange,
+		Actor:   record,
+		Target:  "user:" + record.Id,
+		Details: map[string]any{"oldEmail": oldEmail, "newEmail": newEmail},
+	})
+
+	// Keep the user signed in when they open the link in the browser they're using
+	if current != nil && current.Id == record.Id {
+		if _, err := startSession(w, r, record); err == nil {
+			http.Redirect(w, r, "/settings?changed=email", http.StatusSeeOther)
+			return
+		}
+	}
+
+	clearAuthCookie(w)
+	http.Redirect(w, r, "/auth/login?email_changed=true", http.StatusSeeOther)
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "password@example.com")
+	current := signIn(t, user)
+	other := signIn(t, user)
+
+	change := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/settings/password", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Authorization", "Bearer "+current)
+		w := httptest.NewRecorder()
+		protected(ChangePasswordHandler, SessionOnlyMiddleware).ServeHTTP(w, r)
+		return w
+	}
+
+	newPassword := "An0ther-Horse-Battery!"
+	if w := change(url.Values{"currentPassword": {"wrong"}, "password": {newPassword}, "confirmPassword": {newPassword}}); w.Code == http.StatusOK {
+		t.Fatal("password changed without the current password")
+	}
+	if _, _, err := authenticateToken(other); err != nil {
+		t.Fatal("a refused change signed out the other session")
+	}
+	// The wrong guess counts as a failed login, which slows down the next attempt
+	clearLoginFailures(user.Email())
+
+	w := change(url.Values{"currentPassword": {testPassword}, "password": {newPassword}, "confirmPassword": {newPassword}})
+	var data SettingsData
+	if err := json.NewDecoder(w.Body).Decode(&data); err != nil || w.Code != http.StatusOK || data.Token == "" {
+		t.Fatalf("password change: got %d %+v", w.Code, data)
+	}
+
+	// Every earlier token stops working, the caller carries on with the new one
+	for name, token := range map[string]string{"current": current, "other": other} {
+		if _, _, err := authenticateToken(token); err == nil {
+			t.Errorf("the %s session survived the password change", name)
+		}
+	}
+	if _, _, err := authenticateToken(data.Token); err != nil {
+		t.Fatalf("new token: %v", err)
+	}
+	if total, _ := app.CountRecords("sessions", dbx.HashExp{"user": user.Id}); total != 1 {
+		t.Fatalf("expected only the new session to be listed, got %d", total)
+	}
+	if stored, _ := app.FindRecordById("users", user.Id); !stored.ValidatePassword(newPassword) {
+		t.Fatal("password wasn't changed")
+	}
+}
+
+func TestConfirmEmailChangeRevokesSessions(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "old@example.com")
+
+	confirm := func(session string) *httptest.ResponseRecorder {
+		t.Helper()
+		stored, err := app.FindRecordById("users", user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := stored.NewEmailChangeToken("new-" + stored.Email())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "/settings/email/confirm?token="+url.QueryEscape(token), nil)
+		if session != "" {
+			r.AddCookie(&http.Cookie{Name: "pb_auth", Value: session})
+		}
+		w := httptest.NewRecorder()
+		ConfirmEmailChangeHandler(w, r)
+		return w
+	}
+
+	// Opened in another browser, the link signs out everywhere
+	sessions := []string{signIn(t, user), signIn(t, user)}
+	w := confirm("")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/auth/login?email_changed=true" {
+		t.Fatalf("confirmation without a session: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	if stored, _ := app.FindRecordById("users", user.Id); stored.Email() != "new-old@example.com" || !stored.Verified() {
+		t.Fatalf("email wasn't changed: %s", stored.Email())
+	}
+	for i, session := range sessions {
+		if _, _, err := authenticateToken(session); err == nil {
+			t.Errorf("session %d survived the email change", i)
+		}
+	}
+	if total, _ := app.CountRecords("sessions", dbx.HashExp{"user": user.Id}); total != 0 {
+		t.Fatalf("expected no sessions to be listed, got %d", total)
+	}
+
+	// Opened where the user is signed in, only that browser gets a new session
+	stored, _ := app.FindRecordById("users", user.Id)
+	current, other := signIn(t, stored), signIn(t, stored)
+	w = confirm(current)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/settings?changed=email" {
+		t.Fatalf("confirmation with a session: got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	for name, token := range map[string]string{"current": current, "other": other} {
+		if _, _, err := authenticateToken(token); err == nil {
+			t.Errorf("the %s session survived the email change", name)
+		}
+	}
+	renewed := ""
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "pb_auth" {
+			renewed = cookie.Value
+		}
+	}
+	if _, _, err := authenticateToken(renewed); err != nil {
+		t.Fatalf("no new session after the email change: %v", err)
+	}
+}
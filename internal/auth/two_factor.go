@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Token type of the short-lived challenge issued between the password and the second factor
+const mfaChallengeType = "mfaChallenge"
+
+// How long a user has to enter their second factor after a valid password
+const mfaChallengeDuration = 5 * time.Minute
+
+// TwoFactorData represents the data for the two-factor settings page
+type TwoFactorData struct {
+	Email         string   `json:"email"`
+	Enabled       bool     `json:"enabled"`
+	Secret        string   `json:"secret,omitempty"`
+	OTPAuthURI    string   `json:"otpauthUri,omitempty"`
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	Success       string   `json:"success,omitempty"`
+}
+
+// TwoFactorLoginForm represents the second login step form data
+type TwoFactorLoginForm struct {
+	Error string `json:"error,omitempty"`
+}
+
+// findTOTPFactor returns the TOTP factor of a user (enabled or pending setup)
+func findTOTPFactor(userId string) (*core.Record, error) {
+	return PbClient.FindFirstRecordByData("totp_factors", "user", userId)
+}
+
+// hasTwoFactorEnabled reports whether the user must pass a second factor to log in
+func hasTwoFactorEnabled(user *core.Record) bool {
+	factor, err := findTOTPFactor(user.Id)
+	return err == nil && factor.GetBool("enabled")
+}
+
+// setRecoveryCodes stores the hashes of freshly generated recovery codes and returns the plain codes
+func setRecoveryCodes(factor *core.Record) []string {
+	codes := generateRecoveryCodes()
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = security.SHA256(normalizeRecoveryCode(code))
+	}
+	factor.Set("recoveryCodes", hashes)
+
+	return codes
+}
+
+// verifySecondFactor checks a TOTP or recovery code and persists its consumption
+func verifySecondFactor(factor *core.Record, code string) bool {
+	// Try the authenticator app code first
+	if step, ok := validateTOTP(factor.GetString("secret"), code, int64(factor.GetInt("lastUsedStep")), time.Now()); ok {
+		factor.Set("lastUsedStep", step)
+		if err := PbClient.Save(factor); err != nil {
+			log.Printf("⚠️ Failed to save TOTP factor: %v", err)
+			return false
+		}
+		return true
+	}
+
+	// Fall back to a single-use recovery code
+	var hashes []string
+	if err := factor.UnmarshalJSONField("recoveryCodes", &hashes); err != nil {
+		return false
+	}
+
+	hash := security.SHA256(normalizeRecoveryCode(code))
+	for i, stored := range hashes {
+		if !security.Equal(stored, hash) {
+			continue
+		}
+
+		factor.Set("recoveryCodes", append(hashes[:i:i], hashes[i+1:]...))
+		if err := PbClient.Save(factor); err != nil {
+			log.Printf("⚠️ Failed to save TOTP factor: %v", err)
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+// newMFAChallenge issues a short-lived token proving the user passed the password step
+func newMFAChallenge(record *core.Record) (string, error) {
+	return security.NewJWT(
+		jwt.MapClaims{
+			core.TokenClaimType:         mfaChallengeType,
+			core.TokenClaimId:           record.Id,
+			core.TokenClaimCollectionId: record.Collection().Id,
+		},
+		record.TokenKey()+record.Collection().AuthToken.Secret,
+		mfaChallengeDuration,
+	)
+}
+
+// verifyMFAChallenge resolves the user of a challenge token
+func verifyMFAChallenge(token string) (*core.Record, error) {
+	claims, err := security.ParseUnverifiedJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenType, _ := claims[core.TokenClaimType].(string); tokenType != mfaChallengeType {
+		return nil, errors.New("invalid challenge token type")
+	}
+
+	id, _ := claims[core.TokenClaimId].(string)
+	record, err := PbClient.FindRecordById("users", id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := security.ParseJWT(token, record.TokenKey()+record.Collection().AuthToken.Secret); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// setMFACookie stores the challenge token between the two login steps
+func setMFACookie(w http.ResponseWriter, challenge string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_mfa",
+		Value:    challenge,
+		Path:     "/auth/login/2fa",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(mfaChallengeDuration.Seconds()),
+	})
+}
+
+// clearMFACookie removes the challenge cookie
+func clearMFACookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "pb_mfa",
+		Value:    "",
+		Path:     "/auth/login/2fa",
+		HttpOnly: true,
+		Secure:   SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// TwoFactorLoginHandler handles the second login step for users with two-factor authentication
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// Without a pending challenge the user has to start over
+	cookie, err := r.Cookie("pb_mfa")
+	if err != nil || cookie.Value == "" || PbClient == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	record, err := verifyMFAChallenge(cookie.Value)
+	if err != nil {
+		clearMFACookie(w)
+		renderTemplate(w, r, "login.html", LoginForm{
+			Error: "Your login attempt has expired. Please log in again.",
+		})
+		return
+	}
+
+	if r.Method == "GET" {
+		renderTemplate(w, r, "login_2fa.html", nil)
+		return
+	}
+
+	// Process form submission
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		renderTemplate(w, r, "login_2fa.html", TwoFactorLoginForm{
+			Error: "Authentication code is required",
+		})
+		return
+	}
+
+	// Refuse attempts while the client or account is throttled
+	if wait := loginBlockedFor(r, record.Email()); wait > 0 {
+		renderTemplate(w, r, "login_2fa.html", TwoFactorLoginForm{
+			Error: lockoutMessage(wait),
+		})
+		return
+	}
+
+	factor, err := findTOTPFactor(record.Id)
+	if err != nil || !factor.GetBool("enabled") || !verifySecondFactor(factor, code) {
+		recordLoginFailure(r, record.Email(), "2fa")
+		renderTemplate(w, r, "login_2fa.html", TwoFactorLoginForm{
+			Error: "Invalid authentication code",
+		})
+		return
+	}
+
+	clearLoginFailures(record.Email())
+
+	// Start a new session and set the auth cookie
+	if _, err := startSession(w, r, record); err != nil {
+		renderTemplate(w, r, "login_2fa.html", TwoFactorLoginForm{
+			Error: "Failed to create authentication token",
+		})
+		return
+	}
+
+	auditLogin(r, record, record.Email(), "2fa", AuditSuccess)
+
+	clearMFACookie(w)
+
+	// Redirect to home page
+	http.Redirect(w, r, loginRedirectURL(r), http.StatusSeeOther)
+}
+
+// renderTwoFactor renders the two-factor settings page
+func renderTwoFactor(w http.ResponseWriter, r *http.Request, data TwoFactorData) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "two_factor.html", data); err != nil {
+		http.Error(w, "Error rendering two-factor page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// TwoFactorSettingsHandler shows the two-factor authentication status of the current user
+func TwoFactorSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	renderTwoFactor(w, r, TwoFactorData{
+		Email:   user.Email(),
+		Enabled: hasTwoFactorEnabled(user),
+	})
+}
+
+// TwoFactorSetupHandler generates a new secret and shows the enrollment QR code
+func TwoFactorSetupHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	data := TwoFactorData{Email: user.Email()}
+
+	factor, err := findTOTPFactor(user.Id)
+	if err == nil && factor.GetBool("enabled") {
+		data.Enabled = true
+		data.Error = "Two-factor authentication is already enabled"
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	// Start a fresh pending enrollment
+	if err != nil {
+		collection, err := PbClient.FindCollectionByNameOrId("totp_factors")
+		if err != nil {
+			data.Error = "Two-factor authentication is not configured correctly"
+			renderTwoFactor(w, r, data)
+			return
+		}
+		factor = core.NewRecord(collection)
+		factor.Set("user", user.Id)
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		data.Error = "Failed to generate secret"
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	factor.Set("secret", secret)
+	factor.Set("enabled", false)
+	factor.Set("lastUsedStep", 0)
+	factor.Set("recoveryCodes", []string{})
+
+	if err := PbClient.Save(factor); err != nil {
+		data.Error = "Failed to start two-factor setup: " + err.Error()
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	data.Secret = secret
+	data.OTPAuthURI = totpURI(PbClient.Settings().Meta.AppName, user.Email(), secret)
+	renderTwoFactor(w, r, data)
+}
+
+// TwoFactorEnableHandler confirms the enrollment with a code from the authenticator app
+func TwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	data := TwoFactorData{Email: user.Email()}
+
+	factor, err := findTOTPFactor(user.Id)
+	if err != nil || factor.GetBool("enabled") {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	secret := factor.GetString("secret")
+
+	step, ok := validateTOTP(secret, r.FormValue("code"), 0, time.Now())
+	if !ok {
+		data.Secret = secret
+		data.OTPAuthURI = totpURI(PbClient.Settings().Meta.AppName, user.Email(), secret)
+		data.Error = "Invalid authentication code. Make sure your device's clock is correct and try again."
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	factor.Set("enabled", true)
+	factor.Set("lastUsedStep", step)
+	codes := setRecoveryCodes(factor)
+
+	if err := PbClient.Save(factor); err != nil {
+		data.Error = "Failed to enable two-factor authentication: " + err.Error()
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditTwoFactorEnable, Target: "user:" + user.Id})
+
+	data.Enabled = true
+	data.RecoveryCodes = codes
+	data.Success = "Two-factor authentication is now enabled."
+	renderTwoFactor(w, r, data)
+}
+
+// TwoFactorDisableHandler turns off two-factor authentication after confirming the
+// password and a current code, so a hijacked session alone can't remove the second factor
+func TwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "disable two-factor authentication") {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	data := TwoFactorData{Email: user.Email(), Enabled: true}
+
+	factor, err := findTOTPFactor(user.Id)
+	if err != nil || !factor.GetBool("enabled") {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	rejected := func(message string) {
+		RecordAuditEvent(r, AuditEvent{Action: AuditTwoFactorDisable, Outcome: AuditFailure, Target: "user:" + user.Id})
+		data.Error = message
+		renderTwoFactor(w, r, data)
+	}
+
+	if !confirmPassword(w, r, user, r.FormValue("password"), rejected) {
+		return
+	}
+	if !verifySecondFactor(factor, r.FormValue("code")) {
+		rejected("Invalid authentication code")
+		return
+	}
+
+	if err := PbClient.Delete(factor); err != nil {
+		data.Error = "Failed to disable two-factor authentication: " + err.Error()
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditTwoFactorDisable, Target: "user:" + user.Id})
+
+	data.Enabled = false
+	data.Success = "Two-factor authentication has been disabled."
+	renderTwoFactor(w, r, data)
+}
+
+// TwoFactorRecoveryCodesHandler replaces the recovery codes after confirming a current code
+func TwoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := GetCurrentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/auth/login", http.StatusSeeOther)
+		return
+	}
+	if rejectAPITokenAuth(w, r, "regenerate recovery codes") {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	data := TwoFactorData{Email: user.Email(), Enabled: true}
+
+	factor, err := findTOTPFactor(user.Id)
+	if err != nil || !factor.GetBool("enabled") {
+		http.Redirect(w, r, "/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	if !verifySecondFactor(factor, r.FormValue("code")) {
+		data.Error = "Invalid authentication code"
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	codes := setRecoveryCodes(factor)
+	if err := PbClient.Save(factor); err != nil {
+		data.Error = "Failed to regenerate recovery codes: " + err.Error()
+		renderTwoFactor(w, r, data)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{Action: AuditRecoveryCodes, Target: "user:" + user.Id})
+
+	data.RecoveryCodes = codes
+	data.Success = "New recovery codes have been generated. Your old codes no longer work."
+	renderTwoFactor(w, r, data)
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// enableTestTwoFactor enrolls a user in two-factor authentication and returns the
+// TOTP secret and recovery codes
+func enableTestTwoFactor(t *testing.T, user *core.Record) (string, []string) {
+	t.Helper()
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := PbClient.FindCollectionByNameOrId("totp_factors")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	factor := core.NewRecord(collection)
+	factor.Set("user", user.Id)
+	factor.Set("secret", secret)
+	factor.Set("enabled", true)
+	codes := setRecoveryCodes(factor)
+	if err := PbClient.Save(factor); err != nil {
+		t.Fatal(err)
+	}
+
+	return secret, codes
+}
+
+func TestTwoFactorDisableRequiresSecondFactor(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "2fa@example.com")
+	secret, _ := enableTestTwoFactor(t, user)
+	session := signIn(t, user)
+	disable := protected(TwoFactorDisableHandler)
+
+	// A hijacked session knowing the password still can't remove the second factor
+	form := url.Values{"password": {testPassword}, "code": {"000000"}}
+	callWithToken(disable, http.MethodPost, "/settings/2fa/disable", session, form.Encode())
+	if !hasTwoFactorEnabled(user) {
+		t.Fatal("two-factor authentication was disabled without a valid code")
+	}
+
+	code, err := totpCode(secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{"password": {testPassword}, "code": {code}}
+	callWithToken(disable, http.MethodPost, "/settings/2fa/disable", session, form.Encode())
+	if hasTwoFactorEnabled(user) {
+		t.Fatal("two-factor authentication wasn't disabled with the password and a valid code")
+	}
+	if factors, _ := app.CountRecords("totp_factors", dbx.HashExp{"user": user.Id}); factors != 0 {
+		t.Fatalf("%d factors left after disabling", factors)
+	}
+}
+
+func TestTwoFactorDisableCountsWrongPasswords(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "2fa-guess@example.com")
+	_, recoveryCodes := enableTestTwoFactor(t, user)
+	session := signIn(t, user)
+	disable := protected(TwoFactorDisableHandler)
+
+	form := url.Values{"password": {"wrong-password-1"}, "code": {recoveryCodes[0]}}
+	callWithToken(disable, http.MethodPost, "/settings/2fa/disable", session, form.Encode())
+
+	attempt, err := app.FindFirstRecordByData("login_attempts", "key", accountAttemptKey(user.Email()))
+	if err != nil || attempt.GetInt("failures") != 1 {
+		t.Fatal("wrong password on the 2FA page wasn't counted against the account")
+	}
+
+	// The account lockout's backoff applies before the password is checked again
+	form = url.Values{"password": {testPassword}, "code": {recoveryCodes[0]}}
+	w := callWithToken(disable, http.MethodPost, "/settings/2fa/disable", session, form.Encode())
+	if w.Code != http.StatusTooManyRequests || !hasTwoFactorEnabled(user) {
+		t.Fatalf("throttled account disabled two-factor authentication: %d", w.Code)
+	}
+}
@@ -0,0 +1,552 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// How often buffered usage is written to the usage_counters collection
+const usageFlushInterval = 5 * time.Second
+
+// Share of a limit at which usage is reported as approaching the quota
+const quotaWarningRatio = 0.8
+
+// Period of counters that aren't reset each month, such as storage and seats
+const lifetimePeriod = "total"
+
+// QuotaMode decides what happens to requests once a quota is used up
+type QuotaMode int
+
+const (
+	// SoftQuota lets requests through and flags them with an X-Quota-Warning header
+	SoftQuota QuotaMode = iota
+	// HardQuota rejects requests with a 429 until the next period or an upgrade
+	HardQuota
+)
+
+// Metrics counted per calendar month (UTC); every other metric is a running total
+var monthlyMetrics = map[string]bool{
+	LimitAPICalls: true,
+}
+
+// Sources used to initialize running totals that have no stored counter yet
+var usageSources = map[string]func(organizationId string) (int, error){
+	LimitSeats: countSeats,
+}
+
+// UsageInfo represents the usage of one metric on the dashboard
+type UsageInfo struct {
+	Metric   string `json:"metric"`
+	Label    string `json:"label"`
+	Quota    string `json:"-"`
+	Used     int64  `json:"used"`
+	Limit    int    `json:"limit"`
+	Period   string `json:"period"`
+	Display  string `json:"-"`
+	Percent  int    `json:"percent"`
+	Warning  bool   `json:"warning"`
+	Exceeded bool   `json:"exceeded"`
+}
+
+type usageKey struct {
+	organization string
+	metric       string
+	period       string
+}
+
+// usageMeter aggregates usage in memory and writes it in batches, so recording usage
+// on every request doesn't turn into one SQLite write per request
+type usageMeter struct {
+	mu      sync.Mutex
+	pending map[usageKey]int64
+	stored  map[usageKey]int64
+
+	// Held for writing while a flush is in progress so stored values aren't loaded twice
+	flushMu sync.RWMutex
+}
+
+var usage = &usageMeter{
+	pending: map[usageKey]int64{},
+	stored:  map[usageKey]int64{},
+}
+
+// usagePeriod returns the counter period a metric is recorded in at the given time
+func usagePeriod(metric string, at time.Time) string {
+	if monthlyMetrics[metric] {
+		return at.UTC().Format("2006-01")
+	}
+	return lifetimePeriod
+}
+
+// nextPeriodStart returns when the current monthly period ends
+func nextPeriodStart(at time.Time) time.Time {
+	at = at.UTC()
+	return time.Date(at.Year(), at.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// add buffers a change to a counter
+func (m *usageMeter) add(key usageKey, delta int64) {
+	m.mu.Lock()
+	m.pending[key] += delta
+	m.mu.Unlock()
+}
+
+// load returns the stored value of a counter, reading it from the database the first time.
+// Callers must hold flushMu for reading.
+func (m *usageMeter) load(key usageKey) (int64, error) {
+	m.mu.Lock()
+	value, ok := m.stored[key]
+	m.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	counter, err := PbClient.FindFirstRecordByFilter(
+		"usage_counters",
+		"organization = {:organization} && metric = {:metric} && period = {:period}",
+		dbx.Params{"organization": key.organization, "metric": key.metric, "period": key.period},
+	)
+	initial := int64(0)
+	if err == nil {
+		value = int64(counter.GetInt("value"))
+	} else if source, ok := usageSources[key.metric]; ok {
+		count, err := source(key.organization)
+		if err != nil {
+			return 0, err
+		}
+		initial = int64(count)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Another request may have loaded the counter in the meantime
+	if stored, ok := m.stored[key]; ok {
+		return stored, nil
+	}
+	m.stored[key] = value
+	m.pending[key] += initial
+
+	return value, nil
+}
+
+// value returns the current value of a counter, including buffered changes
+func (m *usageMeter) value(key usageKey) (int64, error) {
+	m.flushMu.RLock()
+	defer m.flushMu.RUnlock()
+
+	stored, err := m.load(key)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return stored + m.pending[key], nil
+}
+
+// set changes a counter to an absolute value
+func (m *usageMeter) set(key usageKey, value int64) error {
+	m.flushMu.RLock()
+	defer m.flushMu.RUnlock()
+
+	stored, err := m.load(key)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending[key] = value - stored
+	return nil
+}
+
+// flush writes the buffered changes in a single transaction
+func (m *usageMeter) flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	batch := m.pending
+	m.pending = map[usageKey]int64{}
+	m.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	written := map[usageKey]int64{}
+	err := PbClient.RunInTransaction(func(txApp core.App) error {
+		counters, err := txApp.FindCollectionByNameOrId("usage_counters")
+		if err != nil {
+			return err
+		}
+
+		for key, delta := range batch {
+			if delta == 0 {
+				continue
+			}
+
+			counter, err := txApp.FindFirstRecordByFilter(
+				counters,
+				"organization = {:organization} && metric = {:metric} && period = {:period}",
+				dbx.Params{"organization": key.organization, "metric": key.metric, "period": key.period},
+			)
+			if err != nil {
+				counter = core.NewRecord(counters)
+				counter.Set("organization", key.organization)
+				counter.Set("metric", key.metric)
+				counter.Set("period", key.period)
+			}
+			counter.Set("value", counter.GetInt("value")+int(delta))
+
+			// Usage of an organization deleted in the meantime is dropped
+			if err := txApp.Save(counter); err != nil {
+				log.Printf("⚠️ Dropping %s usage of organization %s: %v", key.metric, key.organization, err)
+				continue
+			}
+			written[key] = delta
+		}
+
+		return nil
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		// Keep the changes for the next attempt
+		for key, delta := range batch {
+			m.pending[key] += delta
+		}
+		return err
+	}
+
+	currentMonth := time.Now().UTC().Format("2006-01")
+	for key, value := range m.stored {
+		if key.period != lifetimePeriod && key.period != currentMonth {
+			delete(m.stored, key)
+			continue
+		}
+		m.stored[key] = value + written[key]
+	}
+
+	return nil
+}
+
+// RecordUsage adds to an organization's usage of a metric in the current period,
+// e.g. auth.RecordUsage(org.Id, auth.LimitStorageBytes, size) after an upload.
+// Negative amounts reduce running totals such as storage.
+func RecordUsage(organizationId string, metric string, amount int64) {
+	usage.add(usageKey{organizationId, metric, usagePeriod(metric, time.Now())}, amount)
+}
+
+// SetUsage sets a running total such as seats to an absolute value
+func SetUsage(organizationId string, metric string, value int64) error {
+	return usage.set(usageKey{organizationId, metric, usagePeriod(metric, time.Now())}, value)
+}
+
+// CurrentUsage returns an organization's usage of a metric in the current period
+func CurrentUsage(organizationId string, metric string) (int64, error) {
+	return usage.value(usageKey{organizationId, metric, usagePeriod(metric, time.Now())})
+}
+
+// FlushUsage writes buffered usage to the database
+func FlushUsage() error {
+	return usage.flush()
+}
+
+// StartUsageMeter periodically writes buffered usage until the context is canceled.
+// Call FlushUsage on shutdown to write what's left.
+func StartUsageMeter(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(usageFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := usage.flush(); err != nil {
+					log.Printf("⚠️ Failed to write usage: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// userStorageOrganization returns the organization a user's own files such as avatars
+// and data exports count against: the oldest organization they own, which is their
+// personal workspace. It returns "" when they don't own one.
+func userStorageOrganization(userId string) string {
+	memberships, err := PbClient.FindRecordsByFilter(
+		"memberships",
+		"user = {:user} && role = {:role}",
+		"created",
+		1,
+		0,
+		dbx.Params{"user": userId, "role": RoleOwner},
+	)
+	if err != nil || len(memberships) == 0 {
+		return ""
+	}
+
+	return memberships[0].GetString("organization")
+}
+
+// storedFileSize returns the size of a file stored in a record's file field, or 0 when
+// the field is empty or the file is missing
+func storedFileSize(record *core.Record, field string) int64 {
+	filename := record.GetString(field)
+	if filename == "" {
+		return 0
+	}
+
+	fsys, err := PbClient.NewFilesystem()
+	if err != nil {
+		return 0
+	}
+	defer fsys.Close()
+
+	attributes, err := fsys.Attributes(record.BaseFilesPath() + "/" + filename)
+	if err != nil {
+		return 0
+	}
+
+	return attributes.Size
+}
+
+// recordUserStorage adds to the storage usage of a user's personal workspace
+func recordUserStorage(userId string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if organizationId := userStorageOrganization(userId); organizationId != "" {
+		RecordUsage(organizationId, LimitStorageBytes, delta)
+	}
+}
+
+// RegisterUsageHooks keeps the seats counter in sync with memberships and invitations
+func RegisterUsageHooks(app core.App) {
+	refreshSeats := func(e *core.RecordEvent) error {
+		organizationId := e.Record.GetString("organization")
+		if seats, err := countSeats(organizationId); err == nil {
+			if err := SetUsage(organizationId, LimitSeats, int64(seats)); err != nil {
+				log.Printf("⚠️ Failed to update seat usage: %v", err)
+			}
+		}
+		return e.Next()
+	}
+
+	app.OnRecordAfterCreateSuccess("memberships", "invitations").BindFunc(refreshSeats)
+	app.OnRecordAfterDeleteSuccess("memberships", "invitations").BindFunc(refreshSeats)
+}
+
+// formatCount formats a number with thousands separators
+func formatCount(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// formatBytes formats a byte count using binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// usageMetrics lists the metrics shown on the dashboard, with their label and quota name
+var usageMetrics = []struct {
+	metric string
+	label  string
+	quota  string
+}{
+	{LimitAPICalls, "API calls this month", "monthly API call"},
+	{LimitStorageBytes, "Storage", "storage"},
+	{LimitSeats, "Seats", "seat"},
+}
+
+// usageInfo describes the usage of a metric against the plan's limit
+func usageInfo(metric string, used int64, limit int) UsageInfo {
+	info := UsageInfo{
+		Metric: metric,
+		Label:  metric,
+		Quota:  metric,
+		Used:   used,
+		Limit:  limit,
+		Period: usagePeriod(metric, time.Now()),
+	}
+	for _, known := range usageMetrics {
+		if known.metric == metric {
+			info.Label = known.label
+			info.Quota = known.quota
+		}
+	}
+
+	format := formatCount
+	if metric == LimitStorageBytes {
+		format = formatBytes
+	}
+
+	if limit == Unlimited {
+		info.Display = format(used) + " / unlimited"
+		return info
+	}
+
+	info.Display = format(used) + " / " + format(int64(limit))
+	if limit > 0 {
+		info.Percent = int(min(used*100/int64(limit), 100))
+	}
+	info.Exceeded = used >= int64(limit)
+	info.Warning = !info.Exceeded && float64(used) >= float64(limit)*quotaWarningRatio
+
+	return info
+}
+
+// organizationUsage returns the usage of every dashboard metric
+func organizationUsage(organizationId string, entitlements PlanEntitlements) []UsageInfo {
+	usages := make([]UsageInfo, 0, len(usageMetrics))
+	for _, metric := range usageMetrics {
+		used, err := CurrentUsage(organizationId, metric.metric)
+		if err != nil {
+			log.Printf("⚠️ Failed to load %s usage: %v", metric.metric, err)
+			continue
+		}
+		usages = append(usages, usageInfo(metric.metric, used, entitlements.Limit(metric.metric)))
+	}
+	return usages
+}
+
+// quotaExceeded rejects a request over a hard quota with a 429
+func quotaExceeded(w http.ResponseWriter, r *http.Request, info UsageInfo) {
+	message := fmt.Sprintf("Your organization has used its %s quota (%s). Upgrade your plan for a higher quota.", info.Quota, info.Display)
+
+	if info.Period != lifetimePeriod {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(nextPeriodStart(time.Now())).Seconds())+1))
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":      message,
+			"metric":     info.Metric,
+			"used":       info.Used,
+			"limit":      info.Limit,
+			"upgradeUrl": AppURL + "/billing",
+		})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if err := renderTemplate(w, r, "upgrade.html", UpgradeData{Message: message}); err != nil {
+		http.Error(w, message, http.StatusTooManyRequests)
+	}
+}
+
+// EnforceQuota returns middleware that checks the active organization's usage of a metric
+// against its plan. Once the quota is used up a hard quota rejects requests with a 429,
+// while a soft quota only adds an X-Quota-Warning header. Usage past 80% of the quota is
+// flagged as "approaching". It must run after AuthMiddleware and OrganizationMiddleware.
+func EnforceQuota(metric string, mode QuotaMode) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			organization := GetCurrentOrganization(r)
+			if organization == nil {
+				unauthorized(w, r, false)
+				return
+			}
+
+			entitlements := Entitlements(r.Context())
+			ctx := context.WithValue(r.Context(), entitlementsContextKey, entitlements)
+
+			limit := entitlements.Limit(metric)
+			if limit == Unlimited {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			used, err := CurrentUsage(organization.Id, metric)
+			if err != nil {
+				log.Printf("⚠️ Failed to check %s quota: %v", metric, err)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			info := usageInfo(metric, used, limit)
+			w.Header().Set("X-Quota-Limit", strconv.Itoa(limit))
+			w.Header().Set("X-Quota-Used", strconv.FormatInt(used, 10))
+
+			switch {
+			case info.Exceeded && mode == HardQuota:
+				quotaExceeded(w, r, info)
+				return
+			case info.Exceeded:
+				w.Header().Set("X-Quota-Warning", "exceeded")
+			case info.Warning:
+				w.Header().Set("X-Quota-Warning", "approaching")
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// MeterAPICalls counts each request against the active organization's API call usage.
+// It must run after OrganizationMiddleware.
+func MeterAPICalls(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if organization := GetCurrentOrganization(r); organization != nil {
+			RecordUsage(organization.Id, LimitAPICalls, 1)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UsageHandler returns the active organization's usage in the current period
+func UsageHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	entitlements := Entitlements(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"plan":  entitlements.Plan,
+		"usage": organizationUsage(organization.Id, entitlements),
+	})
+}
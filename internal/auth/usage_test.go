@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestDataExportStorageUsage(t *testing.T) {
+	app := newTestApp(t)
+	user := createTestUser(t, "storage@example.com")
+	// Storage counts against the personal workspace created with the user, not organizations created later
+	memberships, err := listMemberships(user.Id)
+	if err != nil || len(memberships) != 1 {
+		t.Fatalf("expected the new user's personal workspace, got %d memberships: %v", len(memberships), err)
+	}
+	workspace := memberships[0].ExpandedOne("organization")
+	if _, err := createOrganization(app, user, "Side Project"); err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := app.FindCollectionByNameOrId("data_exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := core.NewRecord(collection)
+	export.Set("user", user.Id)
+	export.Set("status", exportPending)
+	if err := app.Save(export); err != nil {
+		t.Fatal(err)
+	}
+
+	buildDataExport(export)
+
+	export, err = app.FindRecordById("data_exports", export.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := storedFileSize(export, "archive")
+	if export.GetString("status") != exportReady || size == 0 {
+		t.Fatalf("export wasn't built: status %q, archive size %d", export.GetString("status"), size)
+	}
+
+	if used, _ := CurrentUsage(workspace.Id, LimitStorageBytes); used != size {
+		t.Fatalf("storage usage %d after the export, want the archive size %d", used, size)
+	}
+
+	// Expired archives are deleted and stop counting
+	export.Set("expires", time.Now().Add(-time.Minute))
+	if err := app.Save(export); err != nil {
+		t.Fatal(err)
+	}
+	processDataExports()
+
+	if used, _ := CurrentUsage(workspace.Id, LimitStorageBytes); used != 0 {
+		t.Fatalf("storage usage %d after the export expired, want 0", used)
+	}
+}
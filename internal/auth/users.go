@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ErrUserExists is returned when creating a user with an email that is already taken
+var ErrUserExists = errors.New("a user with this email already exists")
+
+// ErrUserNotFound is returned when no user has the given email
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrDeletionBlocked is returned when deleting a user would delete an organization
+// that still has an active subscription
+var ErrDeletionBlocked = errDeletionBlocked
+
+// checkPasswordPolicy turns broken password rules into an error
+func checkPasswordPolicy(password string, email string) error {
+	violations := PasswordRules.Validate(password, email)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Errorf("password does not meet the requirements: %s", strings.Join(messages, "; "))
+}
+
+// findUserByEmail returns the user with the given email
+func findUserByEmail(email string) (*core.Record, error) {
+	user, err := PbClient.FindAuthRecordByEmail("users", email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// CreateUser creates a user without going through registration, e.g. from the command line.
+// The password policy still applies, but no verification email is sent.
+func CreateUser(email string, password string, name string, verified bool) (*core.Record, error) {
+	if _, err := PbClient.FindAuthRecordByEmail("users", email); err == nil {
+		return nil, ErrUserExists
+	}
+	if err := checkPasswordPolicy(password, email); err != nil {
+		return nil, err
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("users")
+	if err != nil {
+		return nil, err
+	}
+
+	user := core.NewRecord(collection)
+	user.SetEmail(email)
+	user.SetPassword(password)
+	user.SetVerified(verified)
+	user.Set("name", name)
+	if err := saveNewUser(user); err != nil {
+		return nil, err
+	}
+
+	RecordAuditEvent(nil, AuditEvent{
+		Action:  AuditRegister,
+		Actor:   user,
+		Target:  "user:" + user.Id,
+		Details: map[string]any{"source": "cli"},
+	})
+
+	return user, nil
+}
+
+// SetUserPassword replaces a user's password, signs them out everywhere and lifts
+// their login lockout
+func SetUserPassword(email string, password string) error {
+	user, err := findUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if err := checkPasswordPolicy(password, user.Email()); err != nil {
+		return err
+	}
+
+	user.SetPassword(password)
+	if err := PbClient.Save(user); err != nil {
+		return err
+	}
+
+	if _, err := revokeUserSessions(user.Id, ""); err != nil {
+		log.Printf("⚠️ Failed to revoke sessions after password change: %v", err)
+	}
+	clearLoginFailures(user.Email())
+
+	RecordAuditEvent(nil, AuditEvent{
+		Action:  AuditPasswordReset,
+		Target:  "user:" + user.Id,
+		Details: map[string]any{"source": "cli"},
+	})
+
+	return nil
+}
+
+// DeleteUser deletes a user right away, skipping the grace period of self-service
+// deletions. It fails with ErrDeletionBlocked like the scheduled deletion does.
+func DeleteUser(email string) error {
+	user, err := findUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	promoted, err := deleteAccount(user)
+	if err != nil {
+		return err
+	}
+
+	auditAccountDeletion(user, promoted, "cli")
+
+	return nil
+}
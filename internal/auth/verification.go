@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// When enabled, AuthMiddleware redirects unverified users to the verification page
+var RequireVerifiedEmail = false
+
+// VerifyEmailForm represents the email verification page data
+type VerifyEmailForm struct {
+	Email   string `json:"email"`
+	Error   string `json:"error,omitempty"`
+	Success string `json:"success,omitempty"`
+}
+
+// sendVerificationEmail emails a verification link to the user
+func sendVerificationEmail(record *core.Record) error {
+	token, err := record.NewVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	verifyLink := fmt.Sprintf("%s/auth/verify?token=%s", AppURL, url.QueryEscape(token))
+
+	return sendEmail(record.Email(), "Verify your email", "verification.html", EmailData{
+		Link: verifyLink,
+	})
+}
+
+// VerifyEmailHandler confirms a verification token or shows the "verify your email" page
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// Make sure PocketBase client is initialized
+	if PbClient == nil {
+		renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+			Error: "Verification system not available",
+		})
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		// No token, show the interstitial for the current user (if any)
+		data := VerifyEmailForm{}
+		if user := GetCurrentUser(r); user != nil {
+			if user.Verified() {
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			data.Email = user.Email()
+		}
+		renderTemplate(w, r, "verify_email.html", data)
+		return
+	}
+
+	// Resolve the user from the signed verification token
+	record, err := PbClient.FindAuthRecordByToken(token, core.TokenTypeVerification)
+	if err != nil {
+		renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+			Error: "Invalid or expired verification link. Please request a new one.",
+		})
+		return
+	}
+
+	// Make sure the token was issued for the user's current email
+	claims, _ := security.ParseUnverifiedJWT(token)
+	if email, _ := claims[core.TokenClaimEmail].(string); email != record.Email() {
+		renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+			Email: record.Email(),
+			Error: "This verification link was issued for a different email address. Please request a new one.",
+		})
+		return
+	}
+
+	if !record.Verified() {
+		record.SetVerified(true)
+		if err := PbClient.Save(record); err != nil {
+			renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+				Email: record.Email(),
+				Error: "Failed to verify email: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// Logged in users go straight to the dashboard
+	if user := GetCurrentUser(r); user != nil && user.Id == record.Id {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/auth/login?verified=true", http.StatusSeeOther)
+}
+
+// ResendVerificationHandler sends a new verification email
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	// Process form submission
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	// Make sure PocketBase client is initialized
+	if PbClient == nil {
+		renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+			Error: "Verification system not available",
+		})
+		return
+	}
+
+	// Prefer the logged in user, fall back to the submitted email
+	currentUser := GetCurrentUser(r)
+	record := currentUser
+	email := ""
+	if record != nil {
+		email = record.Email()
+	} else {
+		email = r.FormValue("email")
+		if email == "" {
+			renderTemplate(w, r, "verify_email.html", VerifyEmailForm{
+				Error: "Email is required",
+			})
+			return
+		}
+		record, _ = PbClient.FindAuthRecordByEmail("users", email)
+	}
+
+	// Don't reveal whether the email exists, is already verified or was throttled.
+	// Unknown addresses are counted too so the throttle doesn't tell them apart.
+	if allowEmailSend(r, emailVerification, email) && record != nil && !record.Verified() {
+		if err := sendVerificationEmail(record); err != nil {
+			log.Printf("⚠️ Failed to send verification email: %v", err)
+		}
+	}
+
+	data := VerifyEmailForm{
+		Success: "If the account exists and is not verified yet, a new verification email has been sent.",
+	}
+	if currentUser != nil {
+		data.Email = currentUser.Email()
+	}
+	renderTemplate(w, r, "verify_email.html", data)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResendVerificationThrottled(t *testing.T) {
+	app := newTestApp(t)
+	capture := newSMTPCapture(t, app)
+	if _, err := CreateUser("unverified@example.com", testPassword, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	resend := func(email string, ip string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/auth/verify/resend", strings.NewReader(url.Values{"email": {email}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = ip + ":4000"
+		w := httptest.NewRecorder()
+		ResendVerificationHandler(w, r)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "a new verification email has been sent") {
+			t.Fatalf("unexpected resend response for %s, %d: %s", email, w.Code, w.Body.String())
+		}
+	}
+
+	// Known, unknown and throttled addresses all get the same answer
+	for i := 0; i < emailSendLimit+2; i++ {
+		resend("unverified@example.com", "198.51.100.1")
+		resend("nobody@example.com", "198.51.100.2")
+	}
+
+	for i := 0; i < emailSendLimit; i++ {
+		capture.waitForEmail(t, "unverified@example.com")
+	}
+	if capture.count() != 0 {
+		t.Fatalf("%d verification emails sent past the limit", capture.count())
+	}
+
+	// The same IP can't spread its requests over many addresses either
+	for i := 0; i < emailSendIPLimit; i++ {
+		resend(fmt.Sprintf("spread%d@example.com", i), "198.51.100.3")
+	}
+	if _, err := CreateUser("another@example.com", testPassword, "", false); err != nil {
+		t.Fatal(err)
+	}
+	resend("another@example.com", "198.51.100.3")
+	if capture.count() != 0 {
+		t.Fatal("verification email sent from an IP past its limit")
+	}
+}
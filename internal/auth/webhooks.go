@@ -0,0 +1,784 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Events sent to webhook endpoints
+const (
+	WebhookMemberJoined        = "member.joined"
+	WebhookMemberRemoved       = "member.removed"
+	WebhookSubscriptionCreated = "subscription.created"
+	WebhookSubscriptionUpdated = "subscription.updated"
+	WebhookPing                = "ping"
+)
+
+// Events endpoints can subscribe to
+var WebhookEvents = []string{
+	WebhookMemberJoined,
+	WebhookMemberRemoved,
+	WebhookSubscriptionCreated,
+	WebhookSubscriptionUpdated,
+}
+
+// Delivery statuses
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryDead      = "dead"
+)
+
+// Attempts made before a delivery is dead-lettered
+const webhookMaxAttempts = 8
+
+// Delay before the first retry, doubled after every failed attempt
+const webhookRetryDelay = 30 * time.Second
+
+// Longest delay between retries
+const webhookMaxRetryDelay = 6 * time.Hour
+
+// How often the queue is checked for due deliveries
+const webhookPollInterval = 5 * time.Second
+
+// Deliveries sent at the same time
+const webhookWorkers = 4
+
+// Response body bytes kept in the delivery log
+const webhookResponseLimit = 1024
+
+// WebhookAllowPrivateNetworks lets endpoints point to loopback, private and link-local
+// addresses, e.g. a receiver on localhost during development. Otherwise endpoints are
+// limited to public addresses so they can't be used to reach internal services.
+var WebhookAllowPrivateNetworks = false
+
+var errWebhookPrivateAddress = errors.New("webhook endpoints must not point to a private or local network address")
+
+// WebhookClient sends webhook requests. It refuses to connect to private addresses
+// whatever the endpoint's host resolves to at the time, and doesn't follow redirects.
+var WebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		// Connect directly, as the address check would otherwise apply to the proxy
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: checkWebhookDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	// A redirect counts as a failed delivery instead of being followed
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Wakes the worker up when a delivery is queued
+var webhookWake = make(chan struct{}, 1)
+
+// WebhookEndpointInfo represents a webhook endpoint
+type WebhookEndpointInfo struct {
+	Id          string    `json:"id"`
+	URL         string    `json:"url"`
+	Description string    `json:"description"`
+	Events      []string  `json:"events"`
+	Secret      string    `json:"secret,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// WebhookDeliveryInfo represents one delivery of an event to an endpoint
+type WebhookDeliveryInfo struct {
+	Id             string    `json:"id"`
+	Endpoint       string    `json:"endpoint"`
+	EndpointURL    string    `json:"endpointUrl"`
+	Event          string    `json:"event"`
+	EventId        string    `json:"eventId"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseStatus int       `json:"responseStatus,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	NextAttempt    time.Time `json:"nextAttempt,omitempty"`
+	Created        time.Time `json:"created"`
+}
+
+// WebhooksData represents the data for the webhooks page
+type WebhooksData struct {
+	Organization string                `json:"organization"`
+	Endpoints    []WebhookEndpointInfo `json:"endpoints"`
+	Deliveries   []WebhookDeliveryInfo `json:"deliveries"`
+	Events       []string              `json:"events"`
+	URL          string                `json:"-"`
+	Description  string                `json:"-"`
+	Error        string                `json:"error,omitempty"`
+	Success      string                `json:"success,omitempty"`
+}
+
+// webhookPayload is the JSON body sent to endpoints
+type webhookPayload struct {
+	Id           string         `json:"id"`
+	Type         string         `json:"type"`
+	Created      time.Time      `json:"created"`
+	Organization string         `json:"organization"`
+	Data         map[string]any `json:"data"`
+}
+
+// isPrivateWebhookIP reports whether webhooks must not be sent to an address
+func isPrivateWebhookIP(ip net.IP) bool {
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified()
+}
+
+// checkWebhookDial rejects connections to private addresses, after DNS resolution
+// so a host can't pass validation and later resolve to an internal address
+func checkWebhookDial(network string, address string, c syscall.RawConn) error {
+	if WebhookAllowPrivateNetworks {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateWebhookIP(ip) {
+		return errWebhookPrivateAddress
+	}
+
+	return nil
+}
+
+// validateWebhookURL checks that an endpoint URL is an http(s) URL whose host resolves
+// to public addresses only
+func validateWebhookURL(ctx context.Context, endpointURL string) error {
+	parsed, err := url.Parse(endpointURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return errors.New("Enter a valid http:// or https:// URL")
+	}
+	if WebhookAllowPrivateNetworks {
+		return nil
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateWebhookIP(ip) {
+			return errors.New("The URL must not point to a private or local network address")
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addresses) == 0 {
+		return errors.New("The URL's host could not be resolved")
+	}
+	for _, address := range addresses {
+		if isPrivateWebhookIP(address.IP) {
+			return errors.New("The URL must not point to a private or local network address")
+		}
+	}
+
+	return nil
+}
+
+// webhookRetryAt returns when a delivery that failed its nth attempt is retried
+func webhookRetryAt(attempts int, now time.Time) time.Time {
+	delay := webhookRetryDelay
+	for i := 1; i < attempts && delay < webhookMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return now.Add(min(delay, webhookMaxRetryDelay))
+}
+
+// endpointInfo converts a webhook endpoint record
+func endpointInfo(endpoint *core.Record, withSecret bool) WebhookEndpointInfo {
+	info := WebhookEndpointInfo{
+		Id:          endpoint.Id,
+		URL:         endpoint.GetString("url"),
+		Description: endpoint.GetString("description"),
+		Events:      endpoint.GetStringSlice("events"),
+		Created:     endpoint.GetDateTime("created").Time(),
+	}
+	if withSecret {
+		info.Secret = endpoint.GetString("secret")
+	}
+	return info
+}
+
+// deliveryInfo converts a webhook delivery record
+func deliveryInfo(delivery *core.Record, endpointURL string) WebhookDeliveryInfo {
+	return WebhookDeliveryInfo{
+		Id:             delivery.Id,
+		Endpoint:       delivery.GetString("endpoint"),
+		EndpointURL:    endpointURL,
+		Event:          delivery.GetString("event"),
+		EventId:        delivery.GetString("eventId"),
+		Status:         delivery.GetString("status"),
+		Attempts:       delivery.GetInt("attempts"),
+		ResponseStatus: delivery.GetInt("responseStatus"),
+		Error:          delivery.GetString("error"),
+		NextAttempt:    delivery.GetDateTime("nextAttempt").Time(),
+		Created:        delivery.GetDateTime("created").Time(),
+	}
+}
+
+// subscribesTo reports whether an endpoint receives an event. Endpoints without
+// a list of events receive all of them.
+func subscribesTo(endpoint *core.Record, event string) bool {
+	events := endpoint.GetStringSlice("events")
+	if len(events) == 0 {
+		return true
+	}
+	for _, subscribed := range events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// queueDelivery stores a delivery of a payload to an endpoint, to be sent by the worker
+func queueDelivery(app core.App, endpoint *core.Record, eventType string, eventId string, payload []byte) (*core.Record, error) {
+	collection, err := app.FindCollectionByNameOrId("webhook_deliveries")
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := core.NewRecord(collection)
+	delivery.Set("organization", endpoint.GetString("organization"))
+	delivery.Set("endpoint", endpoint.Id)
+	delivery.Set("event", eventType)
+	delivery.Set("eventId", eventId)
+	delivery.Set("payload", string(payload))
+	delivery.Set("status", deliveryPending)
+	delivery.Set("nextAttempt", time.Now())
+
+	if err := app.Save(delivery); err != nil {
+		return nil, err
+	}
+
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+
+	return delivery, nil
+}
+
+// DispatchWebhook queues an event for every endpoint of the organization subscribed to it.
+// Organizations whose plan doesn't include webhooks are skipped.
+func DispatchWebhook(app core.App, organizationId string, eventType string, data map[string]any) error {
+	endpoints, err := app.FindAllRecords("webhook_endpoints", dbx.HashExp{"organization": organizationId})
+	if err != nil || len(endpoints) == 0 {
+		return err
+	}
+
+	if !organizationEntitlements(organizationId).Has(FeatureWebhooks) {
+		return nil
+	}
+
+	payload := webhookPayload{
+		Id:           "evt_" + security.RandomStringWithAlphabet(24, "abcdefghijklmnopqrstuvwxyz0123456789"),
+		Type:         eventType,
+		Created:      time.Now().UTC(),
+		Organization: organizationId,
+		Data:         data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, endpoint := range endpoints {
+		if !subscribesTo(endpoint, eventType) {
+			continue
+		}
+		if _, err := queueDelivery(app, endpoint, eventType, payload.Id, body); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendDelivery makes one attempt at a delivery and records the outcome, retrying failed
+// attempts with exponential backoff until the delivery is dead-lettered
+func sendDelivery(delivery *core.Record) {
+	attempts := delivery.GetInt("attempts") + 1
+	delivery.Set("attempts", attempts)
+	delivery.Set("lastAttempt", time.Now())
+
+	status, body, err := postWebhook(delivery)
+	delivery.Set("responseStatus", status)
+	delivery.Set("responseBody", body)
+
+	switch {
+	case err == nil && status >= 200 && status < 300:
+		delivery.Set("status", deliverySucceeded)
+		delivery.Set("error", "")
+		delivery.Set("nextAttempt", nil)
+	default:
+		if err == nil {
+			err = fmt.Errorf("endpoint responded with %d", status)
+		}
+		delivery.Set("error", err.Error())
+
+		if attempts >= webhookMaxAttempts {
+			delivery.Set("status", deliveryDead)
+			delivery.Set("nextAttempt", nil)
+			log.Printf("⚠️ Webhook delivery %s dead-lettered after %d attempts: %v", delivery.Id, attempts, err)
+		} else {
+			delivery.Set("nextAttempt", webhookRetryAt(attempts, time.Now()))
+		}
+	}
+
+	if err := PbClient.Save(delivery); err != nil {
+		log.Printf("⚠️ Failed to update webhook delivery %s: %v", delivery.Id, err)
+	}
+}
+
+// postWebhook signs and posts a delivery's payload to its endpoint
+func postWebhook(delivery *core.Record) (int, string, error) {
+	endpoint, err := PbClient.FindRecordById("webhook_endpoints", delivery.GetString("endpoint"))
+	if err != nil {
+		return 0, "", errors.New("endpoint not found")
+	}
+
+	payload := []byte(delivery.GetString("payload"))
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.GetString("url"), bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-saas-template-webhooks/1.0")
+	req.Header.Set("Webhook-Id", delivery.GetString("eventId"))
+	req.Header.Set("Webhook-Event", delivery.GetString("event"))
+	req.Header.Set("Webhook-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, signStripePayload(endpoint.GetString("secret"), timestamp, payload)))
+
+	resp, err := WebhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	return resp.StatusCode, string(body), nil
+}
+
+// deliverDueWebhooks sends every delivery whose next attempt is due
+func deliverDueWebhooks() {
+	for {
+		due, err := PbClient.FindRecordsByFilter(
+			"webhook_deliveries",
+			"status = {:status} && nextAttempt <= {:now}",
+			"nextAttempt",
+			50,
+			0,
+			dbx.Params{"status": deliveryPending, "now": types.NowDateTime().String()},
+		)
+		if err != nil {
+			log.Printf("⚠️ Failed to load webhook deliveries: %v", err)
+			return
+		}
+
+		queue := make(chan *core.Record)
+		var wg sync.WaitGroup
+		for range min(webhookWorkers, len(due)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for delivery := range queue {
+					sendDelivery(delivery)
+				}
+			}()
+		}
+		for _, delivery := range due {
+			queue <- delivery
+		}
+		close(queue)
+		wg.Wait()
+
+		if len(due) < 50 {
+			return
+		}
+	}
+}
+
+// StartWebhookWorker sends queued webhook deliveries until the context is canceled.
+// Deliveries are stored, so pending retries survive restarts.
+func StartWebhookWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-webhookWake:
+			}
+			deliverDueWebhooks()
+		}
+	}()
+}
+
+// RegisterWebhookHooks sends webhooks when members join or leave and subscriptions change
+func RegisterWebhookHooks(app core.App) {
+	member := func(eventType string) func(e *core.RecordEvent) error {
+		return func(e *core.RecordEvent) error {
+			data := map[string]any{
+				"id":   e.Record.Id,
+				"user": e.Record.GetString("user"),
+				"role": e.Record.GetString("role"),
+			}
+			if user, err := e.App.FindRecordById("users", e.Record.GetString("user")); err == nil {
+				data["email"] = user.Email()
+			}
+
+			if err := DispatchWebhook(e.App, e.Record.GetString("organization"), eventType, data); err != nil {
+				log.Printf("⚠️ Failed to queue %s webhook: %v", eventType, err)
+			}
+			return e.Next()
+		}
+	}
+
+	subscription := func(eventType string) func(e *core.RecordEvent) error {
+		return func(e *core.RecordEvent) error {
+			data := map[string]any{
+				"id":                e.Record.Id,
+				"status":            e.Record.GetString("status"),
+				"cancelAtPeriodEnd": e.Record.GetBool("cancelAtPeriodEnd"),
+			}
+			if plan, err := e.App.FindRecordById("plans", e.Record.GetString("plan")); err == nil {
+				data["plan"] = plan.GetString("code")
+			}
+			if end := e.Record.GetDateTime("currentPeriodEnd"); !end.IsZero() {
+				data["currentPeriodEnd"] = end.Time()
+			}
+
+			if err := DispatchWebhook(e.App, e.Record.GetString("organization"), eventType, data); err != nil {
+				log.Printf("⚠️ Failed to queue %s webhook: %v", eventType, err)
+			}
+			return e.Next()
+		}
+	}
+
+	app.OnRecordAfterCreateSuccess("memberships").BindFunc(member(WebhookMemberJoined))
+	app.OnRecordAfterDeleteSuccess("memberships").BindFunc(member(WebhookMemberRemoved))
+	app.OnRecordAfterCreateSuccess("subscriptions").BindFunc(subscription(WebhookSubscriptionCreated))
+	app.OnRecordAfterUpdateSuccess("subscriptions").BindFunc(subscription(WebhookSubscriptionUpdated))
+}
+
+// listWebhookEndpoints returns the endpoints of an organization
+func listWebhookEndpoints(organizationId string) ([]*core.Record, error) {
+	return PbClient.FindRecordsByFilter(
+		"webhook_endpoints",
+		"organization = {:organization}",
+		"created",
+		0,
+		0,
+		dbx.Params{"organization": organizationId},
+	)
+}
+
+// renderWebhooks responds with the webhooks page or its JSON for API clients
+func renderWebhooks(w http.ResponseWriter, r *http.Request, data WebhooksData) {
+	organization := GetCurrentOrganization(r)
+
+	data.Organization = organization.GetString("name")
+	data.Events = WebhookEvents
+	data.Endpoints = []WebhookEndpointInfo{}
+	data.Deliveries = []WebhookDeliveryInfo{}
+
+	urls := map[string]string{}
+	if endpoints, err := listWebhookEndpoints(organization.Id); err == nil {
+		for _, endpoint := range endpoints {
+			data.Endpoints = append(data.Endpoints, endpointInfo(endpoint, !isAPIRequest(r)))
+			urls[endpoint.Id] = endpoint.GetString("url")
+		}
+	}
+
+	filter := "organization = {:organization}"
+	if endpoint := r.URL.Query().Get("endpoint"); endpoint != "" {
+		filter += " && endpoint = {:endpoint}"
+	}
+	deliveries, err := PbClient.FindRecordsByFilter(
+		"webhook_deliveries",
+		filter,
+		"-created",
+		50,
+		0,
+		dbx.Params{"organization": organization.Id, "endpoint": r.URL.Query().Get("endpoint")},
+	)
+	if err == nil {
+		for _, delivery := range deliveries {
+			data.Deliveries = append(data.Deliveries, deliveryInfo(delivery, urls[delivery.GetString("endpoint")]))
+		}
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if data.Error != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(data)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := renderTemplate(w, r, "webhooks.html", data); err != nil {
+		http.Error(w, "Error rendering webhooks page: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// findOrganizationWebhookRecord looks up an endpoint or delivery of the active organization
+func findOrganizationWebhookRecord(r *http.Request, collection string, id string) (*core.Record, error) {
+	record, err := PbClient.FindRecordById(collection, id)
+	if err != nil {
+		return nil, err
+	}
+	if record.GetString("organization") != GetCurrentOrganization(r).Id {
+		return nil, errors.New("not found")
+	}
+	return record, nil
+}
+
+// webhookNotFound responds with a 404 for a missing endpoint or delivery
+func webhookNotFound(w http.ResponseWriter, r *http.Request, message string) {
+	if isAPIRequest(r) {
+		writeJSONError(w, http.StatusNotFound, message)
+		return
+	}
+	renderWebhooks(w, r, WebhooksData{Error: message})
+}
+
+// WebhooksHandler lists the active organization's webhook endpoints and recent deliveries
+func WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentOrganization(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	renderWebhooks(w, r, WebhooksData{})
+}
+
+// CreateWebhookHandler adds a webhook endpoint to the active organization
+func CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	endpointURL := strings.TrimSpace(r.FormValue("url"))
+	description := strings.TrimSpace(r.FormValue("description"))
+	data := WebhooksData{URL: endpointURL, Description: description}
+
+	if err := validateWebhookURL(r.Context(), endpointURL); err != nil {
+		data.Error = err.Error()
+		renderWebhooks(w, r, data)
+		return
+	}
+
+	events := []string{}
+	for _, event := range r.Form["events"] {
+		for _, known := range WebhookEvents {
+			if event == known {
+				events = append(events, event)
+			}
+		}
+	}
+
+	collection, err := PbClient.FindCollectionByNameOrId("webhook_endpoints")
+	if err != nil {
+		data.Error = "Webhooks are not configured correctly"
+		renderWebhooks(w, r, data)
+		return
+	}
+
+	endpoint := core.NewRecord(collection)
+	endpoint.Set("organization", organization.Id)
+	endpoint.Set("url", endpointURL)
+	endpoint.Set("description", description)
+	endpoint.Set("events", events)
+	endpoint.Set("secret", "whsec_"+security.RandomString(32))
+
+	if err := PbClient.Save(endpoint); err != nil {
+		data.Error = "Failed to add endpoint: " + err.Error()
+		renderWebhooks(w, r, data)
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditWebhookCreate,
+		Organization: organization.Id,
+		Target:       "webhook:" + endpoint.Id,
+		Details:      map[string]any{"url": endpointURL, "events": events},
+	})
+
+	// The secret is only returned to API clients when the endpoint is created
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(endpointInfo(endpoint, true))
+		return
+	}
+
+	renderWebhooks(w, r, WebhooksData{Success: "Endpoint added. Verify requests with its signing secret."})
+}
+
+// DeleteWebhookHandler removes a webhook endpoint and its delivery history
+func DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentOrganization(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	endpoint, err := findOrganizationWebhookRecord(r, "webhook_endpoints", mux.Vars(r)["id"])
+	if err == nil {
+		err = PbClient.Delete(endpoint)
+	}
+	if err != nil {
+		webhookNotFound(w, r, "Endpoint not found")
+		return
+	}
+
+	RecordAuditEvent(r, AuditEvent{
+		Action:       AuditWebhookDelete,
+		Organization: endpoint.GetString("organization"),
+		Target:       "webhook:" + endpoint.Id,
+		Details:      map[string]any{"url": endpoint.GetString("url")},
+	})
+
+	if isAPIRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	renderWebhooks(w, r, WebhooksData{Success: "Endpoint removed."})
+}
+
+// PingWebhookHandler sends a test ping event to an endpoint
+func PingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	organization := GetCurrentOrganization(r)
+	if organization == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	endpoint, err := findOrganizationWebhookRecord(r, "webhook_endpoints", mux.Vars(r)["id"])
+	if err != nil {
+		webhookNotFound(w, r, "Endpoint not found")
+		return
+	}
+
+	payload := webhookPayload{
+		Id:           "evt_" + security.RandomStringWithAlphabet(24, "abcdefghijklmnopqrstuvwxyz0123456789"),
+		Type:         WebhookPing,
+		Created:      time.Now().UTC(),
+		Organization: organization.Id,
+		Data:         map[string]any{"endpoint": endpoint.Id},
+	}
+	body, _ := json.Marshal(payload)
+
+	delivery, err := queueDelivery(PbClient, endpoint, WebhookPing, payload.Id, body)
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to send ping")
+			return
+		}
+		renderWebhooks(w, r, WebhooksData{Error: "Failed to send ping"})
+		return
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(deliveryInfo(delivery, endpoint.GetString("url")))
+		return
+	}
+
+	renderWebhooks(w, r, WebhooksData{Success: "Ping sent to " + endpoint.GetString("url") + ". Refresh to see the result."})
+}
+
+// RedeliverWebhookHandler queues a past delivery again as a new delivery
+func RedeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if GetCurrentOrganization(r) == nil {
+		unauthorized(w, r, false)
+		return
+	}
+
+	previous, err := findOrganizationWebhookRecord(r, "webhook_deliveries", mux.Vars(r)["id"])
+	var endpoint *core.Record
+	if err == nil {
+		endpoint, err = PbClient.FindRecordById("webhook_endpoints", previous.GetString("endpoint"))
+	}
+	if err != nil {
+		webhookNotFound(w, r, "Delivery not found")
+		return
+	}
+
+	delivery, err := queueDelivery(PbClient, endpoint, previous.GetString("event"), previous.GetString("eventId"), []byte(previous.GetString("payload")))
+	if err != nil {
+		if isAPIRequest(r) {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to redeliver")
+			return
+		}
+		renderWebhooks(w, r, WebhooksData{Error: "Failed to redeliver"})
+		return
+	}
+
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(deliveryInfo(delivery, endpoint.GetString("url")))
+		return
+	}
+
+	renderWebhooks(w, r, WebhooksData{Success: "Event " + previous.GetString("eventId") + " queued for redelivery (attempt history is kept)."})
+}
+
+// RetryLabel formats when a pending delivery is retried
+func (d WebhookDeliveryInfo) RetryLabel() string {
+	if d.Status != deliveryPending || d.NextAttempt.IsZero() {
+		return ""
+	}
+	wait := time.Until(d.NextAttempt).Round(time.Second)
+	if wait <= 0 {
+		return "now"
+	}
+	return "in " + strconv.Itoa(int(wait.Seconds())) + "s"
+}
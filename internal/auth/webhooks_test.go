@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateWebhookURL(t *testing.T) {
+	cases := map[string]bool{
+		"https://93.184.216.34/hooks":              true,
+		"http://[2606:2800:220:1::1]/hooks":        true,
+		"ftp://93.184.216.34/hooks":                false,
+		"https:///hooks":                           false,
+		"http://127.0.0.1:8080/hooks":              false,
+		"http://localhost/hooks":                   false,
+		"http://10.0.0.5/hooks":                    false,
+		"http://192.168.1.10/hooks":                false,
+		"http://172.16.0.1/hooks":                  false,
+		"http://169.254.169.254/latest/meta-data/": false,
+		"http://0.0.0.0/hooks":                     false,
+		"http://[::1]/hooks":                       false,
+		"http://[fe80::1]/hooks":                   false,
+		"http://[fd00::1]/hooks":                   false,
+		"http://[::ffff:127.0.0.1]/hooks":          false,
+	}
+
+	for endpointURL, valid := range cases {
+		err := validateWebhookURL(context.Background(), endpointURL)
+		if valid && err != nil {
+			t.Errorf("%s was rejected: %v", endpointURL, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s was accepted", endpointURL)
+		}
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	// Endpoints validated earlier may resolve to a local address by the time they're called
+	resp, err := WebhookClient.Post(receiver.URL, "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("webhook client connected to a loopback address")
+	}
+	if !errors.Is(err, errWebhookPrivateAddress) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	WebhookAllowPrivateNetworks = true
+	t.Cleanup(func() { WebhookAllowPrivateNetworks = false })
+
+	followed := false
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	}))
+	defer receiver.Close()
+
+	resp, err := WebhookClient.Post(receiver.URL+"/hooks", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if followed || resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("redirect was followed (status %d)", resp.StatusCode)
+	}
+}
@@ -0,0 +1,10 @@
+// Package templates embeds the page templates and, under emails/, the email templates,
+// so the server and the tests don't depend on the working directory
+package templates
+
+import "embed"
+
+// FS holds the templates
+//
+//go:embed *.html emails/*.html
+var FS embed.FS
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Brings the users collection to the shape the app relies on: a display name, an
+// avatar with the thumbnails used by the navbar and profile page, and account
+// changes going through the app instead of the record API
+func init() {
+	m.Register(func(app core.App) error {
+		// PocketBase creates the users collection on its first start, unless it was deleted since
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			users = core.NewAuthCollection("users")
+			users.ListRule = types.Pointer("id = @request.auth.id")
+			users.ViewRule = types.Pointer("id = @request.auth.id")
+		}
+
+		name, ok := users.Fields.GetByName("name").(*core.TextField)
+		if !ok {
+			name = &core.TextField{Name: "name"}
+			users.Fields.Add(name)
+		}
+		name.Max = 255
+
+		avatar, ok := users.Fields.GetByName("avatar").(*core.FileField)
+		if !ok {
+			avatar = &core.FileField{Name: "avatar", MaxSelect: 1}
+			users.Fields.Add(avatar)
+		}
+		avatar.MimeTypes = []string{"image/jpeg", "image/png", "image/svg+xml", "image/gif", "image/webp"}
+		avatar.Thumbs = []string{"40x40", "100x100"}
+		avatar.MaxSize = 5 << 20
+
+		if users.Fields.GetByName("created") == nil {
+			users.Fields.Add(
+				&core.AutodateField{Name: "created", OnCreate: true},
+				&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+			)
+		}
+
+		// Creating, updating and deleting users through the record API is superuser-only
+		users.CreateRule = nil
+		users.UpdateRule = nil
+		users.DeleteRule = nil
+
+		users.OAuth2.MappedFields.Name = "name"
+		users.OAuth2.MappedFields.AvatarURL = "avatar"
+
+		return app.Save(users)
+	}, func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		// Restore PocketBase's default rules and avatar settings, keeping the fields
+		ownerRule := "id = @request.auth.id"
+		users.CreateRule = types.Pointer("")
+		users.UpdateRule = types.Pointer(ownerRule)
+		users.DeleteRule = types.Pointer(ownerRule)
+
+		if avatar, ok := users.Fields.GetByName("avatar").(*core.FileField); ok {
+			avatar.Thumbs = nil
+			avatar.MaxSize = 0
+		}
+
+		return app.Save(users)
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the session registry that lets users see and revoke their logins
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Sessions are only managed server-side, so all API rules stay superuser-only
+		sessions := core.NewBaseCollection("sessions")
+		sessions.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.TextField{Name: "tokenHash", Required: true, Hidden: true},
+			&core.TextField{Name: "device"},
+			&core.TextField{Name: "ip"},
+			&core.TextField{Name: "userAgent"},
+			&core.DateField{Name: "lastSeen"},
+			&core.DateField{Name: "expires"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		sessions.AddIndex("idx_sessions_tokenHash", true, "tokenHash", "")
+		sessions.AddIndex("idx_sessions_user", false, "user", "")
+
+		return app.Save(sessions)
+	}, func(app core.App) error {
+		return deleteCollections(app, "sessions")
+	})
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the TOTP factors and recovery codes of two-factor authentication
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		totpFactors := core.NewBaseCollection("totp_factors")
+		totpFactors.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.TextField{Name: "secret", Required: true, Hidden: true},
+			&core.BoolField{Name: "enabled"},
+			&core.NumberField{Name: "lastUsedStep", OnlyInt: true},
+			&core.JSONField{Name: "recoveryCodes", Hidden: true},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		totpFactors.AddIndex("idx_totp_factors_user", true, "user", "")
+
+		return app.Save(totpFactors)
+	}, func(app core.App) error {
+		return deleteCollections(app, "totp_factors")
+	})
+}
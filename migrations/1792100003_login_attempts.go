@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the failed login counters behind account and IP lockouts
+func init() {
+	m.Register(func(app core.App) error {
+		// Failed login counters keyed by "account:<email>", "ip:<address>" or "otp:<login code id>",
+		// and email send counters keyed by "send:<kind>:<email>" or "send-ip:<kind>:<address>"
+		loginAttempts := core.NewBaseCollection("login_attempts")
+		loginAttempts.Fields.Add(
+			&core.TextField{Name: "key", Required: true},
+			&core.NumberField{Name: "failures", OnlyInt: true},
+			&core.DateField{Name: "lastFailure"},
+			&core.DateField{Name: "lockedUntil"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		loginAttempts.AddIndex("idx_login_attempts_key", true, "key", "")
+
+		return app.Save(loginAttempts)
+	}, func(app core.App) error {
+		return deleteCollections(app, "login_attempts")
+	})
+}
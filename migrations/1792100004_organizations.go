@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates organizations with their memberships and pending invitations
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		organizations := core.NewBaseCollection("organizations")
+		organizations.Fields.Add(
+			&core.TextField{Name: "name", Required: true, Max: 100},
+			&core.TextField{Name: "slug", Required: true, Max: 60, Pattern: `^[a-z0-9][a-z0-9-]*$`},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		organizations.AddIndex("idx_organizations_slug", true, "slug", "")
+
+		if err := app.Save(organizations); err != nil {
+			return err
+		}
+
+		memberships := core.NewBaseCollection("memberships")
+		memberships.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.SelectField{Name: "role", Required: true, MaxSelect: 1, Values: []string{"owner", "admin", "member"}},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		memberships.AddIndex("idx_memberships_organization_user", true, "organization, user", "")
+		memberships.AddIndex("idx_memberships_user", false, "user", "")
+
+		if err := app.Save(memberships); err != nil {
+			return err
+		}
+
+		invitations := core.NewBaseCollection("invitations")
+		invitations.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.EmailField{Name: "email", Required: true},
+			&core.SelectField{Name: "role", Required: true, MaxSelect: 1, Values: []string{"admin", "member"}},
+			&core.RelationField{Name: "invitedBy", CollectionId: users.Id, MaxSelect: 1},
+			&core.TextField{Name: "tokenHash", Required: true, Hidden: true},
+			&core.DateField{Name: "expires"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		invitations.AddIndex("idx_invitations_tokenHash", true, "tokenHash", "")
+		invitations.AddIndex("idx_invitations_organization_email", true, "organization, email", "")
+
+		return app.Save(invitations)
+	}, func(app core.App) error {
+		return deleteCollections(app, "invitations", "memberships", "organizations")
+	})
+}
@@ -0,0 +1,82 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the plans, the organizations' subscriptions to them and their invoices.
+// The default plans are seeded separately on every start.
+func init() {
+	m.Register(func(app core.App) error {
+		organizations, err := app.FindCollectionByNameOrId("organizations")
+		if err != nil {
+			return err
+		}
+
+		plans := core.NewBaseCollection("plans")
+		plans.Fields.Add(
+			&core.TextField{Name: "code", Required: true, Max: 40, Pattern: `^[a-z0-9_-]+$`},
+			&core.TextField{Name: "name", Required: true, Max: 100},
+			&core.TextField{Name: "description"},
+			&core.NumberField{Name: "price", OnlyInt: true},
+			&core.TextField{Name: "currency", Required: true, Max: 3},
+			&core.SelectField{Name: "interval", Required: true, MaxSelect: 1, Values: []string{"month", "year"}},
+			&core.TextField{Name: "providerPriceId"},
+			&core.JSONField{Name: "features"},
+			&core.JSONField{Name: "limits"},
+			&core.JSONField{Name: "flags"},
+			&core.BoolField{Name: "active"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		plans.AddIndex("idx_plans_code", true, "code", "")
+
+		if err := app.Save(plans); err != nil {
+			return err
+		}
+
+		subscriptions := core.NewBaseCollection("subscriptions")
+		subscriptions.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.RelationField{Name: "plan", CollectionId: plans.Id, MaxSelect: 1},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"incomplete", "incomplete_expired", "trialing", "active", "past_due", "canceled", "unpaid", "paused"}},
+			&core.TextField{Name: "providerCustomerId"},
+			&core.TextField{Name: "providerSubscriptionId"},
+			&core.DateField{Name: "currentPeriodEnd"},
+			&core.BoolField{Name: "cancelAtPeriodEnd"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		subscriptions.AddIndex("idx_subscriptions_organization", true, "organization", "")
+		subscriptions.AddIndex("idx_subscriptions_providerSubscriptionId", false, "providerSubscriptionId", "")
+		subscriptions.AddIndex("idx_subscriptions_providerCustomerId", false, "providerCustomerId", "")
+
+		if err := app.Save(subscriptions); err != nil {
+			return err
+		}
+
+		invoices := core.NewBaseCollection("invoices")
+		invoices.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.RelationField{Name: "subscription", CollectionId: subscriptions.Id, MaxSelect: 1},
+			&core.TextField{Name: "providerInvoiceId", Required: true},
+			&core.TextField{Name: "number"},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"draft", "open", "paid", "void", "uncollectible"}},
+			&core.NumberField{Name: "amountDue", OnlyInt: true},
+			&core.NumberField{Name: "amountPaid", OnlyInt: true},
+			&core.TextField{Name: "currency", Max: 3},
+			&core.URLField{Name: "hostedUrl"},
+			&core.DateField{Name: "periodStart"},
+			&core.DateField{Name: "periodEnd"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		invoices.AddIndex("idx_invoices_providerInvoiceId", true, "providerInvoiceId", "")
+		invoices.AddIndex("idx_invoices_organization", false, "organization", "")
+
+		return app.Save(invoices)
+	}, func(app core.App) error {
+		return deleteCollections(app, "invoices", "subscriptions", "plans")
+	})
+}
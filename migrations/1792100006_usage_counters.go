@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the usage counters that plan quotas are checked against
+func init() {
+	m.Register(func(app core.App) error {
+		organizations, err := app.FindCollectionByNameOrId("organizations")
+		if err != nil {
+			return err
+		}
+
+		// Aggregated usage per organization, metric and period ("2006-01" or "total")
+		usageCounters := core.NewBaseCollection("usage_counters")
+		usageCounters.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.TextField{Name: "metric", Required: true, Max: 60},
+			&core.TextField{Name: "period", Required: true, Max: 20},
+			&core.NumberField{Name: "value", OnlyInt: true},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		usageCounters.AddIndex("idx_usage_counters_organization_metric_period", true, "organization, metric, period", "")
+
+		return app.Save(usageCounters)
+	}, func(app core.App) error {
+		return deleteCollections(app, "usage_counters")
+	})
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the append-only audit log
+func init() {
+	m.Register(func(app core.App) error {
+		// Ids are stored as plain text so events outlive the users and organizations they mention
+		auditEvents := core.NewBaseCollection("audit_events")
+		auditEvents.Fields.Add(
+			&core.TextField{Name: "organization"},
+			&core.TextField{Name: "action", Required: true, Max: 100},
+			&core.SelectField{Name: "outcome", Required: true, MaxSelect: 1, Values: []string{"success", "failure", "denied"}},
+			&core.TextField{Name: "actor"},
+			&core.TextField{Name: "actorEmail"},
+			&core.TextField{Name: "target"},
+			&core.TextField{Name: "ip"},
+			&core.TextField{Name: "userAgent"},
+			&core.JSONField{Name: "details"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+		)
+		auditEvents.AddIndex("idx_audit_events_organization_created", false, "organization, created", "")
+		auditEvents.AddIndex("idx_audit_events_actor_created", false, "actor, created", "")
+
+		return app.Save(auditEvents)
+	}, func(app core.App) error {
+		return deleteCollections(app, "audit_events")
+	})
+}
@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the organizations' webhook endpoints and their delivery queue
+func init() {
+	m.Register(func(app core.App) error {
+		organizations, err := app.FindCollectionByNameOrId("organizations")
+		if err != nil {
+			return err
+		}
+
+		// An empty events list subscribes the endpoint to every event
+		webhookEndpoints := core.NewBaseCollection("webhook_endpoints")
+		webhookEndpoints.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.URLField{Name: "url", Required: true},
+			&core.TextField{Name: "description", Max: 200},
+			&core.JSONField{Name: "events"},
+			&core.TextField{Name: "secret", Required: true, Hidden: true},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		webhookEndpoints.AddIndex("idx_webhook_endpoints_organization", false, "organization", "")
+
+		if err := app.Save(webhookEndpoints); err != nil {
+			return err
+		}
+
+		// Queued and past deliveries, kept as the delivery log of each endpoint
+		webhookDeliveries := core.NewBaseCollection("webhook_deliveries")
+		webhookDeliveries.Fields.Add(
+			&core.RelationField{Name: "organization", Required: true, CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.RelationField{Name: "endpoint", Required: true, CollectionId: webhookEndpoints.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.TextField{Name: "event", Required: true, Max: 100},
+			&core.TextField{Name: "eventId", Required: true},
+			&core.TextField{Name: "payload", Required: true},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"pending", "succeeded", "dead"}},
+			&core.NumberField{Name: "attempts", OnlyInt: true},
+			&core.NumberField{Name: "responseStatus", OnlyInt: true},
+			&core.TextField{Name: "responseBody"},
+			&core.TextField{Name: "error"},
+			&core.DateField{Name: "lastAttempt"},
+			&core.DateField{Name: "nextAttempt"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		webhookDeliveries.AddIndex("idx_webhook_deliveries_status_nextAttempt", false, "status, nextAttempt", "")
+		webhookDeliveries.AddIndex("idx_webhook_deliveries_organization_created", false, "organization, created", "")
+
+		return app.Save(webhookDeliveries)
+	}, func(app core.App) error {
+		return deleteCollections(app, "webhook_deliveries", "webhook_endpoints")
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates personal access tokens and organization API keys
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+		organizations, err := app.FindCollectionByNameOrId("organizations")
+		if err != nil {
+			return err
+		}
+
+		// Tokens are stored as hashes. Organization keys act as the member who created them.
+		apiTokens := core.NewBaseCollection("api_tokens")
+		apiTokens.Fields.Add(
+			&core.SelectField{Name: "kind", Required: true, MaxSelect: 1, Values: []string{"personal", "organization"}},
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.RelationField{Name: "organization", CollectionId: organizations.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.TextField{Name: "name", Required: true, Max: 100},
+			&core.TextField{Name: "hint", Required: true},
+			&core.TextField{Name: "tokenHash", Required: true, Hidden: true},
+			&core.JSONField{Name: "scopes"},
+			&core.DateField{Name: "expires"},
+			&core.DateField{Name: "lastUsed"},
+			&core.TextField{Name: "lastUsedIp"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		apiTokens.AddIndex("idx_api_tokens_tokenHash", true, "tokenHash", "")
+		apiTokens.AddIndex("idx_api_tokens_user", false, "user", "")
+		apiTokens.AddIndex("idx_api_tokens_organization", false, "organization", "")
+
+		return app.Save(apiTokens)
+	}, func(app core.App) error {
+		return deleteCollections(app, "api_tokens")
+	})
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Creates the self-service data exports and account deletion requests
+func init() {
+	m.Register(func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Downloadable archives of everything stored about a user, built in the background
+		dataExports := core.NewBaseCollection("data_exports")
+		dataExports.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"pending", "ready", "failed"}},
+			&core.FileField{Name: "archive", MaxSelect: 1, MaxSize: 1 << 30, Protected: true},
+			&core.TextField{Name: "error"},
+			&core.DateField{Name: "expires"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		dataExports.AddIndex("idx_data_exports_user", false, "user", "")
+		dataExports.AddIndex("idx_data_exports_status", false, "status", "")
+
+		if err := app.Save(dataExports); err != nil {
+			return err
+		}
+
+		// Account deletion requests, pending until confirmed by email and then
+		// scheduled for the end of the grace period
+		accountDeletions := core.NewBaseCollection("account_deletions")
+		accountDeletions.Fields.Add(
+			&core.RelationField{Name: "user", Required: true, CollectionId: users.Id, CascadeDelete: true, MaxSelect: 1},
+			&core.SelectField{Name: "status", Required: true, MaxSelect: 1, Values: []string{"pending", "scheduled"}},
+			&core.TextField{Name: "tokenHash", Required: true, Hidden: true},
+			&core.DateField{Name: "confirmExpires"},
+			&core.DateField{Name: "scheduledFor"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		accountDeletions.AddIndex("idx_account_deletions_user", true, "user", "")
+		accountDeletions.AddIndex("idx_account_deletions_tokenHash", true, "tokenHash", "")
+		accountDeletions.AddIndex("idx_account_deletions_status_scheduledFor", false, "status, scheduledFor", "")
+
+		return app.Save(accountDeletions)
+	}, func(app core.App) error {
+		return deleteCollections(app, "account_deletions", "data_exports")
+	})
+}
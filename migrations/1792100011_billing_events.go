@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Records processed billing webhook events, so redeliveries are skipped, and when the
+// last event was applied to each subscription and invoice, so late ones are ignored
+func init() {
+	m.Register(func(app core.App) error {
+		billingEvents := core.NewBaseCollection("billing_events")
+		billingEvents.Fields.Add(
+			&core.TextField{Name: "eventId", Required: true},
+			&core.TextField{Name: "type"},
+			&core.AutodateField{Name: "created", OnCreate: true},
+			&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+		)
+		billingEvents.AddIndex("idx_billing_events_eventId", true, "eventId", "")
+
+		if err := app.Save(billingEvents); err != nil {
+			return err
+		}
+
+		for _, name := range []string{"subscriptions", "invoices"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return err
+			}
+			collection.Fields.Add(&core.DateField{Name: "lastEventAt"})
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		for _, name := range []string{"subscriptions", "invoices"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+			collection.Fields.RemoveByName("lastEventAt")
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+
+		return deleteCollections(app, "billing_events")
+	})
+}
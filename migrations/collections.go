@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// deleteCollections deletes the named collections in the given order, skipping ones
+// that were already removed by hand
+func deleteCollections(app core.App, names ...string) error {
+	for _, name := range names {
+		collection, err := app.FindCollectionByNameOrId(name)
+		if err != nil {
+			continue
+		}
+		if err := app.Delete(collection); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collections created by the app's migrations
+var appCollections = []string{
+	"sessions",
+	"totp_factors",
+	"login_attempts",
+	"organizations",
+	"memberships",
+	"invitations",
+	"plans",
+	"subscriptions",
+	"invoices",
+	"usage_counters",
+	"audit_events",
+	"webhook_endpoints",
+	"webhook_deliveries",
+	"api_tokens",
+	"data_exports",
+	"account_deletions",
+	"billing_events",
+}
+
+func newTestApp(t *testing.T) *pocketbase.PocketBase {
+	t.Helper()
+
+	app := pocketbase.NewWithConfig(pocketbase.Config{DefaultDataDir: t.TempDir()})
+	if err := app.Bootstrap(); err != nil {
+		t.Fatalf("failed to bootstrap app: %v", err)
+	}
+	t.Cleanup(func() { app.ResetBootstrapState() })
+
+	return app
+}
+
+func TestMigrationsRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	runner := core.NewMigrationsRunner(app, core.AppMigrations)
+
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	for _, name := range appCollections {
+		if _, err := app.FindCollectionByNameOrId(name); err != nil {
+			t.Errorf("%s wasn't created", name)
+		}
+	}
+
+	// A collection added by hand, e.g. through the dashboard, isn't the migrations' to delete
+	custom := core.NewBaseCollection("projects")
+	if err := app.Save(custom); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := runner.Down(len(core.AppMigrations.Items()))
+	if err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	if len(reverted) != len(core.AppMigrations.Items()) {
+		t.Fatalf("reverted %d of %d migrations", len(reverted), len(core.AppMigrations.Items()))
+	}
+	for _, name := range appCollections {
+		if _, err := app.FindCollectionByNameOrId(name); err == nil {
+			t.Errorf("%s wasn't deleted", name)
+		}
+	}
+	for _, name := range []string{"users", "projects"} {
+		if _, err := app.FindCollectionByNameOrId(name); err != nil {
+			t.Errorf("%s was deleted by a migration that didn't create it", name)
+		}
+	}
+
+	// Reverted migrations apply cleanly again
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("second up failed: %v", err)
+	}
+}
+
+func TestMigrationsRevertOneFeature(t *testing.T) {
+	app := newTestApp(t)
+	runner := core.NewMigrationsRunner(app, core.AppMigrations)
+
+	if _, err := runner.Up(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	// Reverting the last migration only removes the collections it created
+	if _, err := runner.Down(1); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+	for _, name := range appCollections {
+		_, err := app.FindCollectionByNameOrId(name)
+		removed := name == "billing_events"
+		if removed && err == nil {
+			t.Errorf("%s wasn't deleted", name)
+		}
+		if !removed && err != nil {
+			t.Errorf("%s was deleted", name)
+		}
+	}
+
+	// Fields it added to earlier collections are removed as well
+	for _, name := range []string{"subscriptions", "invoices"} {
+		collection, err := app.FindCollectionByNameOrId(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if collection.Fields.GetByName("lastEventAt") != nil {
+			t.Errorf("lastEventAt wasn't removed from %s", name)
+		}
+	}
+}